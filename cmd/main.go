@@ -1,34 +1,88 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mahdiXak47/Download-Manager/internal/cli"
+	"github.com/mahdiXak47/Download-Manager/internal/config"
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
 	"github.com/mahdiXak47/Download-Manager/internal/tui"
 )
 
+// shutdownTimeout bounds how long active downloads get to checkpoint on exit
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	logFile := "download-logs.log"
-	cwd, err := os.Getwd()
-	if err == nil {
-		logFile = filepath.Join(cwd, "download-logs.log")
+	configFile := flag.String("config", "", "settings file (env "+config.EnvConfig+")")
+	dataDir := flag.String("data-dir", "", "directory for download state and history (env "+config.EnvDataDir+")")
+	logFile := flag.String("log-file", "", "log file (env "+config.EnvLogFile+")")
+	profile := flag.String("profile", os.Getenv(config.EnvProfile), "named profile with its own settings and downloads (env "+config.EnvProfile+")")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "Usage: download-manager [flags] [command]")
+		fmt.Fprintln(out, "\nFlags:")
+		flag.PrintDefaults()
+		fmt.Fprintln(out)
+		cli.PrintCommands(out)
+	}
+	flag.Parse()
+
+	config.SetPaths(config.Paths{
+		ConfigFile: *configFile,
+		DataDir:    *dataDir,
+		LogFile:    *logFile,
+	})
+	if err := config.SetProfile(*profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	if err := logger.Initialize(logFile); err != nil {
-		fmt.Printf("Warning: Could not initialize logger: %v\n", err)
+	if err := logger.Initialize(config.GetLogPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not initialize logger: %v\n", err)
 	}
 
-	p := tea.NewProgram(tui.NewModel(),
+	// Run a command instead of the interface if one was given
+	if flag.NArg() > 0 {
+		code := cli.Run(flag.Args(), os.Stdout, os.Stderr)
+		logger.Close()
+		os.Exit(code)
+	}
+
+	model := tui.NewModel()
+	p := tea.NewProgram(model,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
-	if _, err := p.Run(); err != nil {
-		fmt.Printf("Error running program: %v", err)
-		os.Exit(1)
+
+	// Bubble Tea turns SIGINT and SIGTERM into a quit, so all ways out of
+	// the program end up here
+	finalModel, runErr := p.Run()
+
+	// Switching profiles replaces the model, so shut down the one still running
+	if m, ok := finalModel.(tui.Model); ok {
+		model = m
+	}
+
+	// An attached interface leaves the downloads running in the daemon
+	if !model.Attached {
+		fmt.Println("Saving download state...")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := model.Shutdown(ctx); err != nil {
+		fmt.Printf("Warning: Shutdown incomplete: %v\n", err)
 	}
+	cancel()
 
 	logger.Close()
+
+	if runErr != nil && !errors.Is(runErr, tea.ErrInterrupted) {
+		fmt.Printf("Error running program: %v", runErr)
+		os.Exit(1)
+	}
 }
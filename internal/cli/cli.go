@@ -0,0 +1,275 @@
+// Package cli implements the non-interactive subcommands, which work on the
+// same settings and download state as the TUI.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/daemon"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// Exit codes of the commands
+const (
+	ExitOK       = 0 // Success
+	ExitFailure  = 1 // The command failed
+	ExitUsage    = 2 // The command line was invalid
+	ExitNotFound = 3 // A download or queue doesn't exist
+	ExitInvalid  = 4 // The change would leave the settings invalid
+	ExitBusy     = 5 // Another process has the downloads open
+
+	ExitInterrupted = 130 // Stopped by a signal, like a shell reports SIGINT
+)
+
+// shutdownTimeout bounds how long saving the download state may take
+const shutdownTimeout = 10 * time.Second
+
+// command is a subcommand of the program
+type command struct {
+	name    string
+	args    string // Synopsis of the arguments
+	summary string
+	run     func(e *env, args []string) error
+}
+
+// commands lists the subcommands in the order they are shown in the usage
+var commands []command
+
+func init() {
+	commands = []command{
+		{"get", "[-o PATH] [--limit RATE] URL", "Download a single file in the foreground", runGet},
+		{"add", "[--queue NAME] [--globoff] URL...", "Add downloads, expanding [1-10] and {a,b}", runAdd},
+		{"import", "[--queue NAME] [--dry-run] FILE", "Add the URLs in a list, wget or aria2 input file", runImport},
+		{"list", "[--queue NAME] [--status STATUS]", "List downloads", runList},
+		{"pause", "ID...", "Pause downloads", runPause},
+		{"resume", "ID...", "Resume paused downloads", runResume},
+		{"remove", "ID...", "Remove downloads, deleting unfinished files", runRemove},
+		{"retry", "ID...", "Retry failed downloads", runRetry},
+		{"queue", "add|edit|rm|list ...", "Manage queues", runQueue},
+		{"daemon", "[--listen ADDR]", "Run the downloads in the background", runDaemon},
+		{"help", "", "Show this help", runHelp},
+	}
+}
+
+// env is what a command writes to
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+	json   bool // Print results as JSON
+}
+
+// usageError reports a bad command line
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// PrintCommands writes the list of subcommands to w
+func PrintCommands(w io.Writer) {
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %-34s %s\n", c.name, c.args, c.summary)
+	}
+	fmt.Fprintln(w, "\nRun without a command to start the interactive interface, which attaches to")
+	fmt.Fprintln(w, "a running daemon. Every command accepts --json for machine-readable output.")
+}
+
+// Run runs the subcommand named by args[0] and returns the exit code
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		PrintCommands(stderr)
+		return ExitUsage
+	}
+
+	c, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		PrintCommands(stderr)
+		return ExitUsage
+	}
+
+	e := &env{stdout: stdout, stderr: stderr}
+	err := c.run(e, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error: "+err.Error())
+		if errors.Is(err, store.ErrLocked) {
+			fmt.Fprintln(stderr, "Quit the download manager that has this profile open and try again.")
+		}
+	}
+	return exitCode(err)
+}
+
+// exitCode maps an error to the exit code describing it
+func exitCode(err error) int {
+	var usage *usageError
+	var invalid *config.ValidationError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.Is(err, errInterrupted):
+		return ExitInterrupted
+	case errors.Is(err, store.ErrLocked):
+		return ExitBusy
+	case errors.Is(err, queue.ErrNotFound):
+		return ExitNotFound
+	case errors.As(err, &invalid):
+		return ExitInvalid
+	default:
+		return ExitFailure
+	}
+}
+
+// newFlagSet returns a flag set for a command with the common --json flag
+func (e *env) newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.BoolVar(&e.json, "json", false, "print results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: download-manager %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of a command. Flags may follow the arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, &usageError{msg: err.Error()}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// printJSON writes v to stdout as indented JSON
+func (e *env) printJSON(v any) error {
+	enc := json.NewEncoder(e.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// withManager runs fn on the downloads of the active profile. If a daemon
+// serves them, fn works through it and changes take effect right away.
+// Otherwise the downloads are opened here and their state saved afterwards;
+// the manager isn't started, so no transfer runs and queues pick up the
+// changes when the download manager runs next.
+func withManager(fn func(m queue.Service) error) error {
+	var m queue.Service
+	client, err := daemon.Dial(config.GetSocketPath())
+	switch {
+	case err == nil:
+		m = client
+	case errors.Is(err, daemon.ErrNotRunning):
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if m, err = queue.Open(cfg); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	err = fn(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := m.Shutdown(ctx); shutdownErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to save download state: %w", shutdownErr))
+	}
+	return err
+}
+
+func runHelp(e *env, args []string) error {
+	fmt.Fprintln(e.stdout, "Usage: download-manager [flags] [command]")
+	fmt.Fprintln(e.stdout)
+	PrintCommands(e.stdout)
+	return nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024), e.g. "500K" or "1.5M"
+func parseSize(s string) (int64, error) {
+	text := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "B"), "i")
+	multiplier := int64(1)
+	if n := len(text); n > 0 {
+		switch text[n-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			text = text[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%q is not a size, e.g. 500K or 2M", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// toKB converts a rate in bytes per second to the KB/s that speed limits are
+// given in, rounding up so that a small limit doesn't become unlimited
+func toKB(bytesPerSecond int64) int64 {
+	return (bytesPerSecond + 1023) / 1024
+}
+
+// formatSize formats a byte count for humans
+func formatSize(size int64) string {
+	switch {
+	case size < 1<<10:
+		return fmt.Sprintf("%d B", size)
+	case size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	case size < 1<<30:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	default:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/daemon"
+)
+
+func runDaemon(e *env, args []string) error {
+	fs := e.newFlagSet("daemon", "")
+	listen := fs.String("listen", daemon.DefaultListen, "TCP address of the API, \"off\" to serve only the socket")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return usagef("daemon takes no arguments")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := daemon.Options{Listen: *listen}
+	if opts.Listen == "off" {
+		opts.Listen = ""
+	}
+	d, err := daemon.Start(opts)
+	if err != nil {
+		return err
+	}
+
+	if e.json {
+		e.printJSON(map[string]string{
+			"profile": config.GetProfile(),
+			"socket":  d.Socket(),
+			"listen":  d.Addr(),
+			"token":   d.TokenSource(),
+		})
+	} else {
+		fmt.Fprintf(e.stdout, "Serving the downloads of profile %s on %s\n", config.GetProfile(), d.Socket())
+		if d.Addr() != "" {
+			fmt.Fprintf(e.stdout, "Dashboard on http://%s/ and API under /api/v1 (token in %s)\n", d.Addr(), d.TokenSource())
+		}
+		fmt.Fprintln(e.stdout, "Press Ctrl-C to stop; active downloads resume when the daemon runs again.")
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-d.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := d.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to save download state: %w", err)
+	}
+	return serveErr
+}
@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/daemon"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+	"github.com/mahdiXak47/Download-Manager/internal/urlglob"
+)
+
+func runAdd(e *env, args []string) error {
+	fs := e.newFlagSet("add", "[--queue NAME] [--globoff] [--max-urls N] URL...")
+	queueName := fs.String("queue", "", "queue to add to (default: the default queue)")
+	globoff := fs.Bool("globoff", false, "add URLs as they are, without expanding [ranges] and {sets}")
+	maxURLs := fs.Int("max-urls", urlglob.DefaultLimit, "most downloads a single pattern may expand to")
+	patterns, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
+		return usagef("add needs at least one URL")
+	}
+	if *maxURLs < 1 {
+		return usagef("--max-urls must be at least 1")
+	}
+
+	// Like curl, expand every pattern before adding anything
+	urls := patterns
+	if !*globoff {
+		urls = nil
+		for _, pattern := range patterns {
+			expanded, err := urlglob.Expand(pattern, *maxURLs)
+			if errors.Is(err, urlglob.ErrTooMany) {
+				return usagef("%s: %v; raise --max-urls to add them", pattern, err)
+			}
+			if err != nil {
+				return usagef("%s: %v; use --globoff to add it as it is", pattern, err)
+			}
+			urls = append(urls, expanded...)
+		}
+	}
+
+	var added []downloader.Snapshot
+	err = withManager(func(m queue.Service) error {
+		var errs []error
+		for _, rawURL := range urls {
+			id, err := m.Add(rawURL, queue.AddOptions{Queue: *queueName})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", rawURL, err))
+				continue
+			}
+			if s, ok := findSnapshot(m.Snapshot(), id); ok {
+				added = append(added, s)
+			}
+		}
+		return errors.Join(errs...)
+	})
+
+	if e.json {
+		return errors.Join(err, e.printJSON(nonNil(added)))
+	}
+	for _, s := range added {
+		fmt.Fprintf(e.stdout, "Added %s %s -> %s (queue %s)\n", s.ID, s.URL, s.TargetPath, s.Queue)
+	}
+	return err
+}
+
+func runList(e *env, args []string) error {
+	fs := e.newFlagSet("list", "[--queue NAME] [--status STATUS]")
+	queueName := fs.String("queue", "", "only list downloads in this queue")
+	status := fs.String("status", "", "only list downloads with this status, e.g. paused or error")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return usagef("list takes no arguments")
+	}
+	if *status != "" && !downloader.Status(*status).Valid() {
+		return usagef("unknown status %q", *status)
+	}
+
+	all, err := readSnapshots()
+	if err != nil {
+		return err
+	}
+
+	snapshots := make([]downloader.Snapshot, 0, len(all))
+	for _, s := range all {
+		if (*queueName == "" || s.Queue == *queueName) && (*status == "" || string(s.Status) == *status) {
+			snapshots = append(snapshots, s)
+		}
+	}
+
+	if e.json {
+		return e.printJSON(snapshots)
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tSIZE\tQUEUE\tFILE")
+	for _, s := range snapshots {
+		size := "?"
+		if s.TotalSize > 0 {
+			size = formatSize(s.TotalSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s\t%s\t%s\n", s.ID, s.Status, s.Progress, size, s.Queue, s.Filename)
+	}
+	return w.Flush()
+}
+
+// readSnapshots returns the state of all downloads: the live state if a
+// daemon serves them, otherwise the saved state. Reading the saved state
+// doesn't need the store to itself, so this also works while the interface
+// is running.
+func readSnapshots() ([]downloader.Snapshot, error) {
+	client, err := daemon.Dial(config.GetSocketPath())
+	if err == nil {
+		defer client.Shutdown(context.Background())
+		return client.Snapshot(), nil
+	}
+	if !errors.Is(err, daemon.ErrNotRunning) {
+		return nil, err
+	}
+
+	downloads, err := store.ReadDownloads(config.GetDownloadsPath())
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]downloader.Snapshot, 0, len(downloads))
+	for _, d := range downloads {
+		snapshots = append(snapshots, d.Snapshot())
+	}
+	return snapshots, nil
+}
+
+func runPause(e *env, args []string) error {
+	return changeDownloads(e, "pause", args, "Paused", func(m queue.Service, id string) error {
+		return m.PauseDownload(id)
+	})
+}
+
+func runResume(e *env, args []string) error {
+	return changeDownloads(e, "resume", args, "Resumed", func(m queue.Service, id string) error {
+		return m.ResumeDownload(id)
+	})
+}
+
+func runRemove(e *env, args []string) error {
+	return changeDownloads(e, "remove", args, "Removed", func(m queue.Service, id string) error {
+		return m.RemoveDownload(id)
+	})
+}
+
+func runRetry(e *env, args []string) error {
+	return changeDownloads(e, "retry", args, "Retrying", func(m queue.Service, id string) error {
+		return m.RetryDownload(id)
+	})
+}
+
+// changeDownloads applies change to each download named in args and reports
+// the downloads it succeeded for. Downloads can be named by a unique prefix
+// of their ID.
+func changeDownloads(e *env, name string, args []string, verb string, change func(m queue.Service, id string) error) error {
+	fs := e.newFlagSet(name, "ID...")
+	ids, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return usagef("%s needs at least one download ID", name)
+	}
+
+	var changed []downloader.Snapshot
+	err = withManager(func(m queue.Service) error {
+		var errs []error
+		for _, arg := range ids {
+			id, err := resolveID(m.Snapshot(), arg)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			before, _ := findSnapshot(m.Snapshot(), id)
+			if err := change(m, id); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			// A removed download is reported as it was last seen
+			if s, ok := findSnapshot(m.Snapshot(), id); ok {
+				changed = append(changed, s)
+			} else {
+				changed = append(changed, before)
+			}
+		}
+		return errors.Join(errs...)
+	})
+
+	if e.json {
+		return errors.Join(err, e.printJSON(nonNil(changed)))
+	}
+	for _, s := range changed {
+		fmt.Fprintf(e.stdout, "%s %s %s (%s)\n", verb, s.ID, s.Filename, s.Status)
+	}
+	return err
+}
+
+// resolveID returns the ID of the download that arg names, either by its full
+// ID or by a prefix that no other download shares
+func resolveID(snapshots []downloader.Snapshot, arg string) (string, error) {
+	var matches []string
+	for _, s := range snapshots {
+		if s.ID == arg {
+			return s.ID, nil
+		}
+		if strings.HasPrefix(s.ID, arg) {
+			matches = append(matches, s.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("download %s %w", arg, queue.ErrNotFound)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", usagef("download ID %s is ambiguous, it matches %s", arg, strings.Join(matches, ", "))
+	}
+}
+
+// findSnapshot returns the snapshot of the download with the given ID
+func findSnapshot(snapshots []downloader.Snapshot, id string) (downloader.Snapshot, bool) {
+	for _, s := range snapshots {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return downloader.Snapshot{}, false
+}
+
+// nonNil makes an empty result encode as [] rather than null
+func nonNil(snapshots []downloader.Snapshot) []downloader.Snapshot {
+	if snapshots == nil {
+		return []downloader.Snapshot{}
+	}
+	return snapshots
+}
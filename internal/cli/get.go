@@ -0,0 +1,308 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+)
+
+// getQueue is the queue name get downloads are logged under
+const getQueue = "get"
+
+// progressBarWidth is the number of characters in the progress bar
+const progressBarWidth = 30
+
+// progress intervals for terminals and for machine-readable output
+const (
+	ttyInterval  = 200 * time.Millisecond
+	jsonInterval = time.Second
+)
+
+// errInterrupted reports that get was stopped by a signal
+var errInterrupted = errors.New("interrupted, run the same command again to resume")
+
+func runGet(e *env, args []string) error {
+	fs := e.newFlagSet("get", "[-o PATH] [--limit RATE] [--sha256 HEX] URL")
+	output := fs.String("o", "", "file or directory to save to (default: the file name from the URL)")
+	limit := fs.String("limit", "0", "maximum speed per second, e.g. 500K or 2M, 0 for unlimited")
+	checksum := fs.String("sha256", "", "expected SHA-256 of the file; a mismatch fails the download")
+	urls, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(urls) != 1 {
+		return usagef("get needs exactly one URL")
+	}
+	rawURL := urls[0]
+
+	parsed, err := url.ParseRequestURI(rawURL)
+	if err != nil || !strings.HasPrefix(parsed.Scheme, "http") || parsed.Host == "" {
+		return usagef("%q is not an http or https URL", rawURL)
+	}
+	rate, err := parseSize(*limit)
+	if err != nil {
+		return usagef("--limit: %v", err)
+	}
+	if *checksum != "" {
+		if b, err := hex.DecodeString(*checksum); err != nil || len(b) != 32 {
+			return usagef("--sha256: %q is not a SHA-256 in hex", *checksum)
+		}
+	}
+
+	target, err := getTargetPath(parsed, *output)
+	if err != nil {
+		return err
+	}
+
+	d := downloader.New(rawURL, target, getQueue, toKB(rate), time.Time{})
+	d.Checksum = strings.ToLower(*checksum)
+	resumed := d.AdoptPartial()
+
+	// Interrupting leaves the partial file and its resume state behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bus := events.NewBus()
+	sub := bus.Subscribe(64, events.DropOldest)
+	defer sub.Close()
+	d.SetEventBus(bus)
+
+	var p progressPrinter
+	if e.json || !isTerminal(e.stdout) {
+		p = &jsonProgress{env: e}
+	} else {
+		p = &ttyProgress{out: e.stdout, errOut: e.stderr}
+		if resumed {
+			fmt.Fprintf(e.stderr, "Resuming %s\n", target)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Start(ctx)
+	}()
+
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-sub.Events():
+			p.event(ev)
+		case <-ticker.C:
+			p.progress(d.Snapshot())
+		case err := <-done:
+			s := d.Snapshot()
+			if err != nil && ctx.Err() != nil {
+				err = errInterrupted
+			}
+			p.finish(s, err)
+			return err
+		}
+	}
+}
+
+// getTargetPath returns where get saves the file at u: output itself, or
+// the file name from the URL inside output if it is a directory, or in the
+// current directory if output is empty
+func getTargetPath(u *url.URL, output string) (string, error) {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == "" {
+		name = "index.html"
+	}
+
+	if output == "" {
+		return name, nil
+	}
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		return filepath.Join(output, name), nil
+	}
+	if strings.HasSuffix(output, string(filepath.Separator)) {
+		return "", fmt.Errorf("directory %s does not exist", output)
+	}
+	return output, nil
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressPrinter shows the progress of a get
+type progressPrinter interface {
+	interval() time.Duration
+	event(ev events.Event)                   // A lifecycle event of the download
+	progress(s downloader.Snapshot)          // Called every interval
+	finish(s downloader.Snapshot, err error) // Called once Start has returned
+}
+
+// ttyProgress redraws a single curl-style progress line
+type ttyProgress struct {
+	out    io.Writer
+	errOut io.Writer
+	width  int // Length of the last line drawn, to blank out leftovers
+}
+
+func (p *ttyProgress) interval() time.Duration {
+	return ttyInterval
+}
+
+func (p *ttyProgress) event(ev events.Event) {
+	if ev.Type == events.Retrying {
+		p.clear()
+		fmt.Fprintf(p.errOut, "Retrying after error: %s\n", ev.Error)
+	}
+}
+
+func (p *ttyProgress) progress(s downloader.Snapshot) {
+	var line string
+	switch {
+	case s.Status == downloader.StatusVerifying:
+		line = fmt.Sprintf("Verifying %s...", s.Filename)
+	case s.TotalSize > 0:
+		percent := float64(s.Downloaded) / float64(s.TotalSize)
+		filled := int(percent * progressBarWidth)
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		bar := strings.Repeat("=", filled)
+		if filled < progressBarWidth {
+			bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+		}
+		line = fmt.Sprintf("%5.1f%% [%s] %s / %s  %s/s  ETA %s",
+			percent*100, bar, formatSize(s.Downloaded), formatSize(s.TotalSize), formatSize(s.Speed), eta(s))
+	default:
+		line = fmt.Sprintf("%s  %s/s", formatSize(s.Downloaded), formatSize(s.Speed))
+	}
+	p.draw(line)
+}
+
+func (p *ttyProgress) finish(s downloader.Snapshot, err error) {
+	p.clear()
+	if err != nil {
+		return
+	}
+	elapsed := s.ActiveTime
+	fmt.Fprintf(p.out, "Saved %s (%s in %s, %s/s)\n",
+		s.TargetPath, formatSize(s.TotalSize), elapsed.Round(time.Second), formatSize(averageSpeed(s.TotalSize, elapsed)))
+	if s.Checksum != "" {
+		fmt.Fprintln(p.out, "SHA-256 verified")
+	}
+}
+
+// draw replaces the current line with line
+func (p *ttyProgress) draw(line string) {
+	padding := ""
+	if len(line) < p.width {
+		padding = strings.Repeat(" ", p.width-len(line))
+	}
+	fmt.Fprint(p.out, "\r"+line+padding)
+	p.width = len(line)
+}
+
+// clear blanks out the current line
+func (p *ttyProgress) clear() {
+	if p.width > 0 {
+		fmt.Fprint(p.out, "\r"+strings.Repeat(" ", p.width)+"\r")
+		p.width = 0
+	}
+}
+
+// jsonProgress prints one event per line as JSON: lifecycle events as they
+// happen and a progress event every interval
+type jsonProgress struct {
+	env *env
+}
+
+func (p *jsonProgress) interval() time.Duration {
+	return jsonInterval
+}
+
+func (p *jsonProgress) event(ev events.Event) {
+	// Progress is reported on the ticker; the final event comes from finish
+	switch ev.Type {
+	case events.Progress, events.Completed, events.Failed:
+		return
+	}
+	p.print(ev)
+}
+
+func (p *jsonProgress) progress(s downloader.Snapshot) {
+	p.print(snapshotEvent(events.Progress, s))
+}
+
+func (p *jsonProgress) finish(s downloader.Snapshot, err error) {
+	ev := snapshotEvent(events.Completed, s)
+	ev.Message = s.TargetPath
+	if err != nil {
+		ev.Type = events.Failed
+		ev.Error = err.Error()
+	}
+	p.print(ev)
+}
+
+func (p *jsonProgress) print(ev events.Event) {
+	ev.Seq = 0
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	data, err := json.Marshal(ev)
+	if err == nil {
+		p.env.stdout.Write(append(data, '\n'))
+	}
+}
+
+// snapshotEvent describes the state of a download as an event
+func snapshotEvent(t events.Type, s downloader.Snapshot) events.Event {
+	return events.Event{
+		Type:       t,
+		DownloadID: s.ID,
+		URL:        s.URL,
+		Queue:      s.Queue,
+		Status:     string(s.Status),
+		Downloaded: s.Downloaded,
+		TotalSize:  s.TotalSize,
+		Speed:      s.Speed,
+		Error:      s.Error,
+		Time:       time.Now(),
+	}
+}
+
+// eta estimates the time left for a download, as m:ss or h:mm:ss
+func eta(s downloader.Snapshot) string {
+	if s.Speed <= 0 || s.TotalSize <= s.Downloaded {
+		return "--:--"
+	}
+	left := time.Duration((s.TotalSize-s.Downloaded)/s.Speed) * time.Second
+	h, m, sec := int(left.Hours()), int(left.Minutes())%60, int(left.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
+// averageSpeed returns the bytes per second of size transferred in elapsed
+func averageSpeed(size int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return size
+	}
+	return int64(float64(size) / elapsed.Seconds())
+}
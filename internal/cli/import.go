@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/urllist"
+)
+
+// importResult is an entry of an imported list and what became of it
+type importResult struct {
+	urllist.Entry
+	ID     string `json:"id,omitempty"`     // The new download, if the entry was added
+	Target string `json:"target,omitempty"` // Where the new download saves to
+	Error  string `json:"error,omitempty"`  // Why adding the entry failed
+}
+
+func runImport(e *env, args []string) error {
+	fs := e.newFlagSet("import", "[--queue NAME] [--dry-run] [--duplicates] FILE")
+	queueName := fs.String("queue", "", "queue to add to (default: the default queue)")
+	dryRun := fs.Bool("dry-run", false, "only show what would be added")
+	duplicates := fs.Bool("duplicates", false, "also add URLs that are already in the downloads or earlier in the list")
+	files, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return usagef("import needs one file, or - for standard input")
+	}
+
+	var r io.Reader = os.Stdin
+	if files[0] != "-" {
+		f, err := os.Open(files[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// A preview only reads the downloads, so it works while they are open
+	// elsewhere
+	if *dryRun {
+		snapshots, err := readSnapshots()
+		if err != nil {
+			return err
+		}
+		entries, err := urllist.Parse(r, knownURLs(snapshots))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", files[0], err)
+		}
+		results := make([]importResult, len(entries))
+		for i, entry := range entries {
+			results[i].Entry = entry
+		}
+		return e.printImport(results, true, *duplicates)
+	}
+
+	var results []importResult
+	err = withManager(func(m queue.Service) error {
+		if *queueName != "" && m.Config().GetQueue(*queueName) == nil {
+			return fmt.Errorf("queue %s %w", *queueName, queue.ErrNotFound)
+		}
+		entries, err := urllist.Parse(r, knownURLs(m.Snapshot()))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", files[0], err)
+		}
+
+		var errs []error
+		results = make([]importResult, len(entries))
+		for i, entry := range entries {
+			results[i].Entry = entry
+			if !shouldImport(entry, *duplicates) {
+				continue
+			}
+			opts, err := entry.AddOptions(*queueName)
+			var id string
+			if err == nil {
+				id, err = m.Add(entry.URL, opts)
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				errs = append(errs, fmt.Errorf("line %d: %s: %w", entry.Line, entry.URL, err))
+				continue
+			}
+			results[i].ID = id
+			if s, ok := findSnapshot(m.Snapshot(), id); ok {
+				results[i].Target = s.TargetPath
+			}
+		}
+		return errors.Join(errs...)
+	})
+	if results == nil {
+		return err
+	}
+	return errors.Join(err, e.printImport(results, false, *duplicates))
+}
+
+// shouldImport tells whether an entry of a list is added
+func shouldImport(entry urllist.Entry, duplicates bool) bool {
+	return entry.Verdict == urllist.New || (duplicates && entry.Verdict == urllist.Duplicate)
+}
+
+// knownURLs returns the URLs of the downloads, which a list shouldn't add again
+func knownURLs(snapshots []downloader.Snapshot) []string {
+	urls := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
+
+// printImport shows the entries of a list and what became of them, or for a
+// preview, what importing would do with them
+func (e *env) printImport(results []importResult, preview, duplicates bool) error {
+	if e.json {
+		return e.printJSON(results)
+	}
+
+	counts := map[urllist.Verdict]int{}
+	added, failed := 0, 0
+	w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tRESULT\tURL\tNOTE")
+	for _, r := range results {
+		counts[r.Verdict]++
+		result, note := string(r.Verdict), r.Reason
+		switch {
+		case r.ID != "":
+			result, note = "added "+r.ID, r.Target
+			added++
+		case r.Error != "":
+			result, note = "failed", r.Error
+			failed++
+		case preview && shouldImport(r.Entry, duplicates):
+			result = "add"
+		}
+		if len(r.Ignored) > 0 {
+			note = strings.TrimSpace(note + " (ignored: " + strings.Join(r.Ignored, ", ") + ")")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", r.Line, result, r.URL, note)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	skipped := fmt.Sprintf("%d duplicate(s) and %d invalid entries", counts[urllist.Duplicate], counts[urllist.Invalid])
+	if duplicates {
+		skipped = fmt.Sprintf("%d invalid entries", counts[urllist.Invalid])
+	}
+	if preview {
+		wouldAdd := counts[urllist.New]
+		if duplicates {
+			wouldAdd += counts[urllist.Duplicate]
+		}
+		fmt.Fprintf(e.stdout, "\nWould add %d download(s), skipping %s.\n", wouldAdd, skipped)
+		return nil
+	}
+	fmt.Fprintf(e.stdout, "\nAdded %d download(s), %d failed, skipped %s.\n", added, failed, skipped)
+	return nil
+}
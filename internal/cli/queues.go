@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// queueInfo is a queue as printed by the queue commands
+type queueInfo struct {
+	config.QueueConfig
+	Default   bool `json:"default"`
+	Downloads int  `json:"downloads"` // Downloads in the queue
+}
+
+// queueFlags are the settings of a queue that can be given on the command line
+type queueFlags struct {
+	maxConcurrent int
+	speedLimit    string
+	start         string
+	end           string
+	path          string
+	enabled       bool
+	makeDefault   bool
+}
+
+func (f *queueFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.maxConcurrent, "max-concurrent", 3, "downloads that may run at the same time")
+	fs.StringVar(&f.speedLimit, "speed-limit", "0", "per second for each download, e.g. 500K or 2M, 0 for unlimited")
+	fs.StringVar(&f.start, "start", "00:00", "start of the daily time window (HH:MM)")
+	fs.StringVar(&f.end, "end", "23:59", "end of the daily time window (HH:MM)")
+	fs.StringVar(&f.path, "path", "", "download directory (default: a directory named after the queue in the save path)")
+	fs.BoolVar(&f.enabled, "enabled", true, "whether the queue starts downloads")
+	fs.BoolVar(&f.makeDefault, "default", false, "make this the default queue")
+}
+
+// apply sets the fields of q whose flags were given, or all of them if all is set
+func (f *queueFlags) apply(fs *flag.FlagSet, q *config.QueueConfig, all bool) error {
+	set := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	if all || set["max-concurrent"] {
+		q.MaxConcurrent = f.maxConcurrent
+	}
+	if all || set["speed-limit"] {
+		limit, err := parseSize(f.speedLimit)
+		if err != nil {
+			return usagef("--speed-limit: %v", err)
+		}
+		q.SpeedLimit = toKB(limit)
+	}
+	if all || set["start"] {
+		q.StartTime = f.start
+	}
+	if all || set["end"] {
+		q.EndTime = f.end
+	}
+	if set["path"] {
+		q.Path = f.path
+	}
+	if all || set["enabled"] {
+		q.Enabled = f.enabled
+	}
+	return nil
+}
+
+func runQueue(e *env, args []string) error {
+	if len(args) == 0 {
+		return usagef("queue needs a subcommand: add, edit, rm or list")
+	}
+	switch args[0] {
+	case "add":
+		return runQueueAdd(e, args[1:])
+	case "edit":
+		return runQueueEdit(e, args[1:])
+	case "rm":
+		return runQueueRemove(e, args[1:])
+	case "list":
+		return runQueueList(e, args[1:])
+	}
+	return usagef("unknown queue subcommand %q, expected add, edit, rm or list", args[0])
+}
+
+func runQueueAdd(e *env, args []string) error {
+	fs := e.newFlagSet("queue add", "NAME [flags]")
+	var f queueFlags
+	f.register(fs)
+	name, err := parseQueueName(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return e.updateQueues("Added", func(cfg *config.Config) (config.QueueConfig, error) {
+		if cfg.GetQueue(name) != nil {
+			return config.QueueConfig{}, fmt.Errorf("queue %s already exists", name)
+		}
+		q := config.QueueConfig{Name: name, Path: filepath.Join(cfg.SavePath, name)}
+		if err := f.apply(fs, &q, true); err != nil {
+			return config.QueueConfig{}, err
+		}
+		cfg.Queues = append(cfg.Queues, q)
+		if f.makeDefault {
+			cfg.DefaultQueue = name
+		}
+		return q, nil
+	})
+}
+
+func runQueueEdit(e *env, args []string) error {
+	fs := e.newFlagSet("queue edit", "NAME [flags]")
+	var f queueFlags
+	f.register(fs)
+	name, err := parseQueueName(fs, args)
+	if err != nil {
+		return err
+	}
+
+	return e.updateQueues("Updated", func(cfg *config.Config) (config.QueueConfig, error) {
+		q := cfg.GetQueue(name)
+		if q == nil {
+			return config.QueueConfig{}, fmt.Errorf("queue %s %w", name, queue.ErrNotFound)
+		}
+		if err := f.apply(fs, q, false); err != nil {
+			return config.QueueConfig{}, err
+		}
+		if f.makeDefault {
+			cfg.DefaultQueue = name
+		}
+		return *q, nil
+	})
+}
+
+func runQueueRemove(e *env, args []string) error {
+	fs := e.newFlagSet("queue rm", "NAME")
+	name, err := parseQueueName(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// The validator rejects removing the default queue or one that still has downloads
+	return e.updateQueues("Removed", func(cfg *config.Config) (config.QueueConfig, error) {
+		for i := range cfg.Queues {
+			if cfg.Queues[i].Name == name {
+				removed := cfg.Queues[i]
+				cfg.Queues = append(cfg.Queues[:i], cfg.Queues[i+1:]...)
+				return removed, nil
+			}
+		}
+		return config.QueueConfig{}, fmt.Errorf("queue %s %w", name, queue.ErrNotFound)
+	})
+}
+
+func runQueueList(e *env, args []string) error {
+	fs := e.newFlagSet("queue list", "")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return usagef("queue list takes no arguments")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	downloads, err := store.ReadDownloads(config.GetDownloadsPath())
+	if err != nil {
+		return err
+	}
+
+	infos := make([]queueInfo, 0, len(cfg.Queues))
+	for _, q := range cfg.Queues {
+		infos = append(infos, newQueueInfo(cfg, q, downloads))
+	}
+
+	if e.json {
+		return e.printJSON(infos)
+	}
+
+	w := tabwriter.NewWriter(e.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENABLED\tMAX\tSPEED LIMIT\tWINDOW\tDOWNLOADS\tPATH")
+	for _, q := range infos {
+		name := q.Name
+		if q.Default {
+			name += " *"
+		}
+		limit := "unlimited"
+		if q.SpeedLimit > 0 {
+			limit = formatSize(q.SpeedLimit*1024) + "/s"
+		}
+		fmt.Fprintf(w, "%s\t%v\t%d\t%s\t%s-%s\t%d\t%s\n",
+			name, q.Enabled, q.MaxConcurrent, limit, q.StartTime, q.EndTime, q.Downloads, q.Path)
+	}
+	fmt.Fprintln(w, "\n* default queue")
+	return w.Flush()
+}
+
+// parseQueueName parses the flags of a queue command that takes a single queue name
+func parseQueueName(fs *flag.FlagSet, args []string) (string, error) {
+	rest, err := parse(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(rest) != 1 {
+		return "", usagef("%s needs exactly one queue name", fs.Name())
+	}
+	return rest[0], nil
+}
+
+// updateQueues changes the settings with update, which returns the queue it
+// changed, and saves them if the result is valid, also for the existing
+// downloads. The settings are changed without opening the downloads, so this
+// works while the download manager is running; it picks up the saved file
+// on its own.
+func (e *env) updateQueues(verb string, update func(cfg *config.Config) (config.QueueConfig, error)) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	downloads, err := store.ReadDownloads(config.GetDownloadsPath())
+	if err != nil {
+		return err
+	}
+
+	next := cfg.Clone()
+	q, err := update(next)
+	if err != nil {
+		return err
+	}
+	if err := next.ValidateDownloads(downloads); err != nil {
+		return err
+	}
+	if err := config.SaveConfig(next); err != nil {
+		return err
+	}
+
+	info := newQueueInfo(next, q, downloads)
+	if e.json {
+		return e.printJSON(info)
+	}
+	fmt.Fprintf(e.stdout, "%s queue %s\n", verb, q.Name)
+	return nil
+}
+
+// newQueueInfo describes q, counting its downloads
+func newQueueInfo(cfg *config.Config, q config.QueueConfig, downloads []*downloader.Download) queueInfo {
+	info := queueInfo{QueueConfig: q, Default: q.Name == cfg.DefaultQueue}
+	for _, d := range downloads {
+		if d.Queue == q.Name {
+			info.Downloads++
+		}
+	}
+	return info
+}
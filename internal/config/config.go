@@ -2,11 +2,8 @@ package config
 
 import (
 	"encoding/json"
-	"os"
 	"path/filepath"
 	"time"
-
-	"github.com/mahdiXak47/Download-Manager/internal/downloader"
 )
 
 type QueueConfig struct {
@@ -14,95 +11,80 @@ type QueueConfig struct {
 	MaxConcurrent int    `json:"max_concurrent"`
 	StartTime     string `json:"start_time"`  // Format: "HH:MM"
 	EndTime       string `json:"end_time"`    // Format: "HH:MM"
-	SpeedLimit    int64  `json:"speed_limit"` // Bytes per second, 0 for unlimited
+	SpeedLimit    int64  `json:"speed_limit"` // KB/s for each download, 0 for unlimited
 	Enabled       bool   `json:"enabled"`
 	Path          string `json:"path"` // Download directory path for this queue
 }
 
-type Config struct {
-	DefaultQueue string                `json:"default_queue"`
-	SavePath     string                `json:"save_path"`
-	Downloads    []downloader.Download `json:"downloads"`
-	Queues       []QueueConfig         `json:"queues"`
+// HistoryConfig controls how long finished downloads are remembered
+type HistoryConfig struct {
+	RetentionDays int `json:"retention_days"` // Entries older than this are dropped, 0 keeps them forever
+	MaxEntries    int `json:"max_entries"`    // Oldest entries beyond this count are dropped, 0 for no limit
 }
 
-var defaultConfig = Config{
-	DefaultQueue: "default",
-	SavePath:     "downloads",
-	Queues: []QueueConfig{
-		{
-			Name:          "default",
-			MaxConcurrent: 3,
-			StartTime:     "00:00",
-			EndTime:       "23:59",
-			SpeedLimit:    0,
-			Enabled:       true,
-			Path:          "downloads/default",
-		},
-		{
-			Name:          "night",
-			MaxConcurrent: 5,
-			StartTime:     "23:00",
-			EndTime:       "06:00",
-			SpeedLimit:    0,
-			Enabled:       true,
-			Path:          "downloads/night",
-		},
-	},
+// LimitsConfig caps all downloads together, on top of the limits of each queue
+type LimitsConfig struct {
+	MaxActive  int   `json:"max_active"`  // Downloads running at the same time across all queues, 0 for no limit
+	SpeedLimit int64 `json:"speed_limit"` // KB/s for all downloads together, 0 for unlimited
 }
 
-const configFileName = "download-manager.json"
-
-// GetConfigPath returns the path to the config file
-func GetConfigPath() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		homeDir = "."
-	}
-	return filepath.Join(homeDir, ".config", "download-manager", configFileName)
+// Config holds the user's settings. Download state is kept separately in the
+// download store, so this file only changes when settings do.
+type Config struct {
+	SchemaVersion int           `json:"schema_version"`
+	DefaultQueue  string        `json:"default_queue"`
+	SavePath      string        `json:"save_path"`
+	Queues        []QueueConfig `json:"queues"`
+	History       HistoryConfig `json:"history"`
+	Limits        LimitsConfig  `json:"limits"`
+
+	// LegacyDownloads holds the downloads of a config file written before
+	// they moved to the download store, until they are imported into it
+	LegacyDownloads json.RawMessage `json:"legacy_downloads,omitempty"`
 }
 
-// LoadConfig loads the configuration from file or creates default if not exists
-func LoadConfig() (*Config, error) {
-	configPath := GetConfigPath()
-
-	// Ensure config directory exists
-	configDir := filepath.Dir(configPath)
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return nil, err
-	}
-
-	// Try to read existing config
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Create default config
-			config := defaultConfig
-			if err := SaveConfig(&config); err != nil {
-				return nil, err
-			}
-			return &config, nil
-		}
-		return nil, err
-	}
-
-	// Parse existing config
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
-	}
-
-	return &config, nil
+var defaultHistoryConfig = HistoryConfig{
+	RetentionDays: 90,
+	MaxEntries:    1000,
 }
 
-// SaveConfig saves the configuration to file
-func SaveConfig(config *Config) error {
-	data, err := json.MarshalIndent(config, "", "    ")
-	if err != nil {
-		return err
+// newDefaultConfig returns the default configuration, saving into the
+// user's download directory
+func newDefaultConfig() *Config {
+	saveDir := GetDownloadDir()
+	return &Config{
+		SchemaVersion: CurrentSchemaVersion,
+		DefaultQueue:  "default",
+		SavePath:      saveDir,
+		Queues: []QueueConfig{
+			{
+				Name:          "default",
+				MaxConcurrent: 3,
+				StartTime:     "00:00",
+				EndTime:       "23:59",
+				SpeedLimit:    0,
+				Enabled:       true,
+				Path:          filepath.Join(saveDir, "default"),
+			},
+			{
+				Name:          "night",
+				MaxConcurrent: 5,
+				StartTime:     "23:00",
+				EndTime:       "06:00",
+				SpeedLimit:    0,
+				Enabled:       true,
+				Path:          filepath.Join(saveDir, "night"),
+			},
+		},
+		History: defaultHistoryConfig,
 	}
+}
 
-	return os.WriteFile(GetConfigPath(), data, 0644)
+// Clone returns a copy of the config that doesn't share its queues with c
+func (c *Config) Clone() *Config {
+	config := *c
+	config.Queues = append([]QueueConfig(nil), c.Queues...)
+	return &config
 }
 
 // IsTimeAllowed checks if downloads are allowed for a queue at the current time
@@ -124,6 +106,11 @@ func (q *QueueConfig) IsTimeAllowed() bool {
 	return currentTime >= q.StartTime && currentTime <= q.EndTime
 }
 
+// MaxAge returns how long history entries are kept, 0 for forever
+func (h HistoryConfig) MaxAge() time.Duration {
+	return time.Duration(h.RetentionDays) * 24 * time.Hour
+}
+
 // GetQueue returns a queue configuration by name
 func (c *Config) GetQueue(name string) *QueueConfig {
 	for i := range c.Queues {
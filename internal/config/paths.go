@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	appDirName        = "download-manager"
+	configFileName    = "download-manager.json"
+	downloadsFileName = "downloads.journal"
+	historyFileName   = "history.jsonl"
+	logFileName       = "download-manager.log"
+	profilesDirName   = "profiles"
+	socketFileName    = "daemon.sock"
+	tokenFileName     = "api-token"
+)
+
+// Environment variables overriding the default locations. Command line flags
+// take precedence over them, and they take precedence over the XDG variables.
+const (
+	EnvConfig      = "DM_CONFIG"       // Settings file
+	EnvDataDir     = "DM_DATA_DIR"     // Download state and history
+	EnvLogFile     = "DM_LOG_FILE"     // Log file
+	EnvDownloadDir = "DM_DOWNLOAD_DIR" // Default save path for new configs
+	EnvProfile     = "DM_PROFILE"      // Active profile
+)
+
+// Paths holds locations given on the command line. Empty fields fall back
+// to the environment and then to the XDG base directories.
+type Paths struct {
+	ConfigFile string
+	DataDir    string
+	LogFile    string
+}
+
+// overrides are the paths set by SetPaths
+var overrides Paths
+
+// pathsMutex guards overrides and the active profile, which can change while
+// the program runs
+var pathsMutex sync.RWMutex
+
+// SetPaths overrides the default locations. It must be called before any
+// file is opened, normally right after parsing the command line.
+func SetPaths(paths Paths) {
+	pathsMutex.Lock()
+	defer pathsMutex.Unlock()
+	overrides = Paths{
+		ConfigFile: absPath(paths.ConfigFile),
+		DataDir:    absPath(paths.DataDir),
+		LogFile:    absPath(paths.LogFile),
+	}
+}
+
+// GetConfigPath returns the path to the config file: --config, $DM_CONFIG,
+// $XDG_CONFIG_HOME/download-manager or ~/.config/download-manager. Named
+// profiles keep theirs in a profiles directory next to it.
+func GetConfigPath() string {
+	pathsMutex.RLock()
+	defer pathsMutex.RUnlock()
+
+	path := baseConfigPath()
+	if profile != "" {
+		path = filepath.Join(filepath.Dir(path), profilesDirName, profile+".json")
+	}
+	return path
+}
+
+// baseConfigPath returns the config path of the default profile. The caller
+// must hold pathsMutex.
+func baseConfigPath() string {
+	if path := firstSet(overrides.ConfigFile, os.Getenv(EnvConfig)); path != "" {
+		return absPath(path)
+	}
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), appDirName, configFileName)
+}
+
+// GetDataDir returns the directory for download state and history:
+// --data-dir, $DM_DATA_DIR, $XDG_DATA_HOME/download-manager or
+// ~/.local/share/download-manager. Named profiles use a subdirectory of it.
+func GetDataDir() string {
+	pathsMutex.RLock()
+	defer pathsMutex.RUnlock()
+
+	dir := firstSet(overrides.DataDir, os.Getenv(EnvDataDir))
+	if dir != "" {
+		dir = absPath(dir)
+	} else {
+		dir = filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")), appDirName)
+	}
+	if profile != "" {
+		dir = filepath.Join(dir, profilesDirName, profile)
+	}
+	return dir
+}
+
+// GetDownloadsPath returns the path to the download store journal
+func GetDownloadsPath() string {
+	return filepath.Join(GetDataDir(), downloadsFileName)
+}
+
+// GetHistoryPath returns the path to the download history
+func GetHistoryPath() string {
+	return filepath.Join(GetDataDir(), historyFileName)
+}
+
+// GetSocketPath returns the path of the Unix socket a daemon serving the
+// downloads in the data directory listens on
+func GetSocketPath() string {
+	return filepath.Join(GetDataDir(), socketFileName)
+}
+
+// GetTokenPath returns the path of the token clients of the daemon's TCP
+// API authenticate with
+func GetTokenPath() string {
+	return filepath.Join(GetDataDir(), tokenFileName)
+}
+
+// GetLogPath returns the path to the log file: --log-file, $DM_LOG_FILE,
+// $XDG_STATE_HOME/download-manager or ~/.local/state/download-manager
+func GetLogPath() string {
+	pathsMutex.RLock()
+	defer pathsMutex.RUnlock()
+
+	if path := firstSet(overrides.LogFile, os.Getenv(EnvLogFile)); path != "" {
+		return absPath(path)
+	}
+	return filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(".local", "state")), appDirName, logFileName)
+}
+
+// GetDownloadDir returns the directory new configs save downloads into:
+// $DM_DOWNLOAD_DIR, $XDG_DOWNLOAD_DIR, the download directory from the
+// user-dirs.dirs file or ~/Downloads
+func GetDownloadDir() string {
+	if dir := os.Getenv(EnvDownloadDir); dir != "" {
+		return absPath(dir)
+	}
+	if dir := os.Getenv("XDG_DOWNLOAD_DIR"); filepath.IsAbs(dir) {
+		return dir
+	}
+	if dir := userDirsDownloadDir(); dir != "" {
+		return dir
+	}
+	return filepath.Join(homeDir(), "Downloads")
+}
+
+// userDirsDownloadDir reads XDG_DOWNLOAD_DIR from the user-dirs.dirs file
+// written by xdg-user-dirs-update, or returns "" if it isn't set there
+func userDirsDownloadDir() string {
+	file, err := os.Open(filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "user-dirs.dirs"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// Lines look like XDG_DOWNLOAD_DIR="$HOME/Downloads"
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "XDG_DOWNLOAD_DIR=")
+		if !found {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		if rest, found := strings.CutPrefix(value, "$HOME"); found {
+			value = homeDir() + rest
+		}
+		if filepath.IsAbs(value) {
+			return filepath.Clean(value)
+		}
+	}
+	return ""
+}
+
+// xdgDir returns the directory in the XDG variable env, or fallback relative
+// to the home directory if it is unset. Relative values are invalid per the
+// XDG base directory spec and are ignored.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(homeDir(), fallback)
+}
+
+// homeDir returns the user's home directory, or the current directory if it is unknown
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return home
+}
+
+// firstSet returns the first non-empty value
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// absPath makes path absolute, so it doesn't depend on the working directory
+// later on. Empty paths stay empty.
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the name of the profile that uses the plain config file
+// and data directory
+const DefaultProfile = "default"
+
+// profile is the active profile, "" for the default one. It is guarded by pathsMutex.
+var profile string
+
+// profileNamePattern restricts profile names to ones that are safe as file names
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidateProfileName checks that name can be used as a profile name
+func ValidateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// SetProfile selects the profile whose config and download state are used.
+// Each named profile is a complete config with its own download state;
+// "" and DefaultProfile select the default one. Nothing may hold files of
+// the previous profile open when it is switched.
+func SetProfile(name string) error {
+	if name == DefaultProfile {
+		name = ""
+	}
+	if name != "" {
+		if err := ValidateProfileName(name); err != nil {
+			return err
+		}
+	}
+
+	pathsMutex.Lock()
+	defer pathsMutex.Unlock()
+	profile = name
+	return nil
+}
+
+// GetProfile returns the name of the active profile
+func GetProfile() string {
+	pathsMutex.RLock()
+	defer pathsMutex.RUnlock()
+	if profile == "" {
+		return DefaultProfile
+	}
+	return profile
+}
+
+// ListProfiles returns the names of all profiles that have a config file,
+// sorted, with the default profile first
+func ListProfiles() ([]string, error) {
+	pathsMutex.RLock()
+	dir := filepath.Join(filepath.Dir(baseConfigPath()), profilesDirName)
+	pathsMutex.RUnlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, isJSON := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !isJSON || name == DefaultProfile || ValidateProfileName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...), nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import "fmt"
+
+// ReadConfig reads, migrates and validates the config file at path without
+// changing anything on disk, e.g. to pick up edits while the program runs
+func ReadConfig(path string) (*Config, error) {
+	config, _, err := loadFile(path)
+	return config, err
+}
+
+// Diff describes what changed from old to new, one line per change, for
+// logging and display. It returns nil if the configs are equivalent.
+func Diff(old, new *Config) []string {
+	var changes []string
+	changed := func(format string, args ...any) {
+		changes = append(changes, fmt.Sprintf(format, args...))
+	}
+
+	if old.DefaultQueue != new.DefaultQueue {
+		changed("default queue: %s -> %s", old.DefaultQueue, new.DefaultQueue)
+	}
+	if old.SavePath != new.SavePath {
+		changed("save path: %s -> %s", old.SavePath, new.SavePath)
+	}
+
+	for _, q := range old.Queues {
+		if new.GetQueue(q.Name) == nil {
+			changed("queue %s removed", q.Name)
+		}
+	}
+	for _, q := range new.Queues {
+		prev := old.GetQueue(q.Name)
+		if prev == nil {
+			changed("queue %s added", q.Name)
+			continue
+		}
+		if prev.MaxConcurrent != q.MaxConcurrent {
+			changed("queue %s: max concurrent %d -> %d", q.Name, prev.MaxConcurrent, q.MaxConcurrent)
+		}
+		if prev.SpeedLimit != q.SpeedLimit {
+			changed("queue %s: speed limit %d -> %d KB/s", q.Name, prev.SpeedLimit, q.SpeedLimit)
+		}
+		if prev.StartTime != q.StartTime || prev.EndTime != q.EndTime {
+			changed("queue %s: window %s-%s -> %s-%s", q.Name, prev.StartTime, prev.EndTime, q.StartTime, q.EndTime)
+		}
+		if prev.Enabled != q.Enabled {
+			changed("queue %s: enabled %t -> %t", q.Name, prev.Enabled, q.Enabled)
+		}
+		if prev.Path != q.Path {
+			changed("queue %s: path %s -> %s", q.Name, prev.Path, q.Path)
+		}
+	}
+
+	if old.History != new.History {
+		changed("history retention: %d days, %d entries -> %d days, %d entries",
+			old.History.RetentionDays, old.History.MaxEntries, new.History.RetentionDays, new.History.MaxEntries)
+	}
+	if old.Limits.MaxActive != new.Limits.MaxActive {
+		changed("global max active: %d -> %d", old.Limits.MaxActive, new.Limits.MaxActive)
+	}
+	if old.Limits.SpeedLimit != new.Limits.SpeedLimit {
+		changed("global speed limit: %d -> %d KB/s", old.Limits.SpeedLimit, new.Limits.SpeedLimit)
+	}
+	return changes
+}
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/fsutil"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// CurrentSchemaVersion is the schema version of config files written by this build
+const CurrentSchemaVersion = 5
+
+// maxBackups is the number of previous config files kept next to the current one
+const maxBackups = 3
+
+// ErrNewerSchema is returned when the config file was written by a newer
+// version of the program. It is left untouched rather than downgraded.
+var ErrNewerSchema = errors.New("config file has a newer schema version")
+
+// errCorrupt marks config files that can't be parsed
+var errCorrupt = errors.New("config file is corrupt")
+
+// migration upgrades a decoded config document by one schema version
+type migration func(doc map[string]any) error
+
+// migrations[i] upgrades a document from schema version i to i+1. Files
+// written before versioning have no schema_version and count as version 0.
+var migrations = []migration{
+	migrateDownloadIDs,
+	migrateLegacyDownloads,
+	migrateHistoryDefaults,
+	migrateDefaultPaths,
+	migrateGlobalLimits,
+}
+
+// saveMutex serializes all writes of the config file and its backups
+var saveMutex sync.Mutex
+
+// LoadConfig loads the configuration from file or creates default if not exists.
+// A corrupt file is moved aside and the latest good backup is used instead. A
+// file that fails validation is left alone and a *ValidationError is returned.
+func LoadConfig() (*Config, error) {
+	configPath := GetConfigPath()
+
+	// Ensure config directory exists
+	configDir := filepath.Dir(configPath)
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, err
+	}
+
+	config, migrated, err := loadFile(configPath)
+	switch {
+	case err == nil:
+		if migrated {
+			if err := SaveConfig(config); err != nil {
+				return nil, err
+			}
+		}
+		return config, nil
+
+	case errors.Is(err, ErrNewerSchema):
+		return nil, err
+
+	case errors.Is(err, errCorrupt):
+		quarantine(configPath, err)
+
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	// The file is missing or was quarantined. A crash can also leave the
+	// config missing after its backup was rotated, so try the backups first.
+	if config, ok := loadLatestBackup(configPath); ok {
+		// The store already holds what became of the downloads of an old
+		// backup, so they aren't imported again
+		if len(config.LegacyDownloads) > 0 {
+			logger.LogDownloadEvent("CONFIG", "Dropped the downloads of the restored backup, which predate the download store")
+			config.LegacyDownloads = nil
+		}
+		if err := SaveConfig(config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+
+	// Create default config
+	config = newDefaultConfig()
+	if err := SaveConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SaveConfig saves the configuration to file. The previous file is kept as a
+// backup and the new one is written atomically, so a crash at any point
+// leaves a complete config behind. Invalid configs are not written.
+func SaveConfig(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+
+	config.SchemaVersion = CurrentSchemaVersion
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	configPath := GetConfigPath()
+	if err := rotateBackups(configPath); err != nil {
+		logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Failed to back up config: %v", err))
+	}
+	return fsutil.WriteFileAtomic(configPath, data, 0644)
+}
+
+// loadFile reads and parses a config file, migrating it to the current
+// schema version. It reports whether a migration was applied.
+func loadFile(path string) (*Config, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	version := 0
+	if v, ok := doc["schema_version"].(float64); ok {
+		version = int(v)
+	}
+	if version > CurrentSchemaVersion {
+		return nil, false, fmt.Errorf("%w: %s has version %d, this build supports %d",
+			ErrNewerSchema, path, version, CurrentSchemaVersion)
+	}
+
+	for v := version; v < CurrentSchemaVersion; v++ {
+		if err := migrations[v](doc); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate config from version %d: %w", v, err)
+		}
+		logger.LogDownloadEvent("CONFIG", fmt.Sprintf("Migrated config from schema version %d to %d", v, v+1))
+	}
+	doc["schema_version"] = CurrentSchemaVersion
+
+	// Decode the migrated document into the typed config
+	migratedData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	var config Config
+	if err := json.Unmarshal(migratedData, &config); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	// A file that parses but makes no sense is reported rather than corrected
+	if err := config.Validate(); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, version != CurrentSchemaVersion, nil
+}
+
+// backupPath returns the path of the n-th most recent backup of path
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// rotateBackups shifts the existing backups by one and copies the current
+// config into the first slot. Files that don't parse are never backed up,
+// so good backups aren't pushed out by a corrupt config.
+func rotateBackups(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !json.Valid(data) {
+		return nil
+	}
+
+	for n := maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return fsutil.WriteFileAtomic(backupPath(path, 1), data, 0644)
+}
+
+// loadLatestBackup returns the most recent backup of path that parses
+func loadLatestBackup(path string) (*Config, bool) {
+	for n := 1; n <= maxBackups; n++ {
+		config, _, err := loadFile(backupPath(path, n))
+		if err == nil {
+			logger.LogDownloadEvent("CONFIG", fmt.Sprintf("Restored config from backup %s", backupPath(path, n)))
+			return config, true
+		}
+		if !os.IsNotExist(err) {
+			logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Skipping unusable backup %s: %v", backupPath(path, n), err))
+		}
+	}
+	return nil, false
+}
+
+// quarantine moves a corrupt config file aside so it can be inspected later
+// and isn't overwritten
+func quarantine(path string, reason error) {
+	corruptPath := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(path, corruptPath); err != nil {
+		logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Failed to quarantine corrupt config: %v", err))
+		return
+	}
+	logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Moved corrupt config to %s: %v", corruptPath, reason))
+}
+
+// migrateDownloadIDs gives every download saved before downloads had IDs a new one
+func migrateDownloadIDs(doc map[string]any) error {
+	downloads, _ := doc["downloads"].([]any)
+	for _, item := range downloads {
+		download, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("unexpected download entry %v", item)
+		}
+		if id, _ := download["id"].(string); id == "" {
+			download["id"] = downloader.NewID()
+		}
+	}
+	return nil
+}
+
+// migrateLegacyDownloads sets aside the downloads of configs written before
+// they moved to the download store. They stay in the file until
+// ImportLegacyDownloads has put them in the store.
+func migrateLegacyDownloads(doc map[string]any) error {
+	if items, _ := doc["downloads"].([]any); len(items) > 0 {
+		doc["legacy_downloads"] = items
+	}
+	delete(doc, "downloads")
+	return nil
+}
+
+// ImportLegacyDownloads puts the downloads set aside by migrating an old
+// config file into db and saves the config without them, so they are only
+// imported once. db must be open, so no other process imports them at the
+// same time. Downloads already in the store are skipped, so an import
+// interrupted before the save doesn't duplicate them.
+func ImportLegacyDownloads(config *Config, db *store.Store) error {
+	if len(config.LegacyDownloads) == 0 {
+		return nil
+	}
+
+	var downloads []*downloader.Download
+	if err := json.Unmarshal(config.LegacyDownloads, &downloads); err != nil {
+		return fmt.Errorf("failed to read the downloads of the old config file: %w", err)
+	}
+	for _, d := range downloads {
+		if d.ID == "" {
+			d.ID = downloader.NewID()
+		}
+		if db.Has(d.ID) {
+			continue
+		}
+		if err := db.Put(d); err != nil {
+			return err
+		}
+	}
+
+	config.LegacyDownloads = nil
+	if err := SaveConfig(config); err != nil {
+		return err
+	}
+	logger.LogDownloadEvent("CONFIG", fmt.Sprintf("Moved %d downloads from the config file to %s", len(downloads), GetDownloadsPath()))
+	return nil
+}
+
+// migrateHistoryDefaults adds the default history retention to configs
+// written before the download history existed
+func migrateHistoryDefaults(doc map[string]any) error {
+	if _, exists := doc["history"]; exists {
+		return nil
+	}
+	doc["history"] = map[string]any{
+		"retention_days": defaultHistoryConfig.RetentionDays,
+		"max_entries":    defaultHistoryConfig.MaxEntries,
+	}
+	return nil
+}
+
+// legacySavePath is the save path older versions used by default, relative to
+// wherever the program happened to be started
+const legacySavePath = "downloads"
+
+// migrateDefaultPaths moves the relative default save paths of older
+// versions into the user's download directory. Paths the user chose are kept.
+// Existing downloads keep the paths they were started with.
+func migrateDefaultPaths(doc map[string]any) error {
+	saveDir := GetDownloadDir()
+	if savePath, _ := doc["save_path"].(string); savePath == legacySavePath {
+		doc["save_path"] = saveDir
+	}
+
+	queues, _ := doc["queues"].([]any)
+	for _, item := range queues {
+		queue, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("unexpected queue entry %v", item)
+		}
+		name, _ := queue["name"].(string)
+		if path, _ := queue["path"].(string); name != "" && path == legacySavePath+"/"+name {
+			queue["path"] = filepath.Join(saveDir, name)
+		}
+	}
+	return nil
+}
+
+// migrateGlobalLimits adds the global limits, off by default, to configs
+// written before they existed
+func migrateGlobalLimits(doc map[string]any) error {
+	if _, exists := doc["limits"]; exists {
+		return nil
+	}
+	doc["limits"] = map[string]any{
+		"max_active":  0,
+		"speed_limit": 0,
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigFile writes data to a config file in a temporary directory
+// and returns its path
+func writeConfigFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const queuesJSON = `"default_queue": "default", "save_path": "/tmp/dl",
+	"queues": [{"name": "default", "max_concurrent": 3, "start_time": "00:00", "end_time": "23:59", "enabled": true, "path": "/tmp/dl/default"}],
+	"history": {"retention_days": 90, "max_entries": 1000}`
+
+func TestMigrateGlobalLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want LimitsConfig
+	}{
+		{"version 4 without limits", `{"schema_version": 4, ` + queuesJSON + `}`, LimitsConfig{}},
+		{"version 5 keeps limits", `{"schema_version": 5, ` + queuesJSON + `, "limits": {"max_active": 4, "speed_limit": 512}}`, LimitsConfig{MaxActive: 4, SpeedLimit: 512}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ReadConfig(writeConfigFile(t, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SchemaVersion != CurrentSchemaVersion {
+				t.Errorf("schema version %d, want %d", cfg.SchemaVersion, CurrentSchemaVersion)
+			}
+			if cfg.Limits != tt.want {
+				t.Errorf("limits %+v, want %+v", cfg.Limits, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidLimitsAreReported(t *testing.T) {
+	_, err := ReadConfig(writeConfigFile(t, `{"schema_version": 5, `+queuesJSON+`, "limits": {"max_active": -1, "speed_limit": -2}}`))
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("ReadConfig returned %v, want a ValidationError", err)
+	}
+	paths := map[string]bool{}
+	for _, p := range invalid.Problems {
+		paths[p.Path] = true
+	}
+	if !paths["limits.max_active"] || !paths["limits.speed_limit"] {
+		t.Errorf("problems %v, want both limits reported", invalid.Problems)
+	}
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	_, err := ReadConfig(writeConfigFile(t, `{"schema_version": 99, `+queuesJSON+`}`))
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("ReadConfig returned %v, want %v", err, ErrNewerSchema)
+	}
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+)
+
+// Problem is a single invalid value in a config, located by its JSON path
+type Problem struct {
+	Path    string `json:"path"` // e.g. "queues[1].max_concurrent"
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError lists every problem found in a config
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	if len(e.Problems) == 1 {
+		lines = append(lines, "invalid config:")
+	} else {
+		lines = append(lines, fmt.Sprintf("invalid config (%d problems):", len(e.Problems)))
+	}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// problems collects validation problems
+type problems []Problem
+
+func (ps *problems) add(path, format string, args ...any) {
+	*ps = append(*ps, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected problems as a *ValidationError, or nil if there are none
+func (ps problems) err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: ps}
+}
+
+// Validate checks the config and returns a *ValidationError listing every
+// problem found, or nil if the config is valid
+func (c *Config) Validate() error {
+	var ps problems
+	c.validate(&ps)
+	return ps.err()
+}
+
+// ValidateDownloads is like Validate, but also reports downloads that belong
+// to a queue the config doesn't have
+func (c *Config) ValidateDownloads(downloads []*downloader.Download) error {
+	var ps problems
+	c.validate(&ps)
+	for _, d := range downloads {
+		if c.GetQueue(d.Queue) == nil {
+			ps.add(fmt.Sprintf("downloads[%s].queue", d.ID), "queue %q does not exist", d.Queue)
+		}
+	}
+	return ps.err()
+}
+
+// validate adds the problems of the config itself to ps
+func (c *Config) validate(ps *problems) {
+	if c.DefaultQueue == "" {
+		ps.add("default_queue", "must name a queue")
+	} else if c.GetQueue(c.DefaultQueue) == nil {
+		ps.add("default_queue", "queue %q does not exist", c.DefaultQueue)
+	}
+
+	if len(c.Queues) == 0 {
+		ps.add("queues", "at least one queue is required")
+	}
+
+	seen := make(map[string]int)
+	for i, q := range c.Queues {
+		path := fmt.Sprintf("queues[%d]", i)
+
+		if strings.TrimSpace(q.Name) == "" {
+			ps.add(path+".name", "must not be empty")
+		} else if first, exists := seen[q.Name]; exists {
+			ps.add(path+".name", "duplicate of queues[%d].name %q", first, q.Name)
+		} else {
+			seen[q.Name] = i
+		}
+
+		if q.MaxConcurrent < 1 {
+			ps.add(path+".max_concurrent", "must be at least 1, got %d", q.MaxConcurrent)
+		}
+		if q.SpeedLimit < 0 {
+			ps.add(path+".speed_limit", "must be 0 (unlimited) or positive, got %d", q.SpeedLimit)
+		}
+		if err := validateClock(q.StartTime); err != nil {
+			ps.add(path+".start_time", "%v", err)
+		}
+		if err := validateClock(q.EndTime); err != nil {
+			ps.add(path+".end_time", "%v", err)
+		}
+	}
+
+	if c.History.RetentionDays < 0 {
+		ps.add("history.retention_days", "must be 0 (keep forever) or positive, got %d", c.History.RetentionDays)
+	}
+	if c.History.MaxEntries < 0 {
+		ps.add("history.max_entries", "must be 0 (no limit) or positive, got %d", c.History.MaxEntries)
+	}
+
+	if c.Limits.MaxActive < 0 {
+		ps.add("limits.max_active", "must be 0 (no limit) or positive, got %d", c.Limits.MaxActive)
+	}
+	if c.Limits.SpeedLimit < 0 {
+		ps.add("limits.speed_limit", "must be 0 (unlimited) or positive, got %d", c.Limits.SpeedLimit)
+	}
+}
+
+// validateClock checks that s is a time of day in the zero-padded "HH:MM"
+// format, which IsTimeAllowed compares as a string
+func validateClock(s string) error {
+	if _, err := time.Parse("15:04", s); err != nil || len(s) != len("15:04") {
+		return fmt.Errorf("%q is not a valid time, expected HH:MM between 00:00 and 23:59", s)
+	}
+	return nil
+}
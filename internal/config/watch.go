@@ -0,0 +1,134 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// watchDebounce is how long the watcher waits for further writes before
+// reporting a change, since editors often save a file in several steps
+const watchDebounce = 250 * time.Millisecond
+
+// pollInterval is how often the file is checked where change notifications
+// aren't available
+const pollInterval = 2 * time.Second
+
+// errNotifyUnsupported is returned by startNotify on platforms without
+// file change notifications
+var errNotifyUnsupported = errors.New("file change notifications are not supported")
+
+// Watcher reports changes to a file. Files replaced by a rename, as written
+// by SaveConfig and many editors, are noticed as well as writes in place.
+type Watcher struct {
+	path      string
+	changes   chan struct{}
+	done      chan struct{}
+	stop      func() error // Stops the notification mechanism
+	closeOnce sync.Once
+}
+
+// NewWatcher starts watching path, using the platform's change notifications
+// if possible and polling otherwise
+func NewWatcher(path string) *Watcher {
+	w := &Watcher{
+		path:    path,
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	raw := make(chan struct{}, 1)
+	stop, err := startNotify(path, raw)
+	if err != nil {
+		logger.LogDownloadEvent("CONFIG", fmt.Sprintf("Polling %s for changes: %v", path, err))
+		stop = w.startPolling(raw)
+	}
+	w.stop = stop
+
+	go w.debounce(raw)
+	return w
+}
+
+// Changes delivers a value after the file has changed. Changes in quick
+// succession are reported once.
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching the file
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.stop()
+	})
+	return err
+}
+
+// debounce forwards raw notifications to Changes once the file has been
+// quiet for watchDebounce
+func (w *Watcher) debounce(raw <-chan struct{}) {
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-raw:
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			notify(w.changes)
+		}
+	}
+}
+
+// startPolling checks the file's size and modification time every
+// pollInterval and returns a function that stops it
+func (w *Watcher) startPolling(raw chan<- struct{}) func() error {
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(w.path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		lastMod, lastSize := stat()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				mod, size := stat()
+				if !mod.Equal(lastMod) || size != lastSize {
+					lastMod, lastSize = mod, size
+					notify(raw)
+				}
+			}
+		}
+	}()
+
+	return func() error {
+		close(stopped)
+		return nil
+	}
+}
+
+// notify sends on ch without blocking; a pending value already says the same
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
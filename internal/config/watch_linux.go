@@ -0,0 +1,64 @@
+//go:build linux
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask selects the inotify events on the config directory that can
+// change the config file: writes in place and files renamed or created over it
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// startNotify watches the directory of path with inotify and signals raw
+// whenever an event concerns the file itself. The directory is watched
+// rather than the file, because an atomic save replaces the file's inode.
+func startNotify(path string, raw chan<- struct{}) (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), watchMask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// A non-blocking descriptor is handled by the runtime poller, so closing
+	// the file interrupts a pending Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	name := filepath.Base(path)
+
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				if nameEnd > n {
+					break
+				}
+
+				// The name is padded with NUL bytes
+				eventName := string(buf[nameStart:nameEnd])
+				for len(eventName) > 0 && eventName[len(eventName)-1] == 0 {
+					eventName = eventName[:len(eventName)-1]
+				}
+				if eventName == name {
+					notify(raw)
+				}
+				offset = nameEnd
+			}
+		}
+	}()
+
+	return file.Close, nil
+}
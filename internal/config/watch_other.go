@@ -0,0 +1,8 @@
+//go:build !linux
+
+package config
+
+// startNotify is not available on this platform; the watcher polls instead
+func startNotify(path string, raw chan<- struct{}) (func() error, error) {
+	return nil, errNotifyUnsupported
+}
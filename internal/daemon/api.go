@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// apiPrefix is the path all requests are served under, on the socket and
+// over TCP alike. Incompatible changes get a new version.
+const apiPrefix = "/api/v1"
+
+// Kinds of errors, so clients can tell them apart without parsing messages
+const (
+	kindNotFound     = "not_found"
+	kindInvalidURL   = "invalid_url"
+	kindInvalid      = "invalid_config"
+	kindShuttingDown = "shutting_down"
+	kindBadRequest   = "bad_request"
+	kindConflict     = "conflict"
+)
+
+// Status describes a running daemon
+type Status struct {
+	PID       int       `json:"pid"`
+	Profile   string    `json:"profile"`
+	Socket    string    `json:"socket"`
+	Started   time.Time `json:"started"`
+	Downloads int       `json:"downloads"`
+}
+
+// addRequest adds a download
+type addRequest struct {
+	URL string `json:"url"`
+	queue.AddOptions
+}
+
+// moveRequest moves downloads to another queue
+type moveRequest struct {
+	IDs      []string `json:"ids"`
+	Queue    string   `json:"queue"`
+	Relocate bool     `json:"relocate"`
+}
+
+// patchDownloadRequest changes a download. Fields left out stay as they are.
+type patchDownloadRequest struct {
+	Queue      *string `json:"queue"`
+	Relocate   bool    `json:"relocate"`    // With queue, move the file into the new queue's path
+	SpeedLimit *int64  `json:"speed_limit"` // KB/s, 0 for unlimited
+}
+
+// queueResponse describes a queue and the downloads in it
+type queueResponse struct {
+	config.QueueConfig
+	Default bool           `json:"default"`
+	Counts  map[string]int `json:"counts"` // Downloads by status
+}
+
+// Stats sums up the downloads of the daemon
+type Stats struct {
+	Downloads  int                   `json:"downloads"`
+	Counts     map[string]int        `json:"counts"` // Downloads by status
+	Speed      int64                 `json:"speed"`  // Bytes per second of all active downloads
+	Downloaded int64                 `json:"downloaded"`
+	TotalSize  int64                 `json:"total_size"` // Of downloads whose size is known
+	Queues     map[string]QueueStats `json:"queues"`
+	Limits     config.LimitsConfig   `json:"limits"`
+}
+
+// QueueStats sums up the downloads of one queue
+type QueueStats struct {
+	Counts     map[string]int `json:"counts"`
+	Speed      int64          `json:"speed"`
+	Downloaded int64          `json:"downloaded"`
+}
+
+// idResponse names a download that was created
+type idResponse struct {
+	ID string `json:"id"`
+}
+
+// errorResponse is the body of every failed request
+type errorResponse struct {
+	Error    string           `json:"error"`
+	Kind     string           `json:"kind"`
+	Problems []config.Problem `json:"problems,omitempty"` // For invalid_config
+}
+
+// badRequest reports a request that couldn't be understood
+type badRequest struct {
+	msg string
+}
+
+func (e *badRequest) Error() string {
+	return e.msg
+}
+
+func badRequestf(format string, args ...any) error {
+	return &badRequest{msg: fmt.Sprintf(format, args...)}
+}
+
+// writeJSON writes v as the body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as an errorResponse with a status that fits it
+func writeError(w http.ResponseWriter, err error) {
+	var invalid *config.ValidationError
+	var bad *badRequest
+	resp := errorResponse{Error: err.Error()}
+	status := http.StatusConflict
+	switch {
+	case errors.As(err, &bad):
+		status, resp.Kind = http.StatusBadRequest, kindBadRequest
+	case errors.Is(err, queue.ErrInvalidURL):
+		status, resp.Kind = http.StatusBadRequest, kindInvalidURL
+	case errors.Is(err, queue.ErrNotFound):
+		status, resp.Kind = http.StatusNotFound, kindNotFound
+	case errors.Is(err, queue.ErrShuttingDown):
+		status, resp.Kind = http.StatusServiceUnavailable, kindShuttingDown
+	case errors.As(err, &invalid):
+		status, resp.Kind = http.StatusUnprocessableEntity, kindInvalid
+		resp.Problems = invalid.Problems
+	default:
+		// The download or queue is in a state that doesn't allow the change
+		resp.Kind = kindConflict
+	}
+	writeJSON(w, status, resp)
+}
+
+// remoteError is an error returned by the daemon. It wraps the error of the
+// same kind in this process, so errors.Is works as it does on a local manager.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// decodeError turns an errorResponse back into an error
+func (r errorResponse) decodeError() error {
+	switch r.Kind {
+	case kindInvalid:
+		return &config.ValidationError{Problems: r.Problems}
+	case kindInvalidURL:
+		return &remoteError{msg: r.Error, err: queue.ErrInvalidURL}
+	case kindNotFound:
+		return &remoteError{msg: r.Error, err: queue.ErrNotFound}
+	case kindShuttingDown:
+		return &remoteError{msg: r.Error, err: queue.ErrShuttingDown}
+	}
+	return errors.New(r.Error)
+}
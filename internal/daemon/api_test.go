@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// decodeErrorResponse decodes the errorResponse written to rec
+func decodeErrorResponse(t *testing.T, rec *httptest.ResponseRecorder) errorResponse {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", ct)
+	}
+	var e errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatalf("%v in %s", err, rec.Body)
+	}
+	return e
+}
+
+func TestWriteError(t *testing.T) {
+	invalid := &config.ValidationError{Problems: []config.Problem{{Path: "limits.max_active", Message: "must be 0 (no limit) or positive, got -1"}}}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		kind   string
+		is     error // Sentinel the decoded error must match, if any
+	}{
+		{"bad request", badRequestf("invalid request body"), http.StatusBadRequest, kindBadRequest, nil},
+		{"invalid URL", fmt.Errorf("%w: unsupported protocol", queue.ErrInvalidURL), http.StatusBadRequest, kindInvalidURL, queue.ErrInvalidURL},
+		{"not found", fmt.Errorf("download x %w", queue.ErrNotFound), http.StatusNotFound, kindNotFound, queue.ErrNotFound},
+		{"shutting down", queue.ErrShuttingDown, http.StatusServiceUnavailable, kindShuttingDown, queue.ErrShuttingDown},
+		{"validation", fmt.Errorf("saving: %w", invalid), http.StatusUnprocessableEntity, kindInvalid, nil},
+		{"anything else", errors.New("cannot pause download x: it is completed"), http.StatusConflict, kindConflict, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, tt.err)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			e := decodeErrorResponse(t, rec)
+			if e.Kind != tt.kind || e.Error != tt.err.Error() {
+				t.Fatalf("response %+v, want kind %s and message %q", e, tt.kind, tt.err)
+			}
+
+			// Clients get back an error that matches like the original
+			decoded := e.decodeError()
+			if decoded.Error() != tt.err.Error() && tt.kind != kindInvalid {
+				t.Errorf("decoded message %q, want %q", decoded, tt.err)
+			}
+			if tt.is != nil && !errors.Is(decoded, tt.is) {
+				t.Errorf("decoded error %v doesn't match %v", decoded, tt.is)
+			}
+			var validation *config.ValidationError
+			if tt.kind == kindInvalid && (!errors.As(decoded, &validation) || len(validation.Problems) != 1) {
+				t.Errorf("decoded error %v doesn't carry the problems", decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// aria2Path is where aria2 serves JSON-RPC, and where its clients look
+const aria2Path = "/jsonrpc"
+
+// aria2Version is the version of aria2 whose API is mimicked, reported to
+// clients that check which features they can use
+const aria2Version = "1.37.0"
+
+// JSON-RPC error codes. aria2 reports its own errors with code 1.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcAria2Error     = 1
+)
+
+// rpcRequest is a JSON-RPC 2.0 call
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// rpcResponse answers an rpcRequest with either a result or an error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParamsf(format string, args ...any) error {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// aria2 answers a subset of aria2's JSON-RPC interface on a queue manager,
+// so that tools made for aria2 can drive it. GIDs are download IDs, which
+// have the same form. Removed downloads are gone rather than kept as
+// "removed", and only the options listed in addUri and changeOption apply;
+// others are ignored.
+type aria2 struct {
+	manager *queue.Manager
+	secret  string // Expected as "token:SECRET" before the other parameters, empty for none
+}
+
+// aria2Methods are the supported methods, by name
+var aria2Methods = map[string]func(a *aria2, params []json.RawMessage) (any, error){
+	"aria2.addUri":        (*aria2).addURI,
+	"aria2.tellStatus":    (*aria2).tellStatus,
+	"aria2.tellActive":    (*aria2).tellActive,
+	"aria2.tellWaiting":   (*aria2).tellWaiting,
+	"aria2.tellStopped":   (*aria2).tellStopped,
+	"aria2.pause":         (*aria2).pause,
+	"aria2.forcePause":    (*aria2).pause,
+	"aria2.unpause":       (*aria2).unpause,
+	"aria2.remove":        (*aria2).remove,
+	"aria2.forceRemove":   (*aria2).remove,
+	"aria2.changeOption":  (*aria2).changeOption,
+	"aria2.getGlobalStat": (*aria2).getGlobalStat,
+	"aria2.getVersion":    (*aria2).getVersion,
+}
+
+// ServeHTTP answers a call, or a batch of calls in an array, posted as JSON.
+// Browser frontends call from pages of other origins, so any origin may
+// call and preflight requests are answered. The secret travels inside the
+// calls rather than in cookies, so this lets no one in who lacks it.
+func (a *aria2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost+", "+http.MethodOptions)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		allow(w, r, http.MethodPost+", "+http.MethodOptions)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcParseError, Message: "Parse error"}})
+		return
+	}
+
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"}})
+			return
+		}
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, call := range batch {
+			responses = append(responses, a.call(call))
+		}
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.call(body))
+}
+
+// call runs a single call
+func (a *aria2) call(body json.RawMessage) rpcResponse {
+	var req rpcRequest
+	resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	if err := json.Unmarshal(body, &req); err != nil || req.Method == "" {
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "Invalid Request"}
+		return resp
+	}
+	if req.ID != nil {
+		resp.ID = req.ID
+	}
+
+	method, ok := aria2Methods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: "No such method: " + req.Method}
+		return resp
+	}
+	params, ok := a.authorize(req.Params)
+	if !ok {
+		resp.Error = &rpcError{Code: rpcAria2Error, Message: "Unauthorized"}
+		return resp
+	}
+
+	result, err := method(a, params)
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcAria2Error, Message: err.Error()}
+		}
+		logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("aria2 call %s failed: %v", req.Method, err))
+		resp.Error = rpcErr
+		return resp
+	}
+	resp.Result = result
+	return resp
+}
+
+// authorize checks the "token:SECRET" parameter and returns the parameters
+// after it
+func (a *aria2) authorize(params []json.RawMessage) ([]json.RawMessage, bool) {
+	var token string
+	if len(params) > 0 && json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, "token:") {
+		params = params[1:]
+	} else {
+		token = ""
+	}
+	if a.secret == "" {
+		return params, true
+	}
+	ok := subtle.ConstantTimeCompare([]byte(token), []byte("token:"+a.secret)) == 1
+	return params, ok
+}
+
+// param decodes the parameter at index i into v. Optional parameters that
+// weren't given leave v as it is.
+func param(params []json.RawMessage, i int, v any, required bool) error {
+	if i >= len(params) {
+		if required {
+			return invalidParamsf("missing parameter %d", i+1)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return invalidParamsf("parameter %d: %v", i+1, err)
+	}
+	return nil
+}
+
+// find returns the download with the GID in the first parameter
+func (a *aria2) find(params []json.RawMessage) (downloader.Snapshot, error) {
+	var gid string
+	if err := param(params, 0, &gid, true); err != nil {
+		return downloader.Snapshot{}, err
+	}
+	s, ok := findSnapshot(a.manager.Snapshot(), gid)
+	if !ok {
+		return s, fmt.Errorf("GID %s is not found", gid)
+	}
+	return s, nil
+}
+
+// addURI adds a download of the first URI; the others would be mirrors,
+// which aren't supported. Of the options, dir, out and max-download-limit
+// apply.
+func (a *aria2) addURI(params []json.RawMessage) (any, error) {
+	var uris []string
+	var options map[string]string
+	if err := param(params, 0, &uris, true); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &options, false); err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, invalidParamsf("no URI to download")
+	}
+
+	// The manager resolves dir and out against the default queue and
+	// rejects an out that would leave the directory
+	opts := queue.AddOptions{Dir: options["dir"], Filename: options["out"]}
+	id, err := a.manager.Add(uris[0], opts)
+	if err != nil {
+		return nil, err
+	}
+	if limit, ok := options["max-download-limit"]; ok {
+		if err := a.setSpeedLimit(id, limit); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// setSpeedLimit applies an aria2 max-download-limit, in bytes per second
+// with an optional K or M suffix
+func (a *aria2) setSpeedLimit(id, limit string) error {
+	bytesPerSecond, err := parseAria2Size(limit)
+	if err != nil {
+		return invalidParamsf("max-download-limit: %v", err)
+	}
+	// Round up, so a small limit doesn't become unlimited
+	return a.manager.SetSpeedLimit(id, (bytesPerSecond+1023)/1024)
+}
+
+// parseAria2Size parses a size like aria2 does: bytes with an optional
+// K or M suffix, powers of 1024
+func parseAria2Size(s string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		multiplier, s = 1<<10, s[:len(s)-1]
+	case strings.HasSuffix(s, "M"), strings.HasSuffix(s, "m"):
+		multiplier, s = 1<<20, s[:len(s)-1]
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return value * multiplier, nil
+}
+
+func (a *aria2) tellStatus(params []json.RawMessage) (any, error) {
+	s, err := a.find(params)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := param(params, 1, &keys, false); err != nil {
+		return nil, err
+	}
+	return aria2Status(s, keys), nil
+}
+
+func (a *aria2) tellActive(params []json.RawMessage) (any, error) {
+	var keys []string
+	if err := param(params, 0, &keys, false); err != nil {
+		return nil, err
+	}
+	return a.list(keys, "active"), nil
+}
+
+func (a *aria2) tellWaiting(params []json.RawMessage) (any, error) {
+	return a.tellRange(params, "waiting", "paused")
+}
+
+func (a *aria2) tellStopped(params []json.RawMessage) (any, error) {
+	return a.tellRange(params, "complete", "error", "removed")
+}
+
+// tellRange answers tellWaiting and tellStopped, which take an offset, a
+// number of downloads and keys
+func (a *aria2) tellRange(params []json.RawMessage, statuses ...string) (any, error) {
+	var offset, num int
+	var keys []string
+	if err := param(params, 0, &offset, true); err != nil {
+		return nil, err
+	}
+	if err := param(params, 1, &num, true); err != nil {
+		return nil, err
+	}
+	if err := param(params, 2, &keys, false); err != nil {
+		return nil, err
+	}
+	return window(a.list(keys, statuses...), offset, num), nil
+}
+
+// list returns the downloads whose aria2 status is one of statuses, in the
+// order they were added
+func (a *aria2) list(keys []string, statuses ...string) []map[string]any {
+	list := []map[string]any{}
+	for _, s := range a.manager.Snapshot() {
+		status := aria2StatusOf(s.Status)
+		for _, want := range statuses {
+			if status == want {
+				list = append(list, aria2Status(s, keys))
+				break
+			}
+		}
+	}
+	return list
+}
+
+// window returns num items starting at offset. A negative offset counts from
+// the end, and the items are then returned in reverse, like aria2 does.
+func window(items []map[string]any, offset, num int) []map[string]any {
+	result := []map[string]any{}
+	if offset >= 0 {
+		for i := offset; i < len(items) && len(result) < num; i++ {
+			result = append(result, items[i])
+		}
+		return result
+	}
+	for i := len(items) + offset; i >= 0 && len(result) < num; i-- {
+		if i < len(items) {
+			result = append(result, items[i])
+		}
+	}
+	return result
+}
+
+func (a *aria2) pause(params []json.RawMessage) (any, error) {
+	s, err := a.find(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.manager.PauseDownload(s.ID); err != nil {
+		return nil, err
+	}
+	return s.ID, nil
+}
+
+func (a *aria2) unpause(params []json.RawMessage) (any, error) {
+	s, err := a.find(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.manager.ResumeDownload(s.ID); err != nil {
+		return nil, err
+	}
+	return s.ID, nil
+}
+
+func (a *aria2) remove(params []json.RawMessage) (any, error) {
+	s, err := a.find(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.manager.RemoveDownload(s.ID); err != nil {
+		return nil, err
+	}
+	return s.ID, nil
+}
+
+// changeOption applies max-download-limit; other options are ignored
+func (a *aria2) changeOption(params []json.RawMessage) (any, error) {
+	s, err := a.find(params)
+	if err != nil {
+		return nil, err
+	}
+	var options map[string]string
+	if err := param(params, 1, &options, true); err != nil {
+		return nil, err
+	}
+	if limit, ok := options["max-download-limit"]; ok {
+		if err := a.setSpeedLimit(s.ID, limit); err != nil {
+			return nil, err
+		}
+	}
+	return "OK", nil
+}
+
+func (a *aria2) getGlobalStat(params []json.RawMessage) (any, error) {
+	var speed int64
+	var active, waiting, stopped int
+	for _, s := range a.manager.Snapshot() {
+		switch aria2StatusOf(s.Status) {
+		case "active":
+			active++
+			speed += s.Speed
+		case "waiting", "paused":
+			waiting++
+		default:
+			stopped++
+		}
+	}
+	return map[string]string{
+		"downloadSpeed":   strconv.FormatInt(speed, 10),
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(active),
+		"numWaiting":      strconv.Itoa(waiting),
+		"numStopped":      strconv.Itoa(stopped),
+		"numStoppedTotal": strconv.Itoa(stopped),
+	}, nil
+}
+
+func (a *aria2) getVersion(params []json.RawMessage) (any, error) {
+	return map[string]any{"version": aria2Version, "enabledFeatures": []string{"HTTPS"}}, nil
+}
+
+// aria2StatusOf maps a status to aria2's
+func aria2StatusOf(status downloader.Status) string {
+	switch status {
+	case downloader.StatusDownloading, downloader.StatusVerifying, downloader.StatusPostProcessing:
+		return "active"
+	case downloader.StatusPending, downloader.StatusScheduled:
+		return "waiting"
+	case downloader.StatusPaused:
+		return "paused"
+	case downloader.StatusCompleted:
+		return "complete"
+	case downloader.StatusCancelled:
+		return "removed"
+	}
+	return "error"
+}
+
+// aria2Status describes a download like aria2's tellStatus, with only the
+// given keys if there are any. Numbers are strings, as in aria2.
+func aria2Status(s downloader.Snapshot, keys []string) map[string]any {
+	status := aria2StatusOf(s.Status)
+	connections, speed, errorCode := "0", "0", "0"
+	if status == "active" {
+		connections, speed = "1", strconv.FormatInt(s.Speed, 10)
+	}
+	if status == "error" {
+		errorCode = "1" // aria2's unknown error
+	}
+	total := strconv.FormatInt(s.TotalSize, 10)
+	completed := strconv.FormatInt(s.Downloaded, 10)
+
+	all := map[string]any{
+		"gid":             s.ID,
+		"status":          status,
+		"totalLength":     total,
+		"completedLength": completed,
+		"uploadLength":    "0",
+		"downloadSpeed":   speed,
+		"uploadSpeed":     "0",
+		"connections":     connections,
+		"dir":             filepath.Dir(s.TargetPath),
+		"errorCode":       errorCode,
+		"files": []map[string]any{{
+			"index":           "1",
+			"path":            s.TargetPath,
+			"length":          total,
+			"completedLength": completed,
+			"selected":        "true",
+			"uris":            []map[string]string{{"uri": s.URL, "status": "used"}},
+		}},
+	}
+	if s.Error != "" {
+		all["errorMessage"] = s.Error
+	}
+	if len(keys) == 0 {
+		return all
+	}
+
+	picked := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if value, ok := all[key]; ok {
+			picked[key] = value
+		}
+	}
+	return picked
+}
@@ -0,0 +1,392 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// newTestAria2 serves the aria2 adapter of a new test manager, with
+// testToken as its secret
+func newTestAria2(t *testing.T) (*httptest.Server, *queue.Manager) {
+	t.Helper()
+	m := newTestManager(t)
+	srv := httptest.NewServer(&aria2{manager: m, secret: testToken})
+	t.Cleanup(srv.Close)
+	return srv, m
+}
+
+// post sends body to the adapter and returns the response with its body
+func post(t *testing.T, srv *httptest.Server, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+aria2Path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// testResponse is an rpcResponse with its result left encoded
+type testResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// rpc calls method with the secret and params and returns the response
+func rpc(t *testing.T, srv *httptest.Server, method string, params ...any) testResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      "1",
+		"method":  method,
+		"params":  append([]any{"token:" + testToken}, params...),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data := post(t, srv, string(body))
+	var resp testResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", data, err)
+	}
+	return resp
+}
+
+// result decodes the result of a successful call into v
+func (r testResponse) result(t *testing.T, v any) {
+	t.Helper()
+	if r.Error != nil {
+		t.Fatalf("call failed: %d %s", r.Error.Code, r.Error.Message)
+	}
+	if err := json.Unmarshal(r.Result, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectCode fails the test unless the call failed with code
+func (r testResponse) expectCode(t *testing.T, code int) {
+	t.Helper()
+	if r.Error == nil {
+		t.Fatalf("call succeeded with %s, want error %d", r.Result, code)
+	}
+	if r.Error.Code != code {
+		t.Fatalf("error %d %q, want code %d", r.Error.Code, r.Error.Message, code)
+	}
+}
+
+// snapshot returns the download with id
+func snapshot(t *testing.T, m *queue.Manager, id string) downloader.Snapshot {
+	t.Helper()
+	s, ok := findSnapshot(m.Snapshot(), id)
+	if !ok {
+		t.Fatalf("download %s not found", id)
+	}
+	return s
+}
+
+func TestAria2AddURI(t *testing.T) {
+	srv, m := newTestAria2(t)
+	dir := t.TempDir()
+	queueDir := m.Config().GetQueue("default").Path
+
+	tests := []struct {
+		name      string
+		uris      []string
+		options   map[string]string
+		code      int    // Expected error code, 0 for success
+		path      string // Expected target path
+		bandwidth int64  // Expected speed limit in KB/s
+	}{
+		{"plain", []string{"http://example.com/a.bin"}, nil, 0, filepath.Join(queueDir, "a.bin"), 0},
+		{"dir", []string{"http://example.com/b.bin"}, map[string]string{"dir": dir}, 0, filepath.Join(dir, "b.bin"), 0},
+		{"dir and out", []string{"http://example.com/c.bin"}, map[string]string{"dir": dir, "out": "renamed.bin"}, 0, filepath.Join(dir, "renamed.bin"), 0},
+		{"out", []string{"http://example.com/d.bin"}, map[string]string{"out": "named.bin"}, 0, filepath.Join(queueDir, "named.bin"), 0},
+		{"out with dots", []string{"http://example.com/d.bin"}, map[string]string{"out": "v1..2.tar.gz"}, 0, filepath.Join(queueDir, "v1..2.tar.gz"), 0},
+		{"mirrors", []string{"http://example.com/e.bin", "http://mirror.example.com/e.bin"}, nil, 0, filepath.Join(queueDir, "e.bin"), 0},
+		{"speed limit", []string{"http://example.com/f.bin"}, map[string]string{"max-download-limit": "2K"}, 0, filepath.Join(queueDir, "f.bin"), 2},
+		{"small speed limit rounds up", []string{"http://example.com/g.bin"}, map[string]string{"max-download-limit": "100"}, 0, filepath.Join(queueDir, "g.bin"), 1},
+		{"out with a path", []string{"http://example.com/h.bin"}, map[string]string{"out": "../h.bin"}, rpcAria2Error, "", 0},
+		{"bad speed limit", []string{"http://example.com/i.bin"}, map[string]string{"max-download-limit": "fast"}, rpcInvalidParams, "", 0},
+		{"no URI", []string{}, nil, rpcInvalidParams, "", 0},
+		{"bad URI", []string{"ftp://example.com/j.bin"}, nil, rpcAria2Error, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := []any{tt.uris}
+			if tt.options != nil {
+				params = append(params, tt.options)
+			}
+			resp := rpc(t, srv, "aria2.addUri", params...)
+			if tt.code != 0 {
+				resp.expectCode(t, tt.code)
+				return
+			}
+			var gid string
+			resp.result(t, &gid)
+			s := snapshot(t, m, gid)
+			if s.TargetPath != tt.path {
+				t.Errorf("target path %s, want %s", s.TargetPath, tt.path)
+			}
+			if s.URL != tt.uris[0] {
+				t.Errorf("URL %s, want the first URI %s", s.URL, tt.uris[0])
+			}
+			if s.MaxBandwidth != tt.bandwidth {
+				t.Errorf("speed limit %d KB/s, want %d", s.MaxBandwidth, tt.bandwidth)
+			}
+		})
+	}
+
+	// A missing parameter is reported rather than taken as no URIs
+	rpc(t, srv, "aria2.addUri").expectCode(t, rpcInvalidParams)
+}
+
+func TestAria2TellStatus(t *testing.T) {
+	srv, m := newTestAria2(t)
+	id, err := m.AddURL("http://example.com/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status map[string]any
+	rpc(t, srv, "aria2.tellStatus", id).result(t, &status)
+	for key, want := range map[string]any{"gid": id, "status": "waiting", "completedLength": "0", "errorCode": "0"} {
+		if status[key] != want {
+			t.Errorf("%s = %v, want %v", key, status[key], want)
+		}
+	}
+	files, _ := status["files"].([]any)
+	if len(files) != 1 {
+		t.Fatalf("files = %v, want one", status["files"])
+	}
+
+	// Only the keys asked for
+	status = nil
+	rpc(t, srv, "aria2.tellStatus", id, []string{"gid", "status", "bogus"}).result(t, &status)
+	if len(status) != 2 || status["gid"] != id || status["status"] != "waiting" {
+		t.Errorf("status with keys = %v, want gid and status", status)
+	}
+
+	rpc(t, srv, "aria2.tellStatus", "0123456789abcdef").expectCode(t, rpcAria2Error)
+	rpc(t, srv, "aria2.tellStatus").expectCode(t, rpcInvalidParams)
+	rpc(t, srv, "aria2.tellStatus", 42).expectCode(t, rpcInvalidParams)
+}
+
+func TestAria2PauseUnpauseRemove(t *testing.T) {
+	srv, m := newTestAria2(t)
+	id, err := m.AddURL("http://example.com/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		method string
+		code   int               // Expected error code, 0 for success
+		status downloader.Status // Status after the call, "" if removed
+	}{
+		{"aria2.pause", 0, downloader.StatusPaused},
+		{"aria2.forcePause", rpcAria2Error, downloader.StatusPaused}, // Already paused
+		{"aria2.unpause", 0, downloader.StatusPending},
+		{"aria2.unpause", rpcAria2Error, downloader.StatusPending}, // Not paused
+		{"aria2.remove", 0, ""},
+		{"aria2.forceRemove", rpcAria2Error, ""}, // Gone
+	}
+	for _, step := range steps {
+		resp := rpc(t, srv, step.method, id)
+		if step.code != 0 {
+			resp.expectCode(t, step.code)
+		} else {
+			var gid string
+			resp.result(t, &gid)
+			if gid != id {
+				t.Errorf("%s returned %q, want the GID %s", step.method, gid, id)
+			}
+		}
+
+		s, found := findSnapshot(m.Snapshot(), id)
+		switch {
+		case step.status == "" && found:
+			t.Fatalf("after %s the download is still there, %s", step.method, s.Status)
+		case step.status != "" && (!found || s.Status != step.status):
+			t.Fatalf("after %s the download is %s (found %v), want %s", step.method, s.Status, found, step.status)
+		}
+	}
+}
+
+func TestAria2Auth(t *testing.T) {
+	srv, _ := newTestAria2(t)
+	tests := []struct {
+		name   string
+		params string
+	}{
+		{"wrong secret", `["token:wrong"]`},
+		{"no secret", `[]`},
+		{"secret without prefix", `["` + testToken + `"]`},
+		{"no params", `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, data := post(t, srv, `{"jsonrpc":"2.0","id":7,"method":"aria2.getVersion","params":`+tt.params+`}`)
+			var resp testResponse
+			if err := json.Unmarshal(data, &resp); err != nil {
+				t.Fatal(err)
+			}
+			resp.expectCode(t, rpcAria2Error)
+			if resp.Error.Message != "Unauthorized" || string(resp.ID) != "7" {
+				t.Errorf("response %s, want Unauthorized for id 7", data)
+			}
+		})
+	}
+}
+
+func TestAria2BadRequests(t *testing.T) {
+	srv, _ := newTestAria2(t)
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"unknown method", `{"jsonrpc":"2.0","id":"1","method":"aria2.shutdown","params":["token:secret"]}`, rpcMethodNotFound},
+		{"no method", `{"jsonrpc":"2.0","id":"1"}`, rpcInvalidRequest},
+		{"not JSON", `{"jsonrpc":`, rpcParseError},
+		{"empty batch", `[]`, rpcInvalidRequest},
+		{"wrong params", `{"jsonrpc":"2.0","id":"1","method":"aria2.tellWaiting","params":["token:secret","zero",1]}`, rpcInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, data := post(t, srv, tt.body)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("HTTP status %d, want errors reported in a 200 response", resp.StatusCode)
+			}
+			var r testResponse
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatal(err)
+			}
+			r.expectCode(t, tt.code)
+		})
+	}
+}
+
+func TestAria2Batch(t *testing.T) {
+	srv, m := newTestAria2(t)
+	token := `"token:` + testToken + `"`
+	body := fmt.Sprintf(`[
+		{"jsonrpc":"2.0","id":"add","method":"aria2.addUri","params":[%s,["http://example.com/a.bin"]]},
+		{"jsonrpc":"2.0","id":2,"method":"aria2.getVersion","params":[%s]},
+		{"jsonrpc":"2.0","id":"bogus","method":"aria2.bogus","params":[%s]},
+		{"jsonrpc":"2.0","id":"waiting","method":"aria2.tellWaiting","params":[%s,0,10,["gid"]]}
+	]`, token, token, token, token)
+	_, data := post(t, srv, body)
+
+	var responses []testResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("invalid batch response %s: %v", data, err)
+	}
+	if len(responses) != 4 {
+		t.Fatalf("%d responses, want 4", len(responses))
+	}
+	for i, id := range []string{`"add"`, `2`, `"bogus"`, `"waiting"`} {
+		if string(responses[i].ID) != id {
+			t.Errorf("response %d has id %s, want %s", i, responses[i].ID, id)
+		}
+	}
+
+	var gid string
+	responses[0].result(t, &gid)
+	var version map[string]any
+	responses[1].result(t, &version)
+	if version["version"] != aria2Version {
+		t.Errorf("version = %v, want %s", version["version"], aria2Version)
+	}
+	responses[2].expectCode(t, rpcMethodNotFound)
+	var waiting []map[string]string
+	responses[3].result(t, &waiting)
+	if len(waiting) != 1 || waiting[0]["gid"] != gid {
+		t.Errorf("waiting = %v, want the download added in the same batch", waiting)
+	}
+	if n := len(m.Snapshot()); n != 1 {
+		t.Errorf("%d downloads, want 1", n)
+	}
+}
+
+func TestAria2CORS(t *testing.T) {
+	srv, _ := newTestAria2(t)
+
+	// A browser's preflight carries no secret and must still be answered
+	req, err := http.NewRequest(http.MethodOptions, srv.URL+aria2Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://ariang.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight status %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("preflight %s = %q, want %q", header, got, want)
+		}
+	}
+
+	// The call itself may be read by the page
+	resp, _ = post(t, srv, `{"jsonrpc":"2.0","id":"1","method":"aria2.getVersion","params":["token:secret"]}`)
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q on a call, want *", got)
+	}
+
+	resp, err = http.Get(srv.URL + aria2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("GET answered %d with Allow %q, want 405 with POST, OPTIONS", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+}
+
+func TestParseAria2Size(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1000", 1000, true},
+		{"2K", 2048, true},
+		{"2k", 2048, true},
+		{"3M", 3 << 20, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1G", 0, false},
+		{"K", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseAria2Size(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseAria2Size(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
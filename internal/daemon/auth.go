@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+)
+
+// EnvToken sets the token of the TCP API instead of the token file
+const EnvToken = "DM_API_TOKEN"
+
+// kindUnauthorized is the kind of error for requests without a valid token
+const kindUnauthorized = "unauthorized"
+
+// loadToken returns the token clients of the TCP API must send: $DM_API_TOKEN,
+// or the token in the data directory, which is created on first use. source
+// tells the user where to find it.
+func loadToken() (token, source string, err error) {
+	if token := os.Getenv(EnvToken); token != "" {
+		return token, "$" + EnvToken, nil
+	}
+
+	path := config.GetTokenPath()
+	data, err := os.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), path, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("failed to read API token: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token = hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	// Like the socket, the token gives full control over the downloads
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to save API token: %w", err)
+	}
+	return token, path, nil
+}
+
+// requireToken lets requests through to next only if they carry token as
+// a bearer token. The OpenAPI document is open to all.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == apiPrefix+"/openapi.json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="download-manager"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing or invalid API token", Kind: kindUnauthorized})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := requireToken(testToken, next)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string // Authorization header, "" for none
+		status int
+	}{
+		{"no token", "/downloads", "", http.StatusUnauthorized},
+		{"wrong token", "/downloads", "Bearer wrong", http.StatusUnauthorized},
+		{"token prefix", "/downloads", "Bearer " + testToken[:3], http.StatusUnauthorized},
+		{"other scheme", "/downloads", "Basic " + testToken, http.StatusUnauthorized},
+		{"no scheme", "/downloads", testToken, http.StatusUnauthorized},
+		{"valid token", "/downloads", "Bearer " + testToken, http.StatusTeapot},
+		{"OpenAPI document without token", "/openapi.json", "", http.StatusTeapot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, apiPrefix+tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusUnauthorized {
+				return
+			}
+			if rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+			e := decodeErrorResponse(t, rec)
+			if e.Kind != kindUnauthorized {
+				t.Errorf("kind %q, want %q", e.Kind, kindUnauthorized)
+			}
+		})
+	}
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	srv, _ := newTestAPI(t)
+	for _, path := range []string{"/downloads", "/queues", "/limits", "/stats", "/config"} {
+		resp, err := srv.Client().Get(srv.URL + apiPrefix + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s without token: status %d, want %d", path, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// ErrNotRunning is returned by Dial when no daemon listens on the socket
+var ErrNotRunning = errors.New("no daemon is running")
+
+// requestTimeout bounds every request except the event stream
+const requestTimeout = 10 * time.Second
+
+// baseURL is the URL requests are made to; the host is ignored since every
+// connection goes to the socket
+const baseURL = "http://daemon" + apiPrefix
+
+// Client is attached to a daemon and works on its queue manager. Events of
+// the daemon are republished on the client's own bus. Reads return the last
+// state received while the daemon can't be reached.
+type Client struct {
+	http   *http.Client
+	stream *http.Client // Without a timeout, for the event stream
+	events *events.Bus
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the event stream has ended
+
+	mutex     sync.Mutex
+	downloads []downloader.Snapshot
+	config    *config.Config
+}
+
+var _ queue.Service = (*Client)(nil)
+
+// Dial attaches to the daemon listening on socket. It returns an error
+// wrapping ErrNotRunning if there is none.
+func Dial(socket string) (*Client, error) {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	c := &Client{
+		http:   &http.Client{Transport: transport, Timeout: requestTimeout},
+		stream: &http.Client{Transport: transport},
+		events: events.NewBus(),
+		done:   make(chan struct{}),
+	}
+
+	if err := c.do(http.MethodGet, "/status", nil, &c.status); err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("%w on %s", ErrNotRunning, socket)
+		}
+		return nil, fmt.Errorf("failed to attach to the daemon on %s: %w", socket, err)
+	}
+
+	// Subscribe before returning, so no event after Dial is missed
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/events", nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to subscribe to daemon events: %w", err)
+	}
+	c.cancel = cancel
+	go c.receive(ctx, resp.Body)
+
+	logger.LogDownloadEvent("SYSTEM", "Attached to the daemon on "+socket)
+	return c, nil
+}
+
+// Status describes the daemon the client is attached to, as it was on Dial
+func (c *Client) Status() Status {
+	return c.status
+}
+
+// receive republishes the events read from body until the stream ends
+func (c *Client) receive(ctx context.Context, body io.ReadCloser) {
+	defer close(c.done)
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var e events.Event
+		err := dec.Decode(&e)
+		if err == nil {
+			c.events.Publish(e)
+			continue
+		}
+
+		if ctx.Err() == nil {
+			if err == io.EOF {
+				err = errors.New("the daemon stopped")
+			}
+			logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("Lost the daemon: %v", err))
+			c.events.Publish(events.Event{Type: events.Disconnected, Error: err.Error()})
+		}
+		return
+	}
+}
+
+// do sends a request with in encoded as the JSON body and decodes the
+// response into out, if they aren't nil
+func (c *Client) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("daemon answered %s", resp.Status)
+		}
+		return e.decodeError()
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Snapshot returns the state of every download
+func (c *Client) Snapshot() []downloader.Snapshot {
+	var downloads []downloader.Snapshot
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.do(http.MethodGet, "/downloads", nil, &downloads); err != nil {
+		logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("Failed to list downloads of the daemon: %v", err))
+		return c.downloads
+	}
+	c.downloads = downloads
+	return downloads
+}
+
+// Config returns the settings of the daemon
+func (c *Client) Config() *config.Config {
+	var cfg config.Config
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.do(http.MethodGet, "/config", nil, &cfg); err != nil {
+		logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("Failed to read the settings of the daemon: %v", err))
+		if c.config == nil {
+			return &config.Config{}
+		}
+		return c.config.Clone()
+	}
+	c.config = &cfg
+	return cfg.Clone()
+}
+
+// Events returns the bus the daemon's events are republished on
+func (c *Client) Events() *events.Bus {
+	return c.events
+}
+
+func (c *Client) Add(rawURL string, opts queue.AddOptions) (string, error) {
+	var s downloader.Snapshot
+	if err := c.do(http.MethodPost, "/downloads", addRequest{URL: rawURL, AddOptions: opts}, &s); err != nil {
+		return "", err
+	}
+	return s.ID, nil
+}
+
+// action runs an action on a download
+func (c *Client) action(id, action string) error {
+	return c.do(http.MethodPost, "/downloads/"+url.PathEscape(id)+"/"+action, nil, nil)
+}
+
+func (c *Client) PauseDownload(id string) error {
+	return c.action(id, "pause")
+}
+
+func (c *Client) ResumeDownload(id string) error {
+	return c.action(id, "resume")
+}
+
+func (c *Client) CancelDownload(id string) error {
+	return c.action(id, "cancel")
+}
+
+func (c *Client) RetryDownload(id string) error {
+	return c.action(id, "retry")
+}
+
+func (c *Client) ResetRetryCount(id string) error {
+	return c.action(id, "reset-retries")
+}
+
+func (c *Client) RemoveDownload(id string) error {
+	return c.do(http.MethodDelete, "/downloads/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) MoveDownloads(ids []string, queueName string, relocate bool) error {
+	return c.do(http.MethodPost, "/downloads/move", moveRequest{IDs: ids, Queue: queueName, Relocate: relocate}, nil)
+}
+
+// UpdateConfig applies update to the daemon's current settings and replaces
+// them with the result, which the daemon validates
+func (c *Client) UpdateConfig(update func(cfg *config.Config)) error {
+	var cfg config.Config
+	if err := c.do(http.MethodGet, "/config", nil, &cfg); err != nil {
+		return err
+	}
+	update(&cfg)
+	return c.do(http.MethodPut, "/config", &cfg, nil)
+}
+
+func (c *Client) Validate() error {
+	return c.do(http.MethodGet, "/validate", nil, nil)
+}
+
+// HistoryEntries returns the finished downloads matching filter, or nothing
+// if the daemon can't be reached
+func (c *Client) HistoryEntries(filter history.Filter) []history.Entry {
+	params := url.Values{}
+	if filter.Query != "" {
+		params.Set("query", filter.Query)
+	}
+	if !filter.From.IsZero() {
+		params.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		params.Set("to", filter.To.Format(time.RFC3339))
+	}
+
+	var entries []history.Entry
+	if err := c.do(http.MethodGet, "/history?"+params.Encode(), nil, &entries); err != nil {
+		logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("Failed to read the history of the daemon: %v", err))
+		return nil
+	}
+	return entries
+}
+
+func (c *Client) Redownload(e history.Entry) (string, error) {
+	var resp idResponse
+	if err := c.do(http.MethodPost, "/history/redownload", e, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// Shutdown detaches from the daemon, which keeps running
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.cancel()
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.http.CloseIdleConnections()
+	logger.LogDownloadEvent("SYSTEM", "Detached from the daemon")
+	return nil
+}
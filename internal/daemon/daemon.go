@@ -0,0 +1,193 @@
+// Package daemon runs the downloads of a profile in the background and
+// serves them to clients over a Unix socket, so that they keep going when
+// the interface is closed. The interface and the commands attach to a
+// running daemon through Client, which works like a local queue manager.
+// The same JSON API is served over TCP for other programs, behind a token.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/network"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// DefaultListen is the TCP address of the API unless Options say otherwise.
+// Only this machine can connect to it.
+const DefaultListen = "127.0.0.1:6800"
+
+// Options configure a daemon
+type Options struct {
+	Listen string // TCP address of the API, empty to serve only the socket
+}
+
+// Daemon owns the queue manager of the active profile and serves it on a
+// Unix socket in the profile's data directory, and optionally over TCP
+type Daemon struct {
+	manager   *queue.Manager
+	monitor   *network.Monitor
+	server    *http.Server
+	tcpServer *http.Server // nil if the API isn't served over TCP
+	collector *collector   // nil if the API isn't served over TCP
+	socket    string
+	addr      string
+	tokenFrom string
+	cancel    context.CancelFunc // Ends the event streams of attached clients
+	served    chan error
+}
+
+// Start opens the downloads of the active profile, starts them and listens
+// for clients. It fails with store.ErrLocked if another process, like a
+// running interface, has the downloads open.
+func Start(opts Options) (*Daemon, error) {
+	if client, err := Dial(config.GetSocketPath()); err == nil {
+		status := client.Status()
+		client.Shutdown(context.Background())
+		return nil, fmt.Errorf("a daemon (pid %d) already serves these downloads: %w", status.PID, store.ErrLocked)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := queue.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Holding the downloads means no other daemon serves them, so a socket
+	// file left behind is stale
+	socket := config.GetSocketPath()
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		manager.Shutdown(context.Background())
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		manager.Shutdown(context.Background())
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	// Anyone who can connect controls the downloads
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		manager.Shutdown(context.Background())
+		return nil, fmt.Errorf("failed to restrict access to %s: %w", socket, err)
+	}
+
+	var tcpListener net.Listener
+	var token, tokenFrom string
+	if opts.Listen != "" {
+		token, tokenFrom, err = loadToken()
+		if err == nil {
+			tcpListener, err = net.Listen("tcp", opts.Listen)
+		}
+		if err != nil {
+			listener.Close()
+			os.Remove(socket)
+			manager.Shutdown(context.Background())
+			return nil, fmt.Errorf("failed to serve the API on %s: %w", opts.Listen, err)
+		}
+	}
+
+	manager.Events().Retain(replayEvents)
+	monitor := network.NewMonitor(2*time.Second, "google.com")
+	var metrics *collector
+	if tcpListener != nil {
+		// Count from before the first download starts
+		metrics = newCollector(manager, monitor)
+	}
+	manager.Start()
+	manager.WatchConfig(config.GetConfigPath())
+	monitor.Start()
+	manager.WatchNetwork(monitor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := (&server{manager: manager, status: newStatus(socket)}).handler()
+	baseContext := func(net.Listener) context.Context { return ctx }
+	d := &Daemon{
+		manager:   manager,
+		monitor:   monitor,
+		collector: metrics,
+		socket:    socket,
+		tokenFrom: tokenFrom,
+		cancel:    cancel,
+		served:    make(chan error, 2),
+		server:    &http.Server{Handler: handler, BaseContext: baseContext},
+	}
+	go func() {
+		d.served <- d.server.Serve(listener)
+	}()
+	logger.LogDownloadEvent("SYSTEM", "Daemon listening on "+socket)
+
+	if tcpListener != nil {
+		d.addr = tcpListener.Addr().String()
+		// aria2 clients send the token as their RPC secret instead, and the
+		// dashboard asks the user for it
+		mux := http.NewServeMux()
+		mux.Handle(apiPrefix+"/", requireToken(token, handler))
+		mux.Handle(aria2Path, &aria2{manager: manager, secret: token})
+		mux.Handle(metricsPath, requireToken(token, metrics))
+		mux.Handle("/", webHandler())
+		d.tcpServer = &http.Server{
+			Handler:           mux,
+			BaseContext:       baseContext,
+			ReadHeaderTimeout: requestTimeout,
+		}
+		go func() {
+			d.served <- d.tcpServer.Serve(tcpListener)
+		}()
+		logger.LogDownloadEvent("SYSTEM", "Daemon serving the API on "+d.addr)
+	}
+	return d, nil
+}
+
+// Socket returns the path of the socket the daemon listens on
+func (d *Daemon) Socket() string {
+	return d.socket
+}
+
+// Addr returns the TCP address the API is served on, or "" if it isn't
+func (d *Daemon) Addr() string {
+	return d.addr
+}
+
+// TokenSource tells where clients of the TCP API find the token: the path
+// of the token file or the environment variable that set it
+func (d *Daemon) TokenSource() string {
+	return d.tokenFrom
+}
+
+// Done delivers the error that stopped the daemon from serving clients
+func (d *Daemon) Done() <-chan error {
+	return d.served
+}
+
+// Shutdown detaches all clients, then pauses and checkpoints the downloads
+// like the interface does when it quits
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	logger.LogDownloadEvent("SYSTEM", "Daemon shutting down")
+
+	// Event streams never finish on their own, so end them first
+	d.cancel()
+	serveErr := d.server.Shutdown(ctx)
+	if d.tcpServer != nil {
+		serveErr = errors.Join(serveErr, d.tcpServer.Shutdown(ctx))
+	}
+	d.monitor.Stop()
+	if d.collector != nil {
+		d.collector.stop()
+	}
+	os.Remove(d.socket)
+
+	return errors.Join(serveErr, d.manager.Shutdown(ctx))
+}
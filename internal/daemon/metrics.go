@@ -0,0 +1,215 @@
+package daemon
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/metrics"
+	"github.com/mahdiXak47/Download-Manager/internal/network"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// metricsPath is where Prometheus scrapes the metrics
+const metricsPath = "/metrics"
+
+// metricsBuffer is how many events the collector may fall behind by. Bytes
+// are counted from the totals events carry, so only retries and errors in
+// lost events go uncounted.
+const metricsBuffer = 1024
+
+// statuses are reported for every queue, so that gauges exist at zero
+var statuses = []downloader.Status{
+	downloader.StatusPending,
+	downloader.StatusScheduled,
+	downloader.StatusDownloading,
+	downloader.StatusPaused,
+	downloader.StatusVerifying,
+	downloader.StatusPostProcessing,
+	downloader.StatusCompleted,
+	downloader.StatusError,
+	downloader.StatusCancelled,
+}
+
+// collector counts what the events of a manager tell about its downloads
+// since the daemon started, and reads the rest from the manager when scraped
+type collector struct {
+	manager *queue.Manager
+	monitor *network.Monitor
+	sub     *events.Subscription
+	done    chan struct{}
+
+	mutex      sync.Mutex
+	downloaded map[string]int64 // Bytes of each download counted so far
+
+	bytes     *metrics.CounterVec // By queue and host
+	retries   *metrics.CounterVec // By queue
+	errors    *metrics.CounterVec // By kind
+	completed *metrics.CounterVec // By queue
+	failed    *metrics.CounterVec // By queue
+}
+
+// newCollector starts counting the events of manager. Bytes downloaded
+// before are not counted.
+func newCollector(manager *queue.Manager, monitor *network.Monitor) *collector {
+	c := &collector{
+		manager:    manager,
+		monitor:    monitor,
+		sub:        manager.Events().Subscribe(metricsBuffer, events.DropOldest),
+		done:       make(chan struct{}),
+		downloaded: make(map[string]int64),
+		bytes:      metrics.NewCounterVec("queue", "host"),
+		retries:    metrics.NewCounterVec("queue"),
+		errors:     metrics.NewCounterVec("kind"),
+		completed:  metrics.NewCounterVec("queue"),
+		failed:     metrics.NewCounterVec("queue"),
+	}
+	for _, s := range manager.Snapshot() {
+		c.downloaded[s.ID] = s.Downloaded
+	}
+	go c.run()
+	return c
+}
+
+func (c *collector) run() {
+	defer close(c.done)
+	for e := range c.sub.Events() {
+		c.record(e)
+	}
+}
+
+// stop ends counting
+func (c *collector) stop() {
+	c.sub.Close()
+	<-c.done
+}
+
+// record counts an event
+func (c *collector) record(e events.Event) {
+	if e.DownloadID == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	last, seen := c.downloaded[e.DownloadID]
+	switch e.Type {
+	case events.Removed, events.Cancelled:
+		delete(c.downloaded, e.DownloadID)
+	default:
+		// Totals drop when a download restarts from scratch
+		c.downloaded[e.DownloadID] = e.Downloaded
+	}
+	c.mutex.Unlock()
+	if delta := e.Downloaded - last; seen && delta > 0 {
+		c.bytes.Add(float64(delta), e.Queue, host(e.URL))
+	}
+
+	switch e.Type {
+	case events.Retrying:
+		c.retries.Add(1, e.Queue)
+		// Retries asked for by the user carry no error
+		if e.Error != "" {
+			c.errors.Add(1, errorKind(e))
+		}
+	case events.Failed:
+		c.failed.Add(1, e.Queue)
+		c.errors.Add(1, errorKind(e))
+	case events.Completed:
+		c.completed.Add(1, e.Queue)
+	}
+}
+
+// host returns the host a URL points at, for grouping downloads by server
+func host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "unknown"
+	}
+	return u.Hostname()
+}
+
+// errorKind returns the kind of error an event reports. Events from the
+// downloader carry it; others count as "other".
+func errorKind(e events.Event) string {
+	if e.ErrorKind == "" {
+		return downloader.KindOther
+	}
+	return e.ErrorKind
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		allow(w, r, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	mw := metrics.NewWriter(w)
+
+	c.bytes.Write(mw, "dm_downloaded_bytes_total", "Bytes downloaded since the daemon started.")
+	c.completed.Write(mw, "dm_downloads_completed_total", "Downloads that completed since the daemon started.")
+	c.failed.Write(mw, "dm_downloads_failed_total", "Downloads that failed after their last retry since the daemon started.")
+	c.retries.Write(mw, "dm_retries_total", "Retries of downloads since the daemon started.")
+	c.errors.Write(mw, "dm_download_errors_total", "Errors of download attempts since the daemon started, by kind.")
+
+	cfg := c.manager.Config()
+	counts := map[string]map[downloader.Status]int{}
+	speeds := map[string]int64{}
+	for _, q := range cfg.Queues {
+		counts[q.Name] = map[downloader.Status]int{}
+	}
+	for _, s := range c.manager.Snapshot() {
+		if counts[s.Queue] == nil {
+			counts[s.Queue] = map[downloader.Status]int{}
+		}
+		counts[s.Queue][s.Status]++
+		if s.Status == downloader.StatusDownloading {
+			speeds[s.Queue] += s.Speed
+		}
+	}
+
+	// Downloads may sit in a queue that is no longer configured
+	queues := make([]string, 0, len(counts))
+	for name := range counts {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+
+	mw.Family("dm_downloads", "Downloads by queue and status.", metrics.Gauge)
+	for _, name := range queues {
+		for _, status := range statuses {
+			mw.Sample("dm_downloads", float64(counts[name][status]), "queue", name, "status", string(status))
+		}
+	}
+
+	mw.Family("dm_download_speed_bytes", "Current speed of the active downloads in bytes per second.", metrics.Gauge)
+	for _, name := range queues {
+		mw.Sample("dm_download_speed_bytes", float64(speeds[name]), "queue", name)
+	}
+
+	mw.Family("dm_queue_speed_limit_bytes", "Speed limit of each download in the queue in bytes per second, 0 for none.", metrics.Gauge)
+	for _, q := range cfg.Queues {
+		mw.Sample("dm_queue_speed_limit_bytes", float64(q.SpeedLimit*1024), "queue", q.Name)
+	}
+
+	mw.Family("dm_queue_max_concurrent", "Downloads the queue may run at the same time.", metrics.Gauge)
+	for _, q := range cfg.Queues {
+		mw.Sample("dm_queue_max_concurrent", float64(q.MaxConcurrent), "queue", q.Name)
+	}
+
+	mw.Family("dm_global_speed_limit_bytes", "Rate of the limiter shared by all downloads in bytes per second, 0 for none.", metrics.Gauge)
+	mw.Sample("dm_global_speed_limit_bytes", float64(c.manager.GlobalRate()))
+
+	mw.Family("dm_global_max_active", "Downloads that may run at the same time across all queues, 0 for no limit.", metrics.Gauge)
+	mw.Sample("dm_global_max_active", float64(cfg.Limits.MaxActive))
+
+	mw.Family("dm_network_up", "Whether the network monitor sees the network as up.", metrics.Gauge)
+	up := 0.0
+	if c.monitor.IsConnected() {
+		up = 1
+	}
+	mw.Sample("dm_network_up", up)
+}
@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/metrics"
+	"github.com/mahdiXak47/Download-Manager/internal/network"
+)
+
+func TestMetricsGolden(t *testing.T) {
+	m := newTestManager(t)
+	dir := t.TempDir()
+	var ids []string
+	for _, queue := range []string{"default", "default", "gone"} {
+		d := downloader.New("http://files.example.com/a.bin", filepath.Join(dir, "a.bin"), queue, 0, time.Time{})
+		if err := m.AddDownload(d); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.ID)
+	}
+	// The test manager isn't started, so nothing publishes events but the test
+	c := newCollector(m, network.NewMonitor(time.Hour, "example.com"))
+	c.stop()
+
+	for _, e := range []events.Event{
+		{Type: events.Progress, DownloadID: ids[0], Queue: "default", URL: "http://files.example.com/a.bin", Downloaded: 1000},
+		{Type: events.Retrying, DownloadID: ids[0], Queue: "default", Downloaded: 1000, Error: "EOF", ErrorKind: downloader.KindNetwork},
+		{Type: events.Retrying, DownloadID: ids[0], Queue: "default", Downloaded: 1000}, // Asked for by the user
+		{Type: events.Completed, DownloadID: ids[0], Queue: "default", URL: "http://files.example.com/a.bin", Downloaded: 1500},
+		{Type: events.Progress, DownloadID: ids[2], Queue: "gone", URL: "http://[bad", Downloaded: 300},
+		{Type: events.Failed, DownloadID: ids[2], Queue: "gone", Downloaded: 300, Error: "checksum mismatch", ErrorKind: downloader.KindChecksum},
+		{Type: events.Failed, DownloadID: "elsewhere", Queue: "gone", Error: "no kind"},
+		{Type: events.ConfigUpdated}, // Not about a download
+	} {
+		c.record(e)
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, metrics.ContentType)
+	}
+
+	want := `# HELP dm_downloaded_bytes_total Bytes downloaded since the daemon started.
+# TYPE dm_downloaded_bytes_total counter
+dm_downloaded_bytes_total{queue="default",host="files.example.com"} 1500
+dm_downloaded_bytes_total{queue="gone",host="unknown"} 300
+# HELP dm_downloads_completed_total Downloads that completed since the daemon started.
+# TYPE dm_downloads_completed_total counter
+dm_downloads_completed_total{queue="default"} 1
+# HELP dm_downloads_failed_total Downloads that failed after their last retry since the daemon started.
+# TYPE dm_downloads_failed_total counter
+dm_downloads_failed_total{queue="gone"} 2
+# HELP dm_retries_total Retries of downloads since the daemon started.
+# TYPE dm_retries_total counter
+dm_retries_total{queue="default"} 2
+# HELP dm_download_errors_total Errors of download attempts since the daemon started, by kind.
+# TYPE dm_download_errors_total counter
+dm_download_errors_total{kind="checksum"} 1
+dm_download_errors_total{kind="network"} 1
+dm_download_errors_total{kind="other"} 1
+# HELP dm_downloads Downloads by queue and status.
+# TYPE dm_downloads gauge
+dm_downloads{queue="default",status="pending"} 2
+dm_downloads{queue="default",status="scheduled"} 0
+dm_downloads{queue="default",status="downloading"} 0
+dm_downloads{queue="default",status="paused"} 0
+dm_downloads{queue="default",status="verifying"} 0
+dm_downloads{queue="default",status="post-processing"} 0
+dm_downloads{queue="default",status="completed"} 0
+dm_downloads{queue="default",status="error"} 0
+dm_downloads{queue="default",status="cancelled"} 0
+dm_downloads{queue="gone",status="pending"} 1
+dm_downloads{queue="gone",status="scheduled"} 0
+dm_downloads{queue="gone",status="downloading"} 0
+dm_downloads{queue="gone",status="paused"} 0
+dm_downloads{queue="gone",status="verifying"} 0
+dm_downloads{queue="gone",status="post-processing"} 0
+dm_downloads{queue="gone",status="completed"} 0
+dm_downloads{queue="gone",status="error"} 0
+dm_downloads{queue="gone",status="cancelled"} 0
+dm_downloads{queue="night",status="pending"} 0
+dm_downloads{queue="night",status="scheduled"} 0
+dm_downloads{queue="night",status="downloading"} 0
+dm_downloads{queue="night",status="paused"} 0
+dm_downloads{queue="night",status="verifying"} 0
+dm_downloads{queue="night",status="post-processing"} 0
+dm_downloads{queue="night",status="completed"} 0
+dm_downloads{queue="night",status="error"} 0
+dm_downloads{queue="night",status="cancelled"} 0
+# HELP dm_download_speed_bytes Current speed of the active downloads in bytes per second.
+# TYPE dm_download_speed_bytes gauge
+dm_download_speed_bytes{queue="default"} 0
+dm_download_speed_bytes{queue="gone"} 0
+dm_download_speed_bytes{queue="night"} 0
+# HELP dm_queue_speed_limit_bytes Speed limit of each download in the queue in bytes per second, 0 for none.
+# TYPE dm_queue_speed_limit_bytes gauge
+dm_queue_speed_limit_bytes{queue="default"} 0
+dm_queue_speed_limit_bytes{queue="night"} 0
+# HELP dm_queue_max_concurrent Downloads the queue may run at the same time.
+# TYPE dm_queue_max_concurrent gauge
+dm_queue_max_concurrent{queue="default"} 3
+dm_queue_max_concurrent{queue="night"} 5
+# HELP dm_global_speed_limit_bytes Rate of the limiter shared by all downloads in bytes per second, 0 for none.
+# TYPE dm_global_speed_limit_bytes gauge
+dm_global_speed_limit_bytes 0
+# HELP dm_global_max_active Downloads that may run at the same time across all queues, 0 for no limit.
+# TYPE dm_global_max_active gauge
+dm_global_max_active 0
+# HELP dm_network_up Whether the network monitor sees the network as up.
+# TYPE dm_network_up gauge
+dm_network_up 1
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsMethodNotAllowed(t *testing.T) {
+	c := newCollector(newTestManager(t), network.NewMonitor(time.Hour, "example.com"))
+	defer c.stop()
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, metricsPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,21 @@
+package daemon
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI describes the API; keep it in step with the handlers in server.go
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// handleOpenAPI serves the OpenAPI document, which needs no token so tools
+// can discover the API
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
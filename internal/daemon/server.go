@@ -0,0 +1,552 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// streamBuffer is how many events a slow client may fall behind by before
+// it loses the oldest ones
+const streamBuffer = 256
+
+// server answers the requests of clients on a queue manager
+type server struct {
+	manager *queue.Manager
+	status  Status
+}
+
+// handler routes the requests under apiPrefix
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix+"/status", s.handleStatus)
+	mux.HandleFunc(apiPrefix+"/downloads", s.handleDownloads)
+	mux.HandleFunc(apiPrefix+"/downloads/", s.handleDownload)
+	mux.HandleFunc(apiPrefix+"/queues", s.handleQueues)
+	mux.HandleFunc(apiPrefix+"/queues/", s.handleQueue)
+	mux.HandleFunc(apiPrefix+"/limits", s.handleLimits)
+	mux.HandleFunc(apiPrefix+"/stats", s.handleStats)
+	mux.HandleFunc(apiPrefix+"/config", s.handleConfig)
+	mux.HandleFunc(apiPrefix+"/validate", s.handleValidate)
+	mux.HandleFunc(apiPrefix+"/history", s.handleHistory)
+	mux.HandleFunc(apiPrefix+"/history/redownload", s.handleRedownload)
+	mux.HandleFunc(apiPrefix+"/events", s.handleEvents)
+	mux.HandleFunc(apiPrefix+"/stream", s.handleStream)
+	mux.HandleFunc(apiPrefix+"/openapi.json", handleOpenAPI)
+	return mux
+}
+
+// allow reports whether r uses method, answering 405 if it doesn't
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed", Kind: kindBadRequest})
+	return false
+}
+
+// readJSON decodes the request body into v
+func readJSON(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequestf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	status := s.status
+	status.Downloads = len(s.manager.Snapshot())
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleDownloads lists downloads, optionally only those of the queue and
+// status given as parameters, and adds new ones
+func (s *server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		params := r.URL.Query()
+		queueName, status := params.Get("queue"), params.Get("status")
+		downloads := []downloader.Snapshot{}
+		for _, d := range s.manager.Snapshot() {
+			if (queueName == "" || d.Queue == queueName) && (status == "" || string(d.Status) == status) {
+				downloads = append(downloads, d)
+			}
+		}
+		writeJSON(w, http.StatusOK, downloads)
+	case http.MethodPost:
+		var req addRequest
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		id, err := s.manager.Add(req.URL, req.AddOptions)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.writeDownload(w, http.StatusCreated, id)
+	default:
+		allow(w, r, http.MethodGet+", "+http.MethodPost)
+	}
+}
+
+// handleDownload serves /downloads/{id}, /downloads/{id}/{action} and
+// /downloads/move
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPrefix+"/downloads/"), "/")
+	if id == "move" && action == "" {
+		s.handleMove(w, r)
+		return
+	}
+
+	if action == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.writeDownload(w, http.StatusOK, id)
+		case http.MethodPatch:
+			s.patchDownload(w, r, id)
+		case http.MethodDelete:
+			if err := s.manager.RemoveDownload(id); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			allow(w, r, http.MethodGet+", "+http.MethodPatch+", "+http.MethodDelete)
+		}
+		return
+	}
+
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var err error
+	switch action {
+	case "pause":
+		err = s.manager.PauseDownload(id)
+	case "resume":
+		err = s.manager.ResumeDownload(id)
+	case "cancel":
+		err = s.manager.CancelDownload(id)
+	case "retry":
+		err = s.manager.RetryDownload(id)
+	case "reset-retries":
+		err = s.manager.ResetRetryCount(id)
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown action " + action, Kind: kindNotFound})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Cancelled downloads are gone
+	if _, ok := findSnapshot(s.manager.Snapshot(), id); !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeDownload(w, http.StatusOK, id)
+}
+
+func (s *server) handleMove(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var req moveRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.manager.MoveDownloads(req.IDs, req.Queue, req.Relocate); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchDownload moves a download to another queue and changes its speed limit
+func (s *server) patchDownload(w http.ResponseWriter, r *http.Request, id string) {
+	var req patchDownloadRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, ok := findSnapshot(s.manager.Snapshot(), id); !ok {
+		writeError(w, fmt.Errorf("download %s %w", id, queue.ErrNotFound))
+		return
+	}
+	if req.SpeedLimit != nil && *req.SpeedLimit < 0 {
+		writeError(w, badRequestf("speed_limit must not be negative"))
+		return
+	}
+
+	// Moving applies the target queue's speed limit, so an explicit one goes last
+	if req.Queue != nil {
+		if err := s.manager.MoveDownloads([]string{id}, *req.Queue, req.Relocate); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if req.SpeedLimit != nil {
+		if err := s.manager.SetSpeedLimit(id, *req.SpeedLimit); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	s.writeDownload(w, http.StatusOK, id)
+}
+
+// handleQueues lists the queues and creates new ones
+func (s *server) handleQueues(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg := s.manager.Config()
+		snapshots := s.manager.Snapshot()
+		queues := make([]queueResponse, 0, len(cfg.Queues))
+		for _, q := range cfg.Queues {
+			queues = append(queues, newQueueResponse(cfg, q, snapshots))
+		}
+		writeJSON(w, http.StatusOK, queues)
+	case http.MethodPost:
+		var q config.QueueConfig
+		if err := readJSON(r, &q); err != nil {
+			writeError(w, err)
+			return
+		}
+		var exists bool
+		err := s.manager.UpdateConfig(func(cfg *config.Config) {
+			if exists = cfg.GetQueue(q.Name) != nil; !exists {
+				cfg.Queues = append(cfg.Queues, q)
+			}
+		})
+		if exists {
+			err = fmt.Errorf("queue %s already exists", q.Name)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.writeQueue(w, http.StatusCreated, q.Name)
+	default:
+		allow(w, r, http.MethodGet+", "+http.MethodPost)
+	}
+}
+
+// handleQueue serves /queues/{name}. PATCH merges the given fields into the
+// queue; renaming a queue that has downloads fails like it does in Settings.
+func (s *server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, apiPrefix+"/queues/"))
+	if err != nil || name == "" || strings.Contains(name, "/") {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "unknown path " + r.URL.Path, Kind: kindNotFound})
+		return
+	}
+	notFound := fmt.Errorf("queue %s %w", name, queue.ErrNotFound)
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeQueue(w, http.StatusOK, name)
+	case http.MethodPatch:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, badRequestf("invalid request body: %v", err))
+			return
+		}
+		var found bool
+		var decodeErr error
+		var renamed string
+		err = s.manager.UpdateConfig(func(cfg *config.Config) {
+			q := cfg.GetQueue(name)
+			if found = q != nil; !found {
+				return
+			}
+			// Unmarshaling over a copy only changes the fields in the body
+			next := *q
+			if decodeErr = json.Unmarshal(body, &next); decodeErr != nil {
+				return
+			}
+			if next.Name != name && cfg.DefaultQueue == name {
+				cfg.DefaultQueue = next.Name
+			}
+			*q, renamed = next, next.Name
+		})
+		switch {
+		case !found:
+			err = notFound
+		case decodeErr != nil:
+			err = badRequestf("invalid request body: %v", decodeErr)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.writeQueue(w, http.StatusOK, renamed)
+	case http.MethodDelete:
+		var found bool
+		err := s.manager.UpdateConfig(func(cfg *config.Config) {
+			for i, q := range cfg.Queues {
+				if q.Name == name {
+					cfg.Queues = append(cfg.Queues[:i], cfg.Queues[i+1:]...)
+					found = true
+					return
+				}
+			}
+		})
+		if !found {
+			err = notFound
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		allow(w, r, http.MethodGet+", "+http.MethodPatch+", "+http.MethodDelete)
+	}
+}
+
+// writeQueue writes the queue with the given name
+func (s *server) writeQueue(w http.ResponseWriter, status int, name string) {
+	cfg := s.manager.Config()
+	q := cfg.GetQueue(name)
+	if q == nil {
+		writeError(w, fmt.Errorf("queue %s %w", name, queue.ErrNotFound))
+		return
+	}
+	writeJSON(w, status, newQueueResponse(cfg, *q, s.manager.Snapshot()))
+}
+
+// handleLimits reads and replaces the limits that apply to all downloads
+func (s *server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.manager.Config().Limits)
+	case http.MethodPut:
+		var limits config.LimitsConfig
+		if err := readJSON(r, &limits); err != nil {
+			writeError(w, err)
+			return
+		}
+		err := s.manager.UpdateConfig(func(cfg *config.Config) {
+			cfg.Limits = limits
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.manager.Config().Limits)
+	default:
+		allow(w, r, http.MethodGet+", "+http.MethodPut)
+	}
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.stats())
+}
+
+// stats sums up the downloads of the manager
+func (s *server) stats() Stats {
+	cfg := s.manager.Config()
+	stats := Stats{
+		Counts: map[string]int{},
+		Queues: map[string]QueueStats{},
+		Limits: cfg.Limits,
+	}
+	for _, q := range cfg.Queues {
+		stats.Queues[q.Name] = QueueStats{Counts: map[string]int{}}
+	}
+	for _, d := range s.manager.Snapshot() {
+		stats.Downloads++
+		stats.Counts[string(d.Status)]++
+		stats.Downloaded += d.Downloaded
+		stats.TotalSize += d.TotalSize
+		q, ok := stats.Queues[d.Queue]
+		if !ok {
+			q = QueueStats{Counts: map[string]int{}}
+		}
+		q.Counts[string(d.Status)]++
+		q.Downloaded += d.Downloaded
+		if d.Status == downloader.StatusDownloading {
+			stats.Speed += d.Speed
+			q.Speed += d.Speed
+		}
+		stats.Queues[d.Queue] = q
+	}
+	return stats
+}
+
+// writeDownload writes the state of the download with the given ID
+func (s *server) writeDownload(w http.ResponseWriter, status int, id string) {
+	snapshot, ok := findSnapshot(s.manager.Snapshot(), id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "download " + id + " " + queue.ErrNotFound.Error(), Kind: kindNotFound})
+		return
+	}
+	writeJSON(w, status, snapshot)
+}
+
+// handleConfig reads and replaces the settings
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.manager.Config())
+	case http.MethodPut:
+		var next config.Config
+		if err := readJSON(r, &next); err != nil {
+			writeError(w, err)
+			return
+		}
+		err := s.manager.UpdateConfig(func(cfg *config.Config) {
+			*cfg = next
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.manager.Config())
+	default:
+		allow(w, r, http.MethodGet+", "+http.MethodPut)
+	}
+}
+
+func (s *server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	if err := s.manager.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHistory lists finished downloads. The query, from and to parameters
+// map to history.Filter; times are in RFC 3339.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	params := r.URL.Query()
+	filter := history.Filter{Query: params.Get("query")}
+	for name, field := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, badRequestf("%s: %v", name, err))
+			return
+		}
+		*field = t
+	}
+
+	entries := s.manager.HistoryEntries(filter)
+	if entries == nil {
+		entries = []history.Entry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *server) handleRedownload(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var entry history.Entry
+	if err := readJSON(r, &entry); err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := s.manager.Redownload(entry)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, idResponse{ID: id})
+}
+
+// handleEvents streams the events of the manager as JSON, one per line,
+// until the client goes away or the daemon stops
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported", Kind: kindConflict})
+		return
+	}
+
+	sub := s.manager.Events().Subscribe(streamBuffer, events.DropOldest)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// newStatus describes the daemon listening on socket
+func newStatus(socket string) Status {
+	return Status{
+		PID:     os.Getpid(),
+		Profile: config.GetProfile(),
+		Socket:  socket,
+		Started: time.Now(),
+	}
+}
+
+// newQueueResponse describes q of cfg with the number of snapshots in it
+// by status
+func newQueueResponse(cfg *config.Config, q config.QueueConfig, snapshots []downloader.Snapshot) queueResponse {
+	resp := queueResponse{QueueConfig: q, Default: q.Name == cfg.DefaultQueue, Counts: map[string]int{}}
+	for _, d := range snapshots {
+		if d.Queue == q.Name {
+			resp.Counts[string(d.Status)]++
+		}
+	}
+	return resp
+}
+
+// findSnapshot returns the snapshot of the download with the given ID
+func findSnapshot(snapshots []downloader.Snapshot, id string) (downloader.Snapshot, bool) {
+	for _, s := range snapshots {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return downloader.Snapshot{}, false
+}
@@ -0,0 +1,389 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+const testToken = "secret"
+
+// newTestManager returns a manager on a default config and empty store in a
+// temporary directory. It isn't started, so downloads never transfer.
+func newTestManager(t *testing.T) *queue.Manager {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfig, filepath.Join(dir, "config.json"))
+	t.Setenv(config.EnvDataDir, filepath.Join(dir, "data"))
+	t.Setenv(config.EnvDownloadDir, filepath.Join(dir, "downloads"))
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.Open(config.GetDownloadsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist, err := history.Open(config.GetHistoryPath(), history.Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := queue.NewManager(cfg, db, hist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown(context.Background()) })
+	return m
+}
+
+// newTestAPI serves the API of a new test manager over TCP, behind testToken
+func newTestAPI(t *testing.T) (*httptest.Server, *queue.Manager) {
+	t.Helper()
+	m := newTestManager(t)
+	handler := (&server{manager: m, status: newStatus("test.sock")}).handler()
+	srv := httptest.NewServer(requireToken(testToken, handler))
+	t.Cleanup(srv.Close)
+	return srv, m
+}
+
+// call sends a request with testToken and body encoded as JSON, if not nil,
+// and returns the response with its body read
+func call(t *testing.T, srv *httptest.Server, method, path string, body any) (*http.Response, []byte) {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, srv.URL+apiPrefix+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// expect fails the test unless resp has the given status, and decodes the
+// body into v if it isn't nil
+func expect(t *testing.T, resp *http.Response, data []byte, status int, v any) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, data)
+	}
+	if v != nil {
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("%s %s: %v in %s", resp.Request.Method, resp.Request.URL.Path, err, data)
+		}
+	}
+}
+
+// expectError fails the test unless resp is an error of the given status and kind
+func expectError(t *testing.T, resp *http.Response, data []byte, status int, kind string) errorResponse {
+	t.Helper()
+	var e errorResponse
+	expect(t, resp, data, status, &e)
+	if e.Kind != kind || e.Error == "" {
+		t.Fatalf("%s %s: error %+v, want kind %s", resp.Request.Method, resp.Request.URL.Path, e, kind)
+	}
+	return e
+}
+
+func TestDownloadsCRUD(t *testing.T) {
+	srv, _ := newTestAPI(t)
+
+	var list []downloader.Snapshot
+	resp, data := call(t, srv, http.MethodGet, "/downloads", nil)
+	expect(t, resp, data, http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("new daemon has %d downloads", len(list))
+	}
+
+	var created downloader.Snapshot
+	resp, data = call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a.iso", "filename": "b.iso"})
+	expect(t, resp, data, http.StatusCreated, &created)
+	if created.ID == "" || created.Filename != "b.iso" || created.Queue != "default" || created.Status != downloader.StatusPending {
+		t.Fatalf("created %+v", created)
+	}
+	call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/c.iso", "queue": "night"})
+
+	var got downloader.Snapshot
+	resp, data = call(t, srv, http.MethodGet, "/downloads/"+created.ID, nil)
+	expect(t, resp, data, http.StatusOK, &got)
+	if got.ID != created.ID || got.URL != "http://example.com/a.iso" {
+		t.Fatalf("got %+v, want the created download", got)
+	}
+
+	resp, data = call(t, srv, http.MethodGet, "/downloads?queue=night", nil)
+	expect(t, resp, data, http.StatusOK, &list)
+	if len(list) != 1 || list[0].Queue != "night" {
+		t.Fatalf("downloads of queue night: %+v", list)
+	}
+
+	var patched downloader.Snapshot
+	resp, data = call(t, srv, http.MethodPatch, "/downloads/"+created.ID, map[string]any{"queue": "night", "speed_limit": 64})
+	expect(t, resp, data, http.StatusOK, &patched)
+	if patched.Queue != "night" || patched.MaxBandwidth != 64 {
+		t.Fatalf("patched %+v, want queue night and limit 64", patched)
+	}
+
+	resp, data = call(t, srv, http.MethodDelete, "/downloads/"+created.ID, nil)
+	expect(t, resp, data, http.StatusNoContent, nil)
+	resp, data = call(t, srv, http.MethodGet, "/downloads/"+created.ID, nil)
+	expectError(t, resp, data, http.StatusNotFound, kindNotFound)
+	resp, data = call(t, srv, http.MethodDelete, "/downloads/"+created.ID, nil)
+	expectError(t, resp, data, http.StatusNotFound, kindNotFound)
+}
+
+func TestDownloadErrors(t *testing.T) {
+	srv, _ := newTestAPI(t)
+	var created downloader.Snapshot
+	resp, data := call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a.iso"})
+	expect(t, resp, data, http.StatusCreated, &created)
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         any
+		status       int
+		kind         string
+	}{
+		{"malformed body", http.MethodPost, "/downloads", "{", http.StatusBadRequest, kindBadRequest},
+		{"unsupported scheme", http.MethodPost, "/downloads", map[string]any{"url": "ftp://example.com/a"}, http.StatusBadRequest, kindInvalidURL},
+		{"not a URL", http.MethodPost, "/downloads", map[string]any{"url": "a.iso"}, http.StatusBadRequest, kindInvalidURL},
+		{"file name with a path", http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a", "filename": "../a"}, http.StatusBadRequest, kindInvalidURL},
+		{"unknown queue", http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a", "queue": "nope"}, http.StatusNotFound, kindNotFound},
+		{"negative speed limit", http.MethodPatch, "/downloads/" + created.ID, map[string]any{"speed_limit": -1}, http.StatusBadRequest, kindBadRequest},
+		{"patch unknown download", http.MethodPatch, "/downloads/nope", map[string]any{"speed_limit": 1}, http.StatusNotFound, kindNotFound},
+		{"move to unknown queue", http.MethodPost, "/downloads/move", map[string]any{"ids": []string{created.ID}, "queue": "nope"}, http.StatusNotFound, kindNotFound},
+		{"unknown action", http.MethodPost, "/downloads/" + created.ID + "/explode", nil, http.StatusNotFound, kindNotFound},
+		{"action on unknown download", http.MethodPost, "/downloads/nope/pause", nil, http.StatusNotFound, kindNotFound},
+		{"retry without error", http.MethodPost, "/downloads/" + created.ID + "/retry", nil, http.StatusConflict, kindConflict},
+		{"action with GET", http.MethodGet, "/downloads/" + created.ID + "/pause", nil, http.StatusMethodNotAllowed, kindBadRequest},
+		{"bad method", http.MethodPut, "/downloads", nil, http.StatusMethodNotAllowed, kindBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, data := call(t, srv, tt.method, tt.path, tt.body)
+			expectError(t, resp, data, tt.status, tt.kind)
+		})
+	}
+}
+
+func TestDownloadActions(t *testing.T) {
+	srv, m := newTestAPI(t)
+	var d downloader.Snapshot
+	resp, data := call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a.iso"})
+	expect(t, resp, data, http.StatusCreated, &d)
+	action := func(name string) (*http.Response, []byte) {
+		return call(t, srv, http.MethodPost, "/downloads/"+d.ID+"/"+name, nil)
+	}
+
+	// A manager that isn't started only changes statuses
+	resp, data = action("pause")
+	expect(t, resp, data, http.StatusOK, &d)
+	if d.Status != downloader.StatusPaused {
+		t.Fatalf("status after pause = %s", d.Status)
+	}
+	resp, data = action("pause")
+	expectError(t, resp, data, http.StatusConflict, kindConflict)
+
+	resp, data = action("resume")
+	expect(t, resp, data, http.StatusOK, &d)
+	if d.Status != downloader.StatusPending {
+		t.Fatalf("status after resume = %s", d.Status)
+	}
+
+	resp, data = action("reset-retries")
+	expect(t, resp, data, http.StatusOK, &d)
+	if d.RetryCount != 0 {
+		t.Fatalf("retry count after reset = %d", d.RetryCount)
+	}
+
+	resp, data = action("cancel")
+	expect(t, resp, data, http.StatusNoContent, nil)
+	if len(m.Snapshot()) != 0 {
+		t.Fatal("cancelled download is still listed")
+	}
+}
+
+func TestMoveDownloads(t *testing.T) {
+	srv, m := newTestAPI(t)
+	var ids []string
+	for _, u := range []string{"http://example.com/a", "http://example.com/b"} {
+		var d downloader.Snapshot
+		resp, data := call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": u})
+		expect(t, resp, data, http.StatusCreated, &d)
+		ids = append(ids, d.ID)
+	}
+
+	resp, data := call(t, srv, http.MethodPost, "/downloads/move", map[string]any{"ids": ids, "queue": "night"})
+	expect(t, resp, data, http.StatusNoContent, nil)
+	for _, d := range m.Snapshot() {
+		if d.Queue != "night" {
+			t.Errorf("download %s is in queue %s after the move", d.ID, d.Queue)
+		}
+	}
+}
+
+func TestQueuesCRUD(t *testing.T) {
+	srv, _ := newTestAPI(t)
+
+	var queues []queueResponse
+	resp, data := call(t, srv, http.MethodGet, "/queues", nil)
+	expect(t, resp, data, http.StatusOK, &queues)
+	if len(queues) != 2 || !queues[0].Default || queues[0].Name != "default" {
+		t.Fatalf("queues of the default config: %+v", queues)
+	}
+
+	fast := config.QueueConfig{Name: "fast", MaxConcurrent: 2, StartTime: "00:00", EndTime: "23:59", Enabled: true}
+	var q queueResponse
+	resp, data = call(t, srv, http.MethodPost, "/queues", fast)
+	expect(t, resp, data, http.StatusCreated, &q)
+	if q.Name != "fast" || q.MaxConcurrent != 2 || q.Default {
+		t.Fatalf("created queue %+v", q)
+	}
+	resp, data = call(t, srv, http.MethodPost, "/queues", fast)
+	expectError(t, resp, data, http.StatusConflict, kindConflict)
+
+	resp, data = call(t, srv, http.MethodPatch, "/queues/fast", map[string]any{"max_concurrent": 4})
+	expect(t, resp, data, http.StatusOK, &q)
+	if q.MaxConcurrent != 4 || q.StartTime != "00:00" {
+		t.Fatalf("patched queue %+v, want only max_concurrent changed", q)
+	}
+	resp, data = call(t, srv, http.MethodPatch, "/queues/fast", map[string]any{"max_concurrent": 0})
+	e := expectError(t, resp, data, http.StatusUnprocessableEntity, kindInvalid)
+	if len(e.Problems) == 0 {
+		t.Fatal("invalid queue reported without problems")
+	}
+	resp, data = call(t, srv, http.MethodPatch, "/queues/nope", map[string]any{"max_concurrent": 1})
+	expectError(t, resp, data, http.StatusNotFound, kindNotFound)
+
+	resp, data = call(t, srv, http.MethodDelete, "/queues/fast", nil)
+	expect(t, resp, data, http.StatusNoContent, nil)
+	resp, data = call(t, srv, http.MethodGet, "/queues/fast", nil)
+	expectError(t, resp, data, http.StatusNotFound, kindNotFound)
+	resp, data = call(t, srv, http.MethodDelete, "/queues/fast", nil)
+	expectError(t, resp, data, http.StatusNotFound, kindNotFound)
+}
+
+func TestDeleteQueueInUse(t *testing.T) {
+	srv, _ := newTestAPI(t)
+	resp, data := call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a", "queue": "night"})
+	expect(t, resp, data, http.StatusCreated, nil)
+
+	resp, data = call(t, srv, http.MethodDelete, "/queues/night", nil)
+	expectError(t, resp, data, http.StatusUnprocessableEntity, kindInvalid)
+}
+
+func TestLimits(t *testing.T) {
+	srv, m := newTestAPI(t)
+
+	var limits config.LimitsConfig
+	resp, data := call(t, srv, http.MethodGet, "/limits", nil)
+	expect(t, resp, data, http.StatusOK, &limits)
+	if limits != (config.LimitsConfig{}) {
+		t.Fatalf("default limits %+v, want none", limits)
+	}
+
+	resp, data = call(t, srv, http.MethodPut, "/limits", config.LimitsConfig{MaxActive: 2, SpeedLimit: 100})
+	expect(t, resp, data, http.StatusOK, &limits)
+	if limits.MaxActive != 2 || limits.SpeedLimit != 100 {
+		t.Fatalf("limits after PUT %+v", limits)
+	}
+	if got := m.GlobalRate(); got != 100*1024 {
+		t.Errorf("shared limiter rate = %d, want %d", got, 100*1024)
+	}
+	saved, err := config.ReadConfig(config.GetConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Limits != limits || saved.SchemaVersion != config.CurrentSchemaVersion {
+		t.Errorf("saved config has limits %+v, schema %d", saved.Limits, saved.SchemaVersion)
+	}
+
+	for _, bad := range []config.LimitsConfig{{MaxActive: -1}, {SpeedLimit: -1}} {
+		resp, data = call(t, srv, http.MethodPut, "/limits", bad)
+		expectError(t, resp, data, http.StatusUnprocessableEntity, kindInvalid)
+	}
+	if got := m.Config().Limits; got != limits {
+		t.Errorf("rejected limits changed them to %+v", got)
+	}
+
+	var stats Stats
+	resp, data = call(t, srv, http.MethodGet, "/stats", nil)
+	expect(t, resp, data, http.StatusOK, &stats)
+	if stats.Limits != limits {
+		t.Errorf("stats report limits %+v, want %+v", stats.Limits, limits)
+	}
+
+	// Lifting the limit turns the shared limiter off
+	resp, data = call(t, srv, http.MethodPut, "/limits", config.LimitsConfig{})
+	expect(t, resp, data, http.StatusOK, nil)
+	if got := m.GlobalRate(); got != 0 {
+		t.Errorf("shared limiter rate = %d after lifting the limit", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	srv, _ := newTestAPI(t)
+
+	var cfg config.Config
+	resp, data := call(t, srv, http.MethodGet, "/config", nil)
+	expect(t, resp, data, http.StatusOK, &cfg)
+
+	cfg.DefaultQueue = "nope"
+	resp, data = call(t, srv, http.MethodPut, "/config", cfg)
+	expectError(t, resp, data, http.StatusUnprocessableEntity, kindInvalid)
+
+	cfg.DefaultQueue = "night"
+	resp, data = call(t, srv, http.MethodPut, "/config", cfg)
+	expect(t, resp, data, http.StatusOK, &cfg)
+	if cfg.DefaultQueue != "night" {
+		t.Fatalf("default queue after PUT = %s", cfg.DefaultQueue)
+	}
+
+	resp, data = call(t, srv, http.MethodGet, "/validate", nil)
+	expect(t, resp, data, http.StatusNoContent, nil)
+}
+
+func TestShuttingDown(t *testing.T) {
+	srv, m := newTestAPI(t)
+	var d downloader.Snapshot
+	resp, data := call(t, srv, http.MethodPost, "/downloads", map[string]any{"url": "http://example.com/a.iso"})
+	expect(t, resp, data, http.StatusCreated, &d)
+	call(t, srv, http.MethodPost, "/downloads/"+d.ID+"/pause", nil)
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	resp, data = call(t, srv, http.MethodPost, "/downloads/"+d.ID+"/resume", nil)
+	expectError(t, resp, data, http.StatusServiceUnavailable, kindShuttingDown)
+}
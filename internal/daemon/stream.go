@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+)
+
+const (
+	// replayEvents is how many events other than progress the daemon keeps
+	// for clients of the stream that reconnect
+	replayEvents = 1000
+
+	// Progress and stats go out at most once per interval, which clients may
+	// choose down to minStreamInterval
+	defaultStreamInterval = time.Second
+	minStreamInterval     = 100 * time.Millisecond
+
+	// keepAliveInterval keeps idle streams from being closed by proxies
+	keepAliveInterval = 15 * time.Second
+
+	// reconnectDelay is how long browsers wait before reconnecting
+	reconnectDelay = 3 * time.Second
+)
+
+// streamFilter narrows the stream to one download or queue
+type streamFilter struct {
+	download string
+	queue    string
+}
+
+func (f streamFilter) match(e events.Event) bool {
+	// Events about the manager concern every subscriber
+	if e.DownloadID == "" {
+		return true
+	}
+	return (f.download == "" || e.DownloadID == f.download) && (f.queue == "" || e.Queue == f.queue)
+}
+
+func (f streamFilter) matchSnapshot(s downloader.Snapshot) bool {
+	return (f.download == "" || s.ID == f.download) && (f.queue == "" || s.Queue == f.queue)
+}
+
+// sseWriter writes Server-Sent Events and remembers the first error
+type sseWriter struct {
+	w   io.Writer
+	err error
+}
+
+// write sends an event. Events without an ID leave the client's last
+// event ID as it was.
+func (sw *sseWriter) write(name, id string, data []byte) {
+	if sw.err != nil {
+		return
+	}
+	if id != "" {
+		_, sw.err = fmt.Fprintf(sw.w, "id: %s\n", id)
+	}
+	if sw.err == nil {
+		_, sw.err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", name, data)
+	}
+}
+
+// writeJSON sends v as the data of an event
+func (sw *sseWriter) writeJSON(name, id string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		sw.err = err
+		return
+	}
+	sw.write(name, id, data)
+}
+
+// eventID returns the ID of the event with the given Seq on a bus of epoch
+func eventID(epoch int64, seq uint64) string {
+	return strconv.FormatInt(epoch, 10) + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseEventID splits an ID made by eventID. ok is false for anything else,
+// like the plain sequence numbers earlier versions sent.
+func parseEventID(id string) (epoch int64, seq uint64, ok bool) {
+	epochText, seqText, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	epoch, err := strconv.ParseInt(epochText, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err = strconv.ParseUint(seqText, 10, 64)
+	return epoch, seq, err == nil
+}
+
+// handleStream pushes events to the client as Server-Sent Events. Every
+// event except progress and stats carries an ID made of the epoch of the
+// manager's event bus and the event's Seq, so IDs from before a restart of
+// the daemon aren't mistaken for current ones. A client that
+// reconnects with Last-Event-ID, or the last_event_id parameter, first gets
+// the events it missed; if those are no longer known, and on the first
+// connect, it gets a snapshot event with the state of all downloads instead.
+// Progress of each download and the aggregate stats are sent at most once per
+// interval (a parameter, 1s by default). The download and queue parameters
+// narrow the stream; stats are left out when it is narrowed to a download.
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming is not supported", Kind: kindConflict})
+		return
+	}
+
+	params := r.URL.Query()
+	interval := defaultStreamInterval
+	if value := params.Get("interval"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < minStreamInterval {
+			writeError(w, badRequestf("interval must be a duration of at least %s, like 500ms", minStreamInterval))
+			return
+		}
+		interval = d
+	}
+	filter := streamFilter{download: params.Get("download"), queue: params.Get("queue")}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = params.Get("last_event_id")
+	}
+	epoch, since, resume := parseEventID(lastID)
+
+	bus := s.manager.Events()
+	sub, missed, last, ok := bus.SubscribeSince(epoch, since, streamBuffer, events.DropOldest)
+	defer sub.Close()
+	epoch = bus.Epoch()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	sw := &sseWriter{w: w}
+	_, sw.err = fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay.Milliseconds())
+	if resume && ok {
+		for _, e := range missed {
+			if filter.match(e) {
+				sw.writeJSON(string(e.Type), eventID(epoch, e.Seq), e)
+			}
+		}
+	} else {
+		s.writeSnapshot(sw, filter, eventID(epoch, last))
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	progress := map[string]events.Event{} // Latest unsent progress of each download
+	var lastStats []byte
+	var dropped uint64
+	for sw.err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			last = e.Seq
+			if !filter.match(e) {
+				continue
+			}
+			if e.Type == events.Progress {
+				progress[e.DownloadID] = e
+				continue
+			}
+			// The download's progress is out of date after any other event
+			delete(progress, e.DownloadID)
+			sw.writeJSON(string(e.Type), eventID(epoch, e.Seq), e)
+		case <-ticker.C:
+			ids := make([]string, 0, len(progress))
+			for id := range progress {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				sw.writeJSON(string(events.Progress), "", progress[id])
+				delete(progress, id)
+			}
+
+			if filter.download == "" {
+				data, err := json.Marshal(s.stats())
+				if err == nil && string(data) != string(lastStats) {
+					sw.write("stats", "", data)
+					lastStats = data
+				}
+			}
+		case <-keepAlive.C:
+			_, sw.err = io.WriteString(w, ": keep-alive\n\n")
+		}
+
+		// The client fell behind and lost events, so bring it up to date
+		if n := sub.Dropped(); n != dropped {
+			dropped = n
+			clear(progress)
+			s.writeSnapshot(sw, filter, eventID(epoch, last))
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSnapshot sends the state of the downloads matching filter, with the
+// ID of the last event it includes
+func (s *server) writeSnapshot(sw *sseWriter, filter streamFilter, id string) {
+	downloads := []downloader.Snapshot{}
+	for _, d := range s.manager.Snapshot() {
+		if filter.matchSnapshot(d) {
+			downloads = append(downloads, d)
+		}
+	}
+	sw.writeJSON("snapshot", id, downloads)
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+)
+
+// sseEvent is an event read from the stream
+type sseEvent struct {
+	id, name, data string
+}
+
+// stream reads Server-Sent Events from /stream
+type stream struct {
+	t       *testing.T
+	scanner *bufio.Scanner
+	cancel  context.CancelFunc
+}
+
+// openStream connects to /stream, sending lastID as Last-Event-ID unless it is empty
+func openStream(t *testing.T, url, lastID string) *stream {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+apiPrefix+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream answered %s", resp.Status)
+	}
+	s := &stream{t: t, scanner: bufio.NewScanner(resp.Body), cancel: func() {
+		cancel()
+		resp.Body.Close()
+	}}
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *stream) close() {
+	s.cancel()
+}
+
+// next returns the next event that isn't progress or stats
+func (s *stream) next() sseEvent {
+	s.t.Helper()
+	var e sseEvent
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case line == "":
+			if e.name != "" && e.name != string(events.Progress) && e.name != "stats" {
+				return e
+			}
+			e = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	s.t.Fatalf("stream ended: %v", s.scanner.Err())
+	return e
+}
+
+func TestEventIDs(t *testing.T) {
+	for _, id := range []string{"1760860233000000000-42", "0-0"} {
+		epoch, seq, ok := parseEventID(id)
+		if !ok || eventID(epoch, seq) != id {
+			t.Errorf("%q parsed as %d, %d, %v", id, epoch, seq, ok)
+		}
+	}
+	for _, id := range []string{"", "42", "-42", "x-1", "1-x", "1-2-3", "1--2"} {
+		if _, _, ok := parseEventID(id); ok {
+			t.Errorf("%q parsed as an event ID", id)
+		}
+	}
+}
+
+func TestStreamResumes(t *testing.T) {
+	srv, m := newTestAPI(t)
+	m.Events().Retain(replayEvents)
+	epoch := m.Events().Epoch()
+
+	s := openStream(t, srv.URL, "")
+	first := s.next()
+	if first.name != "snapshot" || first.id != eventID(epoch, 0) {
+		t.Fatalf("first event %+v, want a snapshot with ID %s", first, eventID(epoch, 0))
+	}
+
+	a, err := m.AddURL("http://example.com/a.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := s.next()
+	if added.name != string(events.Added) || !strings.Contains(added.data, a) {
+		t.Fatalf("event %+v, want download %s added", added, a)
+	}
+	s.close()
+
+	// Events published while away are replayed, without a snapshot
+	b, err := m.AddURL("http://example.com/b.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := openStream(t, srv.URL, added.id).next()
+	if replayed.name != string(events.Added) || !strings.Contains(replayed.data, b) {
+		t.Fatalf("first event after reconnecting %+v, want download %s added", replayed, b)
+	}
+	if _, seq, _ := parseEventID(replayed.id); replayed.id != eventID(epoch, seq) {
+		t.Errorf("replayed event has ID %s, want epoch %d", replayed.id, epoch)
+	}
+
+	// IDs of another run, or of the format before epochs, get a snapshot
+	_, seq, _ := parseEventID(added.id)
+	for _, lastID := range []string{eventID(epoch-1, seq), fmt.Sprint(seq)} {
+		e := openStream(t, srv.URL, lastID).next()
+		if e.name != "snapshot" {
+			t.Fatalf("reconnecting with %s: first event %+v, want a snapshot", lastID, e)
+		}
+		var downloads []downloader.Snapshot
+		if err := json.Unmarshal([]byte(e.data), &downloads); err != nil {
+			t.Fatal(err)
+		}
+		if len(downloads) != 2 {
+			t.Errorf("reconnecting with %s: snapshot has %d downloads, want 2", lastID, len(downloads))
+		}
+		if _, snapshotSeq, _ := parseEventID(e.id); e.id != eventID(epoch, snapshotSeq) {
+			t.Errorf("snapshot has ID %s, want epoch %d", e.id, epoch)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the dashboard, a static page that works on the API with the
+// token the user gives it
+//
+//go:embed web
+var webFiles embed.FS
+
+// webHandler serves the dashboard. It needs no token: the page holds no
+// data and asks for the token before it makes any request.
+func webHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // The directory is embedded, so it is always there
+	}
+	fileServer := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			allow(w, r, http.MethodGet)
+			return
+		}
+		// Only the page's own files may run, and no other site may frame it
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,533 @@
+// Dashboard of the download manager daemon. It talks to the REST API with
+// the token kept in this browser, and follows /stream for live updates.
+"use strict";
+
+const API = "/api/v1";
+const TOKEN_KEY = "download-manager-token";
+const RECONNECT_DELAY = 3000;
+
+const state = {
+  token: localStorage.getItem(TOKEN_KEY) || "",
+  downloads: new Map(), // By ID, in the order they were added
+  rows: new Map(), // Table rows of the downloads, by ID
+  queues: [],
+  editing: null, // Name of the queue being edited, "" for a new one
+  lastEventId: "",
+  stream: null, // AbortController of the event stream
+};
+
+const $ = (id) => document.getElementById(id);
+
+// api calls the REST API and returns the decoded response. Failed requests
+// throw an Error carrying the daemon's message and problems.
+async function api(method, path, body) {
+  const options = { method, headers: { Authorization: "Bearer " + state.token } };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  const resp = await fetch(API + path, options);
+  if (resp.status === 401) {
+    showLogin("The token was not accepted.");
+    throw new Error("unauthorized");
+  }
+  if (resp.status === 204) {
+    return null;
+  }
+  const data = await resp.json();
+  if (!resp.ok) {
+    // The message of invalid settings already lists the problems
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+// run calls fn and shows what went wrong, if anything
+async function run(fn) {
+  try {
+    await fn();
+    hideMessage();
+  } catch (err) {
+    if (err.message !== "unauthorized") {
+      showMessage(err.message);
+    }
+  }
+}
+
+function showMessage(text, info) {
+  const el = $("message");
+  el.textContent = text;
+  el.className = info ? "info" : "";
+  el.hidden = false;
+}
+
+function hideMessage() {
+  $("message").hidden = true;
+}
+
+function showLogin(reason) {
+  stopStream();
+  $("app").hidden = true;
+  $("login").hidden = false;
+  if (reason) {
+    showMessage(reason);
+  }
+  $("token").focus();
+}
+
+// Formatting
+
+function formatSize(bytes) {
+  if (!bytes) {
+    return "-";
+  }
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function formatSpeed(bytesPerSecond) {
+  return bytesPerSecond > 0 ? formatSize(bytesPerSecond) + "/s" : "";
+}
+
+function formatLimit(kbps) {
+  return kbps > 0 ? kbps + " KB/s" : "unlimited";
+}
+
+function el(tag, text, className) {
+  const node = document.createElement(tag);
+  if (text !== undefined) {
+    node.textContent = text;
+  }
+  if (className) {
+    node.className = className;
+  }
+  return node;
+}
+
+function button(label, onClick) {
+  const b = el("button", label);
+  b.type = "button";
+  b.addEventListener("click", () => run(onClick));
+  return b;
+}
+
+// Downloads
+
+// Actions offered for each status, like the interface's key bindings
+const ACTIONS = {
+  pending: ["pause", "cancel"],
+  scheduled: ["pause", "cancel"],
+  downloading: ["pause", "cancel"],
+  verifying: ["cancel"],
+  "post-processing": ["cancel"],
+  paused: ["resume", "cancel"],
+  error: ["retry", "remove"],
+  completed: ["remove"],
+  cancelled: ["remove"],
+};
+
+function renderDownloads() {
+  const filter = $("filter-queue").value;
+  const body = $("downloads");
+  const rows = new Map();
+  for (const d of state.downloads.values()) {
+    if (filter && d.queue !== filter) {
+      continue;
+    }
+    // Keep the row being used, so an open queue picker stays open
+    let row = state.rows.get(d.id);
+    if (!row || !row.contains(document.activeElement)) {
+      row = downloadRow(d);
+    }
+    rows.set(d.id, row);
+  }
+  state.rows = rows;
+  body.replaceChildren(...rows.values());
+  $("no-downloads").hidden = body.children.length > 0;
+}
+
+function downloadRow(d) {
+  const row = el("tr");
+  const file = el("td", d.filename || d.url, "file");
+  file.title = d.url + "\n" + d.target_path;
+  row.appendChild(file);
+
+  const queue = el("td");
+  const move = el("select");
+  move.title = "Move to queue";
+  for (const q of state.queues) {
+    const option = el("option", q.name);
+    option.value = q.name;
+    option.selected = q.name === d.queue;
+    move.appendChild(option);
+  }
+  move.addEventListener("change", () =>
+    run(async () => {
+      const moved = await api("PATCH", "/downloads/" + encodeURIComponent(d.id), { queue: move.value });
+      move.blur();
+      state.downloads.set(moved.id, moved);
+      renderDownloads();
+    }));
+  queue.appendChild(move);
+  row.appendChild(queue);
+
+  const status = el("td", d.status, "status-" + d.status);
+  if (d.error) {
+    status.title = d.error;
+  }
+  row.appendChild(status);
+
+  const progress = el("td");
+  const bar = el("progress");
+  bar.max = 100;
+  if (d.total_size > 0) {
+    bar.value = Math.min(100, (100 * d.downloaded) / d.total_size);
+  }
+  progress.appendChild(bar);
+  progress.appendChild(document.createTextNode(" " + (d.total_size > 0 ? bar.value.toFixed(1) + "%" : "")));
+  row.appendChild(progress);
+
+  row.appendChild(el("td", d.status === "downloading" ? formatSpeed(d.speed) : ""));
+  row.appendChild(el("td", formatSize(d.total_size)));
+
+  const actions = el("td", undefined, "actions");
+  for (const action of ACTIONS[d.status] || []) {
+    const path = "/downloads/" + encodeURIComponent(d.id);
+    if (action === "remove") {
+      actions.appendChild(button("remove", () => api("DELETE", path)));
+    } else if (action === "cancel") {
+      actions.appendChild(button("cancel", async () => {
+        if (confirm("Cancel " + (d.filename || d.url) + " and delete its partial file?")) {
+          await api("POST", path + "/cancel");
+        }
+      }));
+    } else {
+      actions.appendChild(button(action, () => api("POST", path + "/" + action)));
+    }
+  }
+  row.appendChild(actions);
+  return row;
+}
+
+async function loadDownloads() {
+  const downloads = await api("GET", "/downloads");
+  setDownloads(downloads);
+}
+
+function setDownloads(downloads) {
+  state.downloads = new Map(downloads.map((d) => [d.id, d]));
+  renderDownloads();
+}
+
+// Queues and limits
+
+function renderQueues() {
+  const body = $("queues");
+  body.replaceChildren();
+  for (const q of state.queues) {
+    const row = el("tr");
+    row.appendChild(el("td", q.name + (q.default ? " (default)" : "")));
+    row.appendChild(el("td", q.enabled ? "yes" : "no"));
+    row.appendChild(el("td", String(q.max_concurrent)));
+    row.appendChild(el("td", formatLimit(q.speed_limit)));
+    row.appendChild(el("td", q.start_time + "-" + q.end_time));
+    const count = Object.values(q.counts || {}).reduce((a, b) => a + b, 0);
+    row.appendChild(el("td", String(count)));
+    row.appendChild(el("td", q.path || "", "file"));
+    const actions = el("td", undefined, "actions");
+    actions.appendChild(button("edit", () => editQueue(q)));
+    if (!q.default) {
+      actions.appendChild(button("delete", async () => {
+        if (confirm("Delete queue " + q.name + "?")) {
+          await api("DELETE", "/queues/" + encodeURIComponent(q.name));
+          await loadQueues();
+        }
+      }));
+    }
+    row.appendChild(actions);
+    body.appendChild(row);
+  }
+
+  // Queue pickers keep their selection where the queue still exists
+  for (const id of ["add-queue", "filter-queue"]) {
+    const select = $(id);
+    const current = select.value;
+    const first = id === "filter-queue" ? [["", "all queues"]] : [];
+    select.replaceChildren();
+    for (const [value, label] of first.concat(state.queues.map((q) => [q.name, q.name]))) {
+      const option = el("option", label);
+      option.value = value;
+      select.appendChild(option);
+    }
+    const fallback = id === "add-queue" ? (state.queues.find((q) => q.default) || {}).name : "";
+    select.value = state.queues.some((q) => q.name === current) ? current : fallback || "";
+  }
+}
+
+async function loadQueues() {
+  state.queues = await api("GET", "/queues");
+  renderQueues();
+  renderDownloads();
+}
+
+function editQueue(q) {
+  const form = $("queue-form");
+  state.editing = q ? q.name : "";
+  $("queue-form-title").textContent = q ? "Edit queue " + q.name : "New queue";
+  const values = q || { name: "", max_concurrent: 3, speed_limit: 0, start_time: "00:00", end_time: "23:59", path: "", enabled: true };
+  for (const input of form.querySelectorAll("input")) {
+    if (input.type === "checkbox") {
+      input.checked = values[input.name];
+    } else {
+      input.value = values[input.name];
+    }
+  }
+  form.hidden = false;
+  form.elements.name.focus();
+}
+
+function queueFromForm(form) {
+  return {
+    name: form.elements.name.value.trim(),
+    max_concurrent: Number(form.elements.max_concurrent.value),
+    speed_limit: Number(form.elements.speed_limit.value),
+    start_time: form.elements.start_time.value,
+    end_time: form.elements.end_time.value,
+    path: form.elements.path.value.trim(),
+    enabled: form.elements.enabled.checked,
+  };
+}
+
+async function loadLimits() {
+  const limits = await api("GET", "/limits");
+  const form = $("limits");
+  form.elements.max_active.value = limits.max_active;
+  form.elements.speed_limit.value = limits.speed_limit;
+}
+
+function renderStats(stats) {
+  const counts = stats.counts || {};
+  const parts = [
+    (counts.downloading || 0) + " active",
+    (counts.pending || 0) + " pending",
+  ];
+  if (stats.speed > 0) {
+    parts.push(formatSpeed(stats.speed));
+  }
+  $("summary").textContent = parts.join(" · ");
+}
+
+// Live updates
+
+function setOnline(online) {
+  const el = $("connection");
+  el.textContent = online ? "live" : "offline";
+  el.className = online ? "online" : "offline";
+}
+
+function stopStream() {
+  if (state.stream) {
+    state.stream.abort();
+    state.stream = null;
+  }
+  setOnline(false);
+}
+
+// followStream reads /stream with fetch, which unlike EventSource can send
+// the token in a header, and reconnects with the last event ID when it ends
+async function followStream() {
+  const controller = new AbortController();
+  state.stream = controller;
+  while (state.stream === controller) {
+    try {
+      const headers = { Authorization: "Bearer " + state.token };
+      if (state.lastEventId) {
+        headers["Last-Event-ID"] = state.lastEventId;
+      }
+      const resp = await fetch(API + "/stream", { headers, signal: controller.signal });
+      if (resp.status === 401) {
+        showLogin("The token was not accepted.");
+        return;
+      }
+      if (!resp.ok) {
+        throw new Error(resp.statusText);
+      }
+      setOnline(true);
+      await readEvents(resp.body, handleEvent);
+    } catch (err) {
+      if (controller.signal.aborted) {
+        return;
+      }
+    }
+    setOnline(false);
+    await new Promise((resolve) => setTimeout(resolve, RECONNECT_DELAY));
+  }
+}
+
+// readEvents parses Server-Sent Events from body and hands each to onEvent
+async function readEvents(body, onEvent) {
+  const reader = body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += value;
+    let end;
+    while ((end = buffer.indexOf("\n\n")) >= 0) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+      const event = { id: "", name: "message", data: "" };
+      for (const line of block.split("\n")) {
+        const colon = line.indexOf(":");
+        if (colon === 0) {
+          continue; // Comment
+        }
+        const field = colon < 0 ? line : line.slice(0, colon);
+        const value = colon < 0 ? "" : line.slice(colon + 1).replace(/^ /, "");
+        if (field === "id") {
+          event.id = value;
+        } else if (field === "event") {
+          event.name = value;
+        } else if (field === "data") {
+          event.data += value;
+        }
+      }
+      if (event.id) {
+        state.lastEventId = event.id;
+      }
+      if (event.data) {
+        onEvent(event.name, JSON.parse(event.data));
+      }
+    }
+  }
+}
+
+function handleEvent(name, data) {
+  switch (name) {
+    case "snapshot":
+      setDownloads(data);
+      return;
+    case "stats":
+      renderStats(data);
+      return;
+    case "config-updated":
+    case "config-reloaded":
+      run(() => Promise.all([loadQueues(), loadLimits()]));
+      return;
+    case "network-lost":
+      showMessage("The network is down; active downloads are paused until it returns.");
+      return;
+    case "network-restored":
+      showMessage("The network is back; downloads resumed.", true);
+      return;
+    case "removed":
+    case "cancelled":
+      state.downloads.delete(data.download_id);
+      renderDownloads();
+      run(loadQueues); // For the number of downloads in each
+      return;
+  }
+  if (!data.download_id) {
+    return;
+  }
+
+  const d = state.downloads.get(data.download_id);
+  if (!d || name === "added") {
+    // The event doesn't carry the file name and path, so fetch them
+    run(async () => {
+      const fresh = await api("GET", "/downloads/" + encodeURIComponent(data.download_id));
+      state.downloads.set(fresh.id, fresh);
+      await loadQueues();
+    });
+    return;
+  }
+  Object.assign(d, {
+    status: data.status || d.status,
+    queue: data.queue || d.queue,
+    downloaded: data.downloaded,
+    total_size: data.total_size || d.total_size,
+    speed: data.speed,
+    error: data.error || (name === "failed" ? d.error : ""),
+  });
+  renderDownloads();
+}
+
+// Start up
+
+async function start() {
+  $("login").hidden = true;
+  await Promise.all([loadQueues(), loadLimits(), loadDownloads()]);
+  $("app").hidden = false;
+  hideMessage();
+  followStream();
+}
+
+function init() {
+  $("login").addEventListener("submit", (e) => {
+    e.preventDefault();
+    state.token = $("token").value.trim();
+    localStorage.setItem(TOKEN_KEY, state.token);
+    run(start);
+  });
+  $("logout").addEventListener("click", () => {
+    localStorage.removeItem(TOKEN_KEY);
+    state.token = "";
+    showLogin();
+  });
+
+  $("add").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const request = { url: $("add-url").value.trim(), queue: $("add-queue").value };
+    if ($("add-start").value) {
+      request.start = new Date($("add-start").value).toISOString();
+    }
+    run(async () => {
+      await api("POST", "/downloads", request);
+      $("add-url").value = "";
+      $("add-start").value = "";
+    });
+  });
+  $("filter-queue").addEventListener("change", renderDownloads);
+
+  $("new-queue").addEventListener("click", () => editQueue(null));
+  $("queue-cancel").addEventListener("click", () => {
+    $("queue-form").hidden = true;
+  });
+  $("queue-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const queue = queueFromForm(e.target);
+    run(async () => {
+      if (state.editing) {
+        await api("PATCH", "/queues/" + encodeURIComponent(state.editing), queue);
+      } else {
+        await api("POST", "/queues", queue);
+      }
+      $("queue-form").hidden = true;
+      await loadQueues();
+    });
+  });
+
+  $("limits").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const form = e.target;
+    run(() => api("PUT", "/limits", {
+      max_active: Number(form.elements.max_active.value),
+      speed_limit: Number(form.elements.speed_limit.value),
+    }));
+  });
+
+  if (state.token) {
+    run(start);
+  } else {
+    showLogin();
+  }
+}
+
+document.addEventListener("DOMContentLoaded", init);
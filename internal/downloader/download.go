@@ -1,47 +1,95 @@
 package downloader
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/fsutil"
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
 )
 
-// Download represents a download task with its state and control channels
+// ErrCancelled is returned by Start when the download was cancelled
+var ErrCancelled = errors.New("download cancelled")
+
+// ErrChecksumMismatch is wrapped by the error of a download whose file
+// doesn't have the expected checksum. Such downloads aren't retried.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Download represents a download task with its state and control fields
 type Download struct {
-	URL                string    `json:"url"`
-	TargetPath         string    `json:"target_path"`
-	Filename           string    `json:"filename"`
-	Queue              string    `json:"queue"`
-	Status             string    `json:"status"` // pending, downloading, paused, completed, error, cancelled
-	Progress           float64   `json:"progress"`
-	Speed              int64     `json:"speed"` // bytes per second
-	TotalSize          int64     `json:"total_size"`
-	Downloaded         int64     `json:"downloaded"`
-	Error              string    `json:"error,omitempty"`
-	MaxBandwidth       int64     `json:"max_bandwidth"` // in KB/s, 0 means unlimited
-	StartTime          time.Time `json:"start_time,omitempty"`
-	CompletionTime     time.Time `json:"completion_time,omitempty"`
-	ScheduledStartTime time.Time `json:"scheduled_start_time,omitempty"`
+	ID                 string        `json:"id"`
+	URL                string        `json:"url"`
+	TargetPath         string        `json:"target_path"`
+	Filename           string        `json:"filename"`
+	Queue              string        `json:"queue"`
+	Status             Status        `json:"status"`
+	Progress           float64       `json:"progress"`
+	Speed              int64         `json:"speed"` // bytes per second
+	TotalSize          int64         `json:"total_size"`
+	Downloaded         int64         `json:"downloaded"`
+	Error              string        `json:"error,omitempty"`
+	MaxBandwidth       int64         `json:"max_bandwidth"`         // in KB/s, 0 means unlimited
+	Checksum           string        `json:"checksum,omitempty"`    // Expected SHA-256 of the file in hex, checked when it is finished
+	Headers            []string      `json:"headers,omitempty"`     // Extra request headers as "Name: value", sent with every request
+	StartTime          time.Time     `json:"start_time,omitempty"`  // When the download first started
+	ActiveTime         time.Duration `json:"active_time,omitempty"` // Time spent transferring the file, over all runs
+	CompletionTime     time.Time     `json:"completion_time,omitempty"`
+	ScheduledStartTime time.Time     `json:"scheduled_start_time,omitempty"`
+	History            []Transition  `json:"history,omitempty"` // Most recent status changes, oldest first
 
 	// Control fields (not persisted to JSON)
-	pauseChan      chan struct{} `json:"-"`
-	resumeChan     chan struct{} `json:"-"`
-	cancelChan     chan struct{} `json:"-"`
-	isPaused       bool          `json:"-"`
-	isCancelled    bool          `json:"-"`
-	mutex          sync.Mutex    `json:"-"`
-	RetryCount     int           `json:"retry_count"`
-	MaxRetries     int           `json:"max_retries"`
-	RetryDelay     time.Duration `json:"-"`
-	client         *http.Client  `json:"-"`
-	supportsRanges bool          `json:"-"`
+	runCancel      context.CancelFunc `json:"-"` // Aborts the current attempt or wait, nil when idle
+	wake           chan struct{}      `json:"-"` // Signalled by Resume and Cancel to end a pause
+	running        bool               `json:"-"`
+	isPaused       bool               `json:"-"`
+	isCancelled    bool               `json:"-"`
+	mutex          sync.Mutex         `json:"-"`
+	RetryCount     int                `json:"retry_count"`
+	MaxRetries     int                `json:"max_retries"`
+	RetryDelay     time.Duration      `json:"-"`
+	client         *http.Client       `json:"-"`
+	supportsRanges bool               `json:"-"`
+	events         *events.Bus        `json:"-"`
+	shared         *RateLimiter       `json:"-"` // Limits all downloads together, nil for no global limit
+}
+
+// Snapshot is an immutable copy of a download's state, safe to read and copy
+// without holding any lock. It encodes to JSON like the download itself.
+type Snapshot struct {
+	ID                 string        `json:"id"`
+	URL                string        `json:"url"`
+	TargetPath         string        `json:"target_path"`
+	Filename           string        `json:"filename"`
+	Queue              string        `json:"queue"`
+	Status             Status        `json:"status"`
+	Progress           float64       `json:"progress"`
+	Speed              int64         `json:"speed"`
+	TotalSize          int64         `json:"total_size"`
+	Downloaded         int64         `json:"downloaded"`
+	Error              string        `json:"error,omitempty"`
+	MaxBandwidth       int64         `json:"max_bandwidth"`
+	Checksum           string        `json:"checksum,omitempty"`
+	Headers            []string      `json:"headers,omitempty"`
+	StartTime          time.Time     `json:"start_time,omitempty"`
+	ActiveTime         time.Duration `json:"active_time,omitempty"`
+	CompletionTime     time.Time     `json:"completion_time,omitempty"`
+	ScheduledStartTime time.Time     `json:"scheduled_start_time,omitempty"`
+	RetryCount         int           `json:"retry_count"`
+	MaxRetries         int           `json:"max_retries"`
+	History            []Transition  `json:"history,omitempty"`
 }
 
 // DownloadResult represents the outcome of a download attempt
@@ -53,22 +101,72 @@ type DownloadResult struct {
 	ShouldRetry bool
 }
 
-// Initialize sets up control channels for a download
+// NewID generates a random identifier for a download
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to a time based ID if the random source fails
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Snapshot returns a consistent copy of the download's current state
+func (d *Download) Snapshot() Snapshot {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return Snapshot{
+		ID:                 d.ID,
+		URL:                d.URL,
+		TargetPath:         d.TargetPath,
+		Filename:           d.Filename,
+		Queue:              d.Queue,
+		Status:             d.Status,
+		Progress:           d.Progress,
+		Speed:              d.Speed,
+		TotalSize:          d.TotalSize,
+		Downloaded:         d.Downloaded,
+		Error:              d.Error,
+		MaxBandwidth:       d.MaxBandwidth,
+		Checksum:           d.Checksum,
+		Headers:            append([]string(nil), d.Headers...),
+		StartTime:          d.StartTime,
+		ActiveTime:         d.ActiveTime,
+		CompletionTime:     d.CompletionTime,
+		ScheduledStartTime: d.ScheduledStartTime,
+		RetryCount:         d.RetryCount,
+		MaxRetries:         d.MaxRetries,
+		History:            append([]Transition(nil), d.History...),
+	}
+}
+
+// downloadJSON has the same fields as Download but none of its methods, so it
+// can be marshalled without recursing into MarshalJSON
+type downloadJSON Download
+
+// MarshalJSON encodes the download while holding its lock, so a running
+// transfer can't change fields halfway through
+func (d *Download) MarshalJSON() ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return json.Marshal((*downloadJSON)(d))
+}
+
+// Initialize sets up control fields for a download
 func (d *Download) Initialize() {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if d.pauseChan == nil {
-		d.pauseChan = make(chan struct{}, 1)
-	}
-	if d.resumeChan == nil {
-		d.resumeChan = make(chan struct{}, 1)
+	if d.ID == "" {
+		d.ID = NewID()
 	}
-	if d.cancelChan == nil {
-		d.cancelChan = make(chan struct{}, 1)
+
+	if d.wake == nil {
+		d.wake = make(chan struct{}, 1)
 	}
-	if d.Status == "" {
-		d.Status = "pending"
+	if !d.Status.Valid() {
+		// Unknown or missing statuses in saved state start over as pending
+		d.Status = StatusPending
 		logger.LogDownloadPending(d.URL, d.Queue, "Initialized download")
 	}
 	if d.MaxRetries == 0 {
@@ -78,9 +176,9 @@ func (d *Download) Initialize() {
 		d.RetryDelay = 5 * time.Second
 	}
 	if d.client == nil {
-		// Configure HTTP client with more lenient timeouts
+		// Configure HTTP client with more lenient timeouts. There is no overall
+		// timeout: transfers can take long and are stopped through their context.
 		d.client = &http.Client{
-			Timeout: 60 * time.Second,
 			Transport: &http.Transport{
 				TLSHandshakeTimeout:   30 * time.Second,
 				ResponseHeaderTimeout: 30 * time.Second,
@@ -99,22 +197,20 @@ func (d *Download) Initialize() {
 	}
 }
 
-// Pause signals the download to pause
+// Pause stops the transfer, aborting any request in flight. The data
+// received so far is kept, so Resume continues where it left off.
 func (d *Download) Pause() {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
 	// Only allow pausing if we're actually downloading and not already paused
-	if d.Status == "downloading" && !d.isPaused && !d.isCancelled {
-		oldStatus := d.Status
-		d.Status = "paused"
+	if (d.Status == StatusDownloading || d.Status == StatusScheduled) && !d.isPaused && !d.isCancelled {
+		d.transitionLocked(StatusPaused)
 		d.isPaused = true
-		// Log status change to paused
-		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		// Send pause signal
-		select {
-		case d.pauseChan <- struct{}{}:
-		default:
+		d.Speed = 0
+		d.emitLocked(events.Paused, "")
+		if d.runCancel != nil {
+			d.runCancel()
 		}
 	} else {
 		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Cannot pause: status=%s, isPaused=%v, isCancelled=%v",
@@ -122,76 +218,91 @@ func (d *Download) Pause() {
 	}
 }
 
-// Resume signals the download to resume
+// Resume continues a paused transfer. It only has an effect while Start is
+// running; a download that isn't running must be started again instead.
 func (d *Download) Resume() {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
 	// Only allow resuming if we're actually paused
-	if d.Status == "paused" && d.isPaused && !d.isCancelled {
-		oldStatus := d.Status
-		d.Status = "downloading"
+	if d.Status == StatusPaused && d.isPaused && !d.isCancelled {
+		d.transitionLocked(StatusDownloading)
 		d.isPaused = false
-		// Log status change to downloading (resumed)
-		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		// Send resume signal
-		select {
-		case d.resumeChan <- struct{}{}:
-		default:
-		}
+		d.emitLocked(events.Resumed, "")
+		d.signalWake()
 	} else {
 		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Cannot resume: status=%s, isPaused=%v, isCancelled=%v",
 			d.Status, d.isPaused, d.isCancelled))
 	}
 }
 
-// Cancel stops the download and removes temporary files
+// Cancel stops the download, aborting any request in flight, and removes temporary files
 func (d *Download) Cancel() error {
 	d.mutex.Lock()
-	oldStatus := d.Status
 	defer d.mutex.Unlock()
 
-	if d.Status != "completed" && d.Status != "cancelled" && !d.isCancelled {
-		d.Status = "cancelled"
+	if !d.isCancelled && d.Status.CanTransitionTo(StatusCancelled) {
+		d.transitionLocked(StatusCancelled)
 		d.isCancelled = true
-		// Log status change to cancelled
-		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		select {
-		case d.cancelChan <- struct{}{}:
-		default:
+		d.Speed = 0
+		d.emitLocked(events.Cancelled, "")
+		if d.runCancel != nil {
+			d.runCancel()
 		}
+		d.signalWake()
 
-		// Only attempt to remove the file if it was created
-		if d.TargetPath != "" && d.Progress > 0 {
-			if err := os.Remove(d.TargetPath); err != nil && !os.IsNotExist(err) {
-				errorMsg := fmt.Sprintf("failed to remove file: %v", err)
-				logger.LogDownloadError(d.URL, d.Queue, errorMsg)
-				return fmt.Errorf("failed to remove file: %v", err)
-			}
+		return d.removeFilesLocked()
+	}
+	return nil
+}
+
+// removeFilesLocked deletes the partial file and resume state of a cancelled
+// download. The caller must hold d.mutex.
+func (d *Download) removeFilesLocked() error {
+	// Only attempt to remove the file if it was created
+	d.removeSidecarLocked()
+	if d.TargetPath != "" && d.Downloaded > 0 {
+		if err := os.Remove(d.TargetPath); err != nil && !os.IsNotExist(err) {
+			errorMsg := fmt.Sprintf("failed to remove file: %v", err)
+			logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+			return fmt.Errorf("failed to remove file: %v", err)
 		}
 	}
 	return nil
 }
 
+// signalWake wakes a Start that is waiting out a pause. The caller must hold d.mutex.
+func (d *Download) signalWake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// IsRunning reports whether Start is currently executing for this download
+func (d *Download) IsRunning() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.running
+}
+
 // Retry attempts to restart a failed download
 func (d *Download) Retry() error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if d.Status == "error" {
-		oldStatus := d.Status // Save the old status for logging
-		d.Status = "pending"
+	if d.Status == StatusError {
+		d.transitionLocked(StatusPending)
 		d.Error = ""
 		d.Progress = 0
 		d.Speed = 0
 		d.Downloaded = 0
+		d.ActiveTime = 0
 		d.RetryCount++
 
 		// Log the retry attempt
 		logger.LogDownloadEvent("RETRY", fmt.Sprintf("Retry attempt %d of %d for download %s", d.RetryCount, d.MaxRetries, d.URL))
-
-		// Log status change
-		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, 0, d.TotalSize)
+		d.emitLocked(events.Retrying, "")
 		return nil
 	}
 
@@ -199,8 +310,134 @@ func (d *Download) Retry() error {
 	return fmt.Errorf("download is not in error state")
 }
 
+// SetEventBus makes the download publish its lifecycle events on bus
+func (d *Download) SetEventBus(bus *events.Bus) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.events = bus
+}
+
+// SetSharedLimiter makes the transfer take its bytes from limiter as well,
+// which other downloads share, on top of its own bandwidth limit
+func (d *Download) SetSharedLimiter(limiter *RateLimiter) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.shared = limiter
+}
+
+// emitLocked publishes an event carrying the download's current state.
+// The caller must hold d.mutex.
+func (d *Download) emitLocked(t events.Type, errorMsg string) {
+	e := d.eventLocked(t)
+	e.Error = errorMsg
+	d.events.Publish(e)
+}
+
+// emitErrorLocked publishes an event about err, sorted into its kind. The
+// caller must hold d.mutex.
+func (d *Download) emitErrorLocked(t events.Type, err error) {
+	e := d.eventLocked(t)
+	e.Error = err.Error()
+	e.ErrorKind = ErrorKind(err)
+	d.events.Publish(e)
+}
+
+// eventLocked returns an event of type t carrying the download's current
+// state. The caller must hold d.mutex.
+func (d *Download) eventLocked(t events.Type) events.Event {
+	return events.Event{
+		Type:       t,
+		DownloadID: d.ID,
+		URL:        d.URL,
+		Queue:      d.Queue,
+		Status:     string(d.Status),
+		Downloaded: d.Downloaded,
+		TotalSize:  d.TotalSize,
+		Speed:      d.Speed,
+	}
+}
+
+// emit publishes an event carrying the download's current state
+func (d *Download) emit(t events.Type, errorMsg string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.emitLocked(t, errorMsg)
+}
+
+// SetStatus changes the status of the download. It returns a
+// *TransitionError if the change isn't allowed from the current status.
+func (d *Download) SetStatus(status Status) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.transitionLocked(status)
+}
+
+// transitionLocked changes the status to next if the transition table allows
+// it and records the change in History. The caller must hold d.mutex.
+func (d *Download) transitionLocked(next Status) error {
+	prev := d.Status
+	if !prev.CanTransitionTo(next) {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Rejected status change from %s to %s", prev, next))
+		return &TransitionError{From: prev, To: next}
+	}
+
+	d.Status = next
+	d.History = append(d.History, Transition{From: prev, To: next, Time: time.Now()})
+	if len(d.History) > maxHistory {
+		d.History = append([]Transition(nil), d.History[len(d.History)-maxHistory:]...)
+	}
+	logger.LogDownloadStatus(d.URL, string(prev), string(next), d.Downloaded, d.TotalSize)
+	return nil
+}
+
+// SetQueue sets the queue the download belongs to
+func (d *Download) SetQueue(queue string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.Queue = queue
+}
+
+// SetMaxBandwidth changes the bandwidth limit (in KB/s, 0 for unlimited).
+// A running transfer picks up the new limit on its next read.
+func (d *Download) SetMaxBandwidth(maxBandwidth int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.MaxBandwidth = maxBandwidth
+}
+
+// Relocate moves the (possibly partial) file to newPath and updates TargetPath.
+// A running transfer keeps writing to the same file after it has been moved.
+func (d *Download) Relocate(newPath string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if newPath == d.TargetPath {
+		return nil
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("target file already exists: %s", newPath)
+	}
+
+	if _, err := os.Stat(d.TargetPath); err == nil {
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(d.TargetPath, newPath); err != nil {
+			return fmt.Errorf("failed to move file: %w", err)
+		}
+		// Bring the resume state along, if there is one
+		os.Rename(SidecarPath(d.TargetPath), SidecarPath(newPath))
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	logger.LogDownloadEvent("MOVE", fmt.Sprintf("Relocated %s from %s to %s", d.URL, d.TargetPath, newPath))
+	d.TargetPath = newPath
+	return nil
+}
+
 // GetStatus returns the current status of the download
-func (d *Download) GetStatus() string {
+func (d *Download) GetStatus() Status {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	return d.Status
@@ -235,91 +472,306 @@ func (d *Download) ResetRetryCount() {
 	logger.LogDownloadEvent("RETRY", fmt.Sprintf("Reset retry count for download %s", d.URL))
 }
 
-// Start begins downloading a file with optional progress callback
-func (d *Download) Start() error {
+// Start downloads the file, retrying failed attempts, and returns when the
+// download completes, fails for good, is cancelled or ctx is done. Pause and
+// Cancel interrupt requests and waits immediately; while paused Start blocks
+// until Resume or Cancel. If ctx ends first the download is left paused.
+func (d *Download) Start(ctx context.Context) error {
 	// Initialize control channels and fields
 	d.Initialize()
 	d.mutex.Lock()
-	oldStatus := d.Status
-	d.Status = "downloading"
-	d.StartTime = time.Now()
+	if d.isCancelled {
+		d.mutex.Unlock()
+		return ErrCancelled
+	}
+	if d.running {
+		d.mutex.Unlock()
+		return fmt.Errorf("download is already running")
+	}
+	next := StatusDownloading
+	scheduled := !d.ScheduledStartTime.IsZero() && time.Now().Before(d.ScheduledStartTime)
+	if scheduled {
+		next = StatusScheduled
+	}
+	if d.Status != next {
+		if err := d.transitionLocked(next); err != nil {
+			d.mutex.Unlock()
+			return err
+		}
+	}
+	if d.StartTime.IsZero() {
+		d.StartTime = time.Now()
+	}
+	d.running = true
+	d.isPaused = false
+	d.emitLocked(events.Started, "")
 	d.mutex.Unlock()
 
+	defer func() {
+		d.mutex.Lock()
+		d.running = false
+		d.mutex.Unlock()
+	}()
+
 	// Log download start
 	logger.LogDownloadStart(d.URL, d.Queue, d.MaxBandwidth)
-	// Log status change
-	logger.LogDownloadStatus(d.URL, oldStatus, "downloading", 0, d.TotalSize)
 
-	if !d.ScheduledStartTime.IsZero() && time.Now().Before(d.ScheduledStartTime) {
-		waitDuration := d.ScheduledStartTime.Sub(time.Now())
+	if scheduled {
+		waitDuration := time.Until(d.ScheduledStartTime)
 		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Waiting for scheduled start time: %v", d.ScheduledStartTime))
-		time.Sleep(waitDuration)
+		if err := d.sleep(ctx, waitDuration); err != nil {
+			return d.interrupted(err)
+		}
+
+		// A download resumed during the wait is already downloading
+		d.mutex.Lock()
+		if d.Status == StatusScheduled {
+			d.transitionLocked(StatusDownloading)
+		}
+		d.mutex.Unlock()
 	}
 
 	// Main download loop with retry logic
-	for d.RetryCount <= d.MaxRetries {
-		err := d.performDownload()
+	for {
+		if err := d.waitWhilePaused(ctx); err != nil {
+			return d.interrupted(err)
+		}
+
+		attemptCtx, done, ok := d.beginAttempt(ctx)
+		if !ok {
+			// Paused or cancelled in the meantime
+			continue
+		}
+		began := time.Now()
+		err := d.performDownload(attemptCtx)
+		aborted := attemptCtx.Err() != nil && ctx.Err() == nil
+		done()
+		d.mutex.Lock()
+		d.ActiveTime += time.Since(began)
+		d.mutex.Unlock()
+
+		if err == nil {
+			err = d.verify()
+		}
+
 		if err == nil {
 			// Download completed successfully
 			d.mutex.Lock()
-			oldStatus := d.Status
-			d.Status = "completed"
+			if err := d.transitionLocked(StatusCompleted); err != nil {
+				cancelled := d.isCancelled
+				d.mutex.Unlock()
+				if cancelled {
+					return d.interrupted(ErrCancelled)
+				}
+				return err
+			}
 			d.Progress = 100.0
+			d.Speed = 0
 			d.CompletionTime = time.Now()
+			d.removeSidecarLocked()
+			d.emitLocked(events.Completed, "")
+			duration := d.ActiveTime
 			d.mutex.Unlock()
 
-			// Calculate download duration
-			duration := time.Since(d.StartTime)
 			// Log download completion
 			logger.LogDownloadComplete(d.URL, d.TargetPath, duration, d.TotalSize)
-			// Log status change
-			logger.LogDownloadStatus(d.URL, oldStatus, "completed", d.TotalSize, d.TotalSize)
 			return nil
 		}
 
-		// Check if download was cancelled
 		d.mutex.Lock()
+		// Check if download was cancelled
 		if d.isCancelled {
 			d.mutex.Unlock()
-			logger.LogDownloadStatus(d.URL, "downloading", "cancelled", d.Downloaded, d.TotalSize)
-			return fmt.Errorf("download cancelled")
+			return d.interrupted(ErrCancelled)
+		}
+
+		// A pause aborts the attempt; wait for resume at the top of the loop.
+		// If it was resumed already, the attempt didn't fail and is redone.
+		if d.isPaused || aborted {
+			d.mutex.Unlock()
+			continue
+		}
+
+		if ctx.Err() != nil {
+			d.mutex.Unlock()
+			return d.interrupted(ctx.Err())
 		}
 
 		// Handle error and retry if possible
-		oldStatus := d.Status
-		d.Status = "error"
 		d.Error = err.Error()
-
-		// Log error status
+		d.Speed = 0
 		logger.LogDownloadError(d.URL, d.Queue, err.Error())
-		logger.LogDownloadStatus(d.URL, oldStatus, "error", d.Downloaded, d.TotalSize)
 
-		// Check if we should retry
-		if d.RetryCount < d.MaxRetries {
+		// Check if we should retry; a wrong checksum won't fix itself
+		if d.RetryCount < d.MaxRetries && !errors.Is(err, ErrChecksumMismatch) {
 			d.RetryCount++
-			d.Status = "pending"
+			if d.Status != StatusDownloading {
+				d.transitionLocked(StatusDownloading)
+			}
 			retryMsg := fmt.Sprintf("Retry attempt %d of %d after error: %s",
 				d.RetryCount, d.MaxRetries, err.Error())
 			logger.LogDownloadPending(d.URL, d.Queue, retryMsg)
-			logger.LogDownloadStatus(d.URL, "error", "pending", d.Downloaded, d.TotalSize)
+			d.emitErrorLocked(events.Retrying, err)
+			retryDelay := d.RetryDelay
 			d.mutex.Unlock()
-			time.Sleep(d.RetryDelay)
+			if err := d.sleep(ctx, retryDelay); err != nil {
+				return d.interrupted(err)
+			}
 			continue
 		}
 
+		finalError := fmt.Errorf("download failed after %d retries: %w", d.MaxRetries, err)
+		if errors.Is(err, ErrChecksumMismatch) {
+			finalError = err
+		}
+		d.transitionLocked(StatusError)
+		d.emitErrorLocked(events.Failed, finalError)
 		d.mutex.Unlock()
-		finalError := fmt.Errorf("download failed after %d retries: %v", d.MaxRetries, err)
 		logger.LogDownloadError(d.URL, d.Queue, finalError.Error())
 		return finalError
 	}
+}
+
+// verify checks the finished file against the size the server announced
+// and the expected checksum, if there is one
+func (d *Download) verify() error {
+	d.mutex.Lock()
+	if err := d.transitionLocked(StatusVerifying); err != nil {
+		d.mutex.Unlock()
+		return err
+	}
+	path, size, checksum := d.TargetPath, d.TotalSize, d.Checksum
+	d.mutex.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify file: %w", err)
+	}
+	if size > 0 && info.Size() != size {
+		if info.Size() > size {
+			// Resuming can't fix a file that is too large; start over
+			d.discard()
+		}
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, size, info.Size())
+	}
+
+	if checksum != "" {
+		actual, err := fsutil.SHA256(path)
+		if err != nil {
+			return fmt.Errorf("failed to verify file: %w", err)
+		}
+		if !strings.EqualFold(actual, checksum) {
+			// The file is complete but wrong, so there is nothing to resume
+			d.discard()
+			return fmt.Errorf("%w: expected SHA-256 %s, got %s", ErrChecksumMismatch, strings.ToLower(checksum), actual)
+		}
+	}
+	return nil
+}
+
+// discard deletes the file and its resume state, so the next attempt
+// starts over rather than asking for the bytes after a file that is gone
+func (d *Download) discard() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	os.Remove(d.TargetPath)
+	d.removeSidecarLocked()
+	d.Downloaded = 0
+	d.Progress = 0
+	d.ActiveTime = 0
+}
+
+// beginAttempt returns a context for the next attempt that Pause and Cancel
+// abort, and a function to release it. It reports false if the download is
+// already paused or cancelled.
+func (d *Download) beginAttempt(parent context.Context) (context.Context, context.CancelFunc, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.isPaused || d.isCancelled {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	d.runCancel = cancel
+	return ctx, func() {
+		d.mutex.Lock()
+		d.runCancel = nil
+		d.mutex.Unlock()
+		cancel()
+	}, true
+}
+
+// sleep waits for duration. It returns early without error if the download
+// is paused or cancelled, so the caller's loop can react, and with ctx's
+// error if ctx is done.
+func (d *Download) sleep(ctx context.Context, duration time.Duration) error {
+	sleepCtx, done, ok := d.beginAttempt(ctx)
+	if !ok {
+		return nil
+	}
+	defer done()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-sleepCtx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitWhilePaused blocks until the download is resumed. It returns
+// ErrCancelled if the download is cancelled and ctx's error if ctx is done.
+func (d *Download) waitWhilePaused(ctx context.Context) error {
+	for {
+		d.mutex.Lock()
+		cancelled, paused := d.isCancelled, d.isPaused
+		d.mutex.Unlock()
+
+		if cancelled {
+			return ErrCancelled
+		}
+		if !paused {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.wake:
+		}
+	}
+}
+
+// interrupted finishes Start for a download that was cancelled or whose
+// context ended. In the latter case it is left paused so it can be resumed.
+func (d *Download) interrupted(err error) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if errors.Is(err, ErrCancelled) || d.isCancelled {
+		// The aborted attempt may have written to the files after Cancel
+		// removed them
+		d.removeFilesLocked()
+		return ErrCancelled
+	}
 
-	finalError := fmt.Errorf("download failed after %d retries", d.MaxRetries)
-	logger.LogDownloadError(d.URL, d.Queue, finalError.Error())
-	return finalError
+	if d.Status != StatusPaused {
+		d.transitionLocked(StatusPaused)
+		d.Speed = 0
+		d.emitLocked(events.Paused, err.Error())
+	}
+	d.isPaused = true
+	return err
 }
 
-// performDownload handles the actual file download process
-func (d *Download) performDownload() error {
+// performDownload handles the actual file download process. Cancelling ctx
+// aborts the requests and the transfer.
+func (d *Download) performDownload(ctx context.Context) error {
 	// Ensure queue name is valid
 	if d.Queue == "" {
 		d.Queue = "default"
@@ -333,8 +785,19 @@ func (d *Download) performDownload() error {
 	var supportsRanges bool
 	var headResp *http.Response
 
-	headResp, err := d.client.Head(d.URL)
+	headReq, err := http.NewRequestWithContext(ctx, "HEAD", d.URL, nil)
+	if err != nil {
+		errorMsg := fmt.Sprintf("failed to create request: %v", err)
+		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	d.setHeaders(headReq)
+
+	headResp, err = d.client.Do(headReq)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// Log the HEAD request failure but don't return error yet
 		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("HEAD request failed: %v, proceeding with GET request", err))
 	} else {
@@ -344,7 +807,7 @@ func (d *Download) performDownload() error {
 	}
 
 	// Create the GET request
-	req, err := http.NewRequest("GET", d.URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", d.URL, nil)
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to create request: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
@@ -355,74 +818,67 @@ func (d *Download) performDownload() error {
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	d.setHeaders(req)
 
 	// If we're resuming and we know the server supports ranges, set the range header
 	d.mutex.Lock()
 	startByte := d.Downloaded
+	targetPath := d.TargetPath
 	d.supportsRanges = supportsRanges
 	d.mutex.Unlock()
 
-	if startByte > 0 && supportsRanges {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startByte))
+	// A transfer paused after its last byte arrived has nothing left to fetch
+	if startByte > 0 && startByte == totalSize {
+		return nil
 	}
 
-	// Send the request with retry logic
-	var getResp *http.Response
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		getResp, err = d.client.Do(req)
-		if err == nil {
-			break
-		}
-		if i < maxRetries-1 {
-			logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("GET request attempt %d failed: %v, retrying...", i+1, err))
-			time.Sleep(time.Second * time.Duration(i+1)) // Exponential backoff
-		}
+	if startByte > 0 && supportsRanges {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startByte))
 	}
 
+	// Send the request. A failure, including a timeout or a connection cut
+	// before the response, fails the attempt, which Start retries.
+	getResp, err := d.client.Do(req)
 	if err != nil {
-		// Check for network-related errors
-		if os.IsTimeout(err) || err == io.ErrUnexpectedEOF || err == io.EOF {
-			d.mutex.Lock()
-			d.Status = "paused"
-			d.isPaused = true
-			d.mutex.Unlock()
-			logger.LogDownloadStatus(d.URL, "downloading", "paused", d.Downloaded, d.TotalSize)
-			return fmt.Errorf("download paused due to network error: %w", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		errorMsg := fmt.Sprintf("failed to send GET request after %d attempts: %v", maxRetries, err)
-		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("GET request failed: %v", err))
 		return fmt.Errorf("failed to send GET request: %w", err)
 	}
 	defer getResp.Body.Close()
 
 	// Check if the request was successful
 	if getResp.StatusCode < 200 || getResp.StatusCode >= 300 {
-		errorMsg := fmt.Sprintf("server responded with status: %s", getResp.Status)
-		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
-		return fmt.Errorf("server responded with status: %s", getResp.Status)
+		err := &HTTPStatusError{StatusCode: getResp.StatusCode, Status: getResp.Status}
+		logger.LogDownloadError(d.URL, d.Queue, err.Error())
+		return err
 	}
 
 	// Update total size from GET response if we didn't get it from HEAD
 	if totalSize == 0 {
 		totalSize, _ = strconv.ParseInt(getResp.Header.Get("Content-Length"), 10, 64)
+		if getResp.StatusCode == http.StatusPartialContent && totalSize > 0 {
+			// Content-Length only covers the requested range
+			totalSize += startByte
+		}
 		d.mutex.Lock()
-		d.TotalSize = totalSize
 		d.supportsRanges = getResp.Header.Get("Accept-Ranges") == "bytes"
 		d.mutex.Unlock()
 	}
-
-	// If we got a 206 response, the server supports ranges
-	if getResp.StatusCode == 206 {
-		supportsRanges = true
+	if totalSize > 0 {
+		d.mutex.Lock()
+		d.TotalSize = totalSize
+		d.mutex.Unlock()
 	}
 
 	// Prepare file for writing
 	var file *os.File
 	var openMode int
 
-	if startByte > 0 && supportsRanges {
+	// Only append if the server actually sent the requested range; a 200
+	// response carries the whole file again
+	if startByte > 0 && getResp.StatusCode == http.StatusPartialContent {
 		openMode = os.O_WRONLY | os.O_APPEND
 	} else {
 		openMode = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
@@ -430,7 +886,7 @@ func (d *Download) performDownload() error {
 	}
 
 	// Verify target directory exists and is writable
-	dir := filepath.Dir(d.TargetPath)
+	dir := filepath.Dir(targetPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		errorMsg := fmt.Sprintf("failed to create directory: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
@@ -444,7 +900,7 @@ func (d *Download) performDownload() error {
 		return fmt.Errorf("target directory is not writable: %w", err)
 	}
 
-	file, err = os.OpenFile(d.TargetPath, openMode, 0644)
+	file, err = os.OpenFile(targetPath, openMode, 0644)
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to open file: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
@@ -452,9 +908,20 @@ func (d *Download) performDownload() error {
 	}
 	defer file.Close()
 
-	result := d.downloadChunks(getResp.Body, file, startByte, totalSize)
+	result := d.downloadChunks(ctx, getResp.Body, file, startByte, totalSize)
 
-	if result.Error != nil && !result.ShouldRetry {
+	// Flush what was written and record how far we got, so the transfer can
+	// be resumed even if it was interrupted
+	if err := file.Sync(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to flush file: %v", err))
+	}
+	d.mutex.Lock()
+	if err := d.writeSidecarLocked(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to write resume state: %v", err))
+	}
+	d.mutex.Unlock()
+
+	if result.Error != nil {
 		return result.Error
 	}
 
@@ -465,28 +932,22 @@ func (d *Download) performDownload() error {
 			d.Progress = 100.0
 			d.mutex.Unlock()
 		}
-		logger.LogDownloadStatus(d.URL, "downloading", "completed", result.Downloaded, result.Downloaded)
-		return nil
-	}
-
-	if result.Downloaded > (result.TotalSize*95/100) && supportsRanges {
-		d.mutex.Lock()
-		d.Downloaded = result.Downloaded
-		d.mutex.Unlock()
 		return nil
 	}
 
-	return fmt.Errorf("download incomplete: got %d of %d bytes", result.Downloaded, result.TotalSize)
+	return fmt.Errorf("%w: got %d of %d bytes", ErrSizeMismatch, result.Downloaded, result.TotalSize)
 }
 
-// downloadChunks handles the actual data transfer
-func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, totalSize int64) DownloadResult {
+// downloadChunks handles the actual data transfer until the body ends or ctx is cancelled
+func (d *Download) downloadChunks(ctx context.Context, body io.Reader, file *os.File, startByte, totalSize int64) DownloadResult {
 	// Setup rate limiting if needed
 	var limiter *RateLimiter
-	if d.MaxBandwidth > 0 {
-		limiter = NewRateLimiter(d.MaxBandwidth * 1024) // Convert KB/s to bytes/s
-		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Applying bandwidth limit of %d KB/s", d.MaxBandwidth))
-	}
+	var currentLimit int64
+	defer func() {
+		if limiter != nil {
+			limiter.Stop()
+		}
+	}()
 
 	// Track progress
 	buffer := make([]byte, 32*1024)
@@ -497,53 +958,52 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 
 	// Start the download loop
 	for {
-		// Check if we should pause
-		select {
-		case <-d.pauseChan:
-			logger.LogDownloadStatus(d.URL, "downloading", "paused", downloaded, totalSize)
-			// Wait for resume signal
-			select {
-			case <-d.resumeChan:
-				logger.LogDownloadStatus(d.URL, "paused", "downloading", downloaded, totalSize)
-				startTime = time.Now()
-				lastUpdateTime = startTime
-				lastBytes = downloaded
-				continue
-			case <-d.cancelChan:
-				logger.LogDownloadStatus(d.URL, "paused", "cancelled", downloaded, totalSize)
-				return DownloadResult{
-					Completed:   false,
-					Downloaded:  downloaded,
-					TotalSize:   totalSize,
-					Error:       fmt.Errorf("download cancelled"),
-					ShouldRetry: false,
-				}
-			}
-
-		case <-d.cancelChan:
-			logger.LogDownloadStatus(d.URL, "downloading", "cancelled", downloaded, totalSize)
+		// Stop if the attempt was paused or cancelled
+		if err := ctx.Err(); err != nil {
 			return DownloadResult{
 				Completed:   false,
 				Downloaded:  downloaded,
 				TotalSize:   totalSize,
-				Error:       fmt.Errorf("download cancelled"),
+				Error:       err,
 				ShouldRetry: false,
 			}
+		}
 
-		default:
-			// Proceed with download
+		// Apply bandwidth limit changes (e.g. after moving to another queue)
+		d.mutex.Lock()
+		maxBandwidth := d.MaxBandwidth
+		shared := d.shared
+		d.mutex.Unlock()
+		if maxBandwidth != currentLimit {
+			if limiter != nil {
+				limiter.Stop()
+				limiter = nil
+			}
+			if maxBandwidth > 0 {
+				limiter = NewRateLimiter(maxBandwidth * 1024) // Convert KB/s to bytes/s
+				logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Applying bandwidth limit of %d KB/s", maxBandwidth))
+			}
+			currentLimit = maxBandwidth
 		}
 
 		// Read chunk
 		var n int
 		var err error
 		if limiter != nil {
-			n, err = limiter.Read(body, buffer)
+			n, err = limiter.Read(ctx, body, buffer)
 		} else {
 			n, err = body.Read(buffer)
 		}
+		if n > 0 && shared != nil {
+			if werr := shared.WaitToken(ctx, int64(n)); werr != nil {
+				err = werr
+			}
+		}
 
 		if err != nil && err != io.EOF {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
 			return DownloadResult{
 				Completed:   false,
 				Downloaded:  downloaded,
@@ -571,12 +1031,12 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 		downloaded += int64(n)
 
 		// Update progress
+		d.mutex.Lock()
+		d.Downloaded = downloaded
 		if totalSize > 0 {
-			d.mutex.Lock()
 			d.Progress = float64(downloaded) / float64(totalSize) * 100
-			d.Downloaded = downloaded
-			d.mutex.Unlock()
 		}
+		d.mutex.Unlock()
 
 		// Calculate speed and log progress
 		now := time.Now()
@@ -585,6 +1045,10 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 			bytesPerSecond := int64(float64(downloaded-lastBytes) / elapsed.Seconds())
 			d.mutex.Lock()
 			d.Speed = bytesPerSecond
+			d.Downloaded = downloaded
+			d.emitLocked(events.Progress, "")
+			// Keep the resume state current in case of a crash
+			d.writeSidecarLocked()
 			d.mutex.Unlock()
 
 			// Only calculate progress if we have a valid total size
@@ -613,11 +1077,12 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 // New creates a new download instance
 func New(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime time.Time) *Download {
 	download := &Download{
+		ID:                 NewID(),
 		URL:                url,
 		TargetPath:         targetPath,
 		Filename:           filepath.Base(targetPath),
 		Queue:              queue,
-		Status:             "pending",
+		Status:             StatusPending,
 		MaxBandwidth:       maxBandwidth,
 		MaxRetries:         3,
 		RetryDelay:         5 * time.Second,
@@ -630,6 +1095,6 @@ func New(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime t
 // StartDownload is a convenience function to create and start a download
 func StartDownload(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime time.Time) (*Download, error) {
 	download := New(url, targetPath, queue, maxBandwidth, scheduledStartTime)
-	go download.Start()
+	go download.Start(context.Background())
 	return download, nil
 }
@@ -0,0 +1,452 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSlowServer serves content in small chunks with a short delay between
+// them, so a transfer takes long enough to be paused and cancelled halfway.
+// It honours "bytes=N-" ranges like a real server.
+func newSlowServer(t *testing.T, content []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		start := 0
+		if spec := r.Header.Get("Range"); spec != "" {
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(spec, "bytes="), "-"))
+			if err != nil || n >= len(content) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			start = n
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+		if start > 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		for rest := content[start:]; len(rest) > 0; {
+			n := min(4096, len(rest))
+			if _, err := w.Write(rest[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			rest = rest[n:]
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+// newTestDownload returns a download of url into a temporary directory that
+// retries quickly
+func newTestDownload(t *testing.T, url string) *Download {
+	t.Helper()
+	d := New(url, filepath.Join(t.TempDir(), "file.bin"), "default", 0, time.Time{})
+	d.RetryDelay = 10 * time.Millisecond
+	return d
+}
+
+// start runs d.Start in the background and returns the channel its result
+// arrives on
+func start(ctx context.Context, d *Download) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- d.Start(ctx) }()
+	return done
+}
+
+// wait returns the result of Start or fails the test if it takes too long
+func wait(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(30 * time.Second):
+		t.Fatal("Start did not return")
+		return nil
+	}
+}
+
+// waitFor polls until cond holds or fails the test
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPauseResumeLoop(t *testing.T) {
+	content := testContent(512 * 1024)
+	srv := newSlowServer(t, content)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+	done := start(context.Background(), d)
+
+	// Readers race with the pause and resume calls below
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				d.Snapshot()
+				d.GetProgress()
+				d.IsRunning()
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		d.Pause()
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		d.Resume()
+	}
+	close(stop)
+	readers.Wait()
+
+	if err := wait(t, done); err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	if s := d.GetStatus(); s != StatusCompleted {
+		t.Fatalf("status = %s, want %s", s, StatusCompleted)
+	}
+	if got := d.GetRetryCount(); got != 0 {
+		t.Errorf("retry count = %d, want 0: pauses must not count as failed attempts", got)
+	}
+	got, err := os.ReadFile(d.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file has %d bytes that differ from the %d served", len(got), len(content))
+	}
+	if _, err := os.Stat(SidecarPath(d.TargetPath)); !os.IsNotExist(err) {
+		t.Errorf("sidecar left behind after completion: %v", err)
+	}
+}
+
+func TestCancelWhilePausingAndResuming(t *testing.T) {
+	srv := newSlowServer(t, testContent(1024*1024))
+
+	for i := 0; i < 20; i++ {
+		d := newTestDownload(t, srv.URL+"/file.bin")
+		done := start(context.Background(), d)
+		waitFor(t, "first bytes", func() bool { return d.Snapshot().Downloaded > 0 })
+
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					d.Pause()
+					d.Resume()
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			if err := d.Cancel(); err != nil {
+				t.Errorf("Cancel returned %v", err)
+			}
+		}()
+		wg.Wait()
+
+		if err := wait(t, done); !errors.Is(err, ErrCancelled) {
+			t.Fatalf("Start returned %v, want %v", err, ErrCancelled)
+		}
+		if s := d.GetStatus(); s != StatusCancelled {
+			t.Fatalf("status = %s, want %s", s, StatusCancelled)
+		}
+		if d.IsRunning() {
+			t.Fatal("download still running after Start returned")
+		}
+		if _, err := os.Stat(d.TargetPath); !os.IsNotExist(err) {
+			t.Errorf("partial file left behind after cancel: %v", err)
+		}
+		if _, err := os.Stat(SidecarPath(d.TargetPath)); !os.IsNotExist(err) {
+			t.Errorf("sidecar left behind after cancel: %v", err)
+		}
+	}
+}
+
+func TestCancelWhilePaused(t *testing.T) {
+	srv := newSlowServer(t, testContent(1024*1024))
+
+	for i := 0; i < 20; i++ {
+		d := newTestDownload(t, srv.URL+"/file.bin")
+		done := start(context.Background(), d)
+		waitFor(t, "first bytes", func() bool { return d.Snapshot().Downloaded > 0 })
+
+		d.Pause()
+		if s := d.GetStatus(); s != StatusPaused {
+			t.Fatalf("status after Pause = %s, want %s", s, StatusPaused)
+		}
+		if err := d.Cancel(); err != nil {
+			t.Fatalf("Cancel returned %v", err)
+		}
+		if err := wait(t, done); !errors.Is(err, ErrCancelled) {
+			t.Fatalf("Start returned %v, want %v", err, ErrCancelled)
+		}
+	}
+}
+
+func TestCancelDuringScheduledWait(t *testing.T) {
+	srv := newSlowServer(t, testContent(1024))
+	d := New(srv.URL+"/file.bin", filepath.Join(t.TempDir(), "file.bin"), "default", 0, time.Now().Add(time.Hour))
+	done := start(context.Background(), d)
+	waitFor(t, "scheduled status", func() bool { return d.GetStatus() == StatusScheduled && d.IsRunning() })
+
+	if err := d.Cancel(); err != nil {
+		t.Fatalf("Cancel returned %v", err)
+	}
+	if err := wait(t, done); !errors.Is(err, ErrCancelled) {
+		t.Fatalf("Start returned %v, want %v", err, ErrCancelled)
+	}
+}
+
+func TestContextEndLeavesDownloadPaused(t *testing.T) {
+	content := testContent(512 * 1024)
+	srv := newSlowServer(t, content)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := start(ctx, d)
+	waitFor(t, "first bytes", func() bool { return d.Snapshot().Downloaded > 0 })
+	cancel()
+
+	if err := wait(t, done); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Start returned %v, want %v", err, context.Canceled)
+	}
+	if s := d.GetStatus(); s != StatusPaused {
+		t.Fatalf("status = %s, want %s", s, StatusPaused)
+	}
+
+	// Starting again continues from the partial file
+	if err := wait(t, start(context.Background(), d)); err != nil {
+		t.Fatalf("second Start returned %v", err)
+	}
+	got, err := os.ReadFile(d.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("resumed file differs from the content served")
+	}
+}
+
+func TestChecksumMismatchDiscardsFile(t *testing.T) {
+	srv := newSlowServer(t, testContent(16*1024))
+	d := newTestDownload(t, srv.URL+"/file.bin")
+	d.Checksum = strings.Repeat("0", 64)
+
+	if err := wait(t, start(context.Background(), d)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Start returned %v, want %v", err, ErrChecksumMismatch)
+	}
+	if s := d.Snapshot(); s.Downloaded != 0 || s.Progress != 0 {
+		t.Errorf("discarded download has %d bytes, %.0f%% progress, want none", s.Downloaded, s.Progress)
+	}
+	if _, err := os.Stat(d.TargetPath); !os.IsNotExist(err) {
+		t.Errorf("file with the wrong checksum left behind: %v", err)
+	}
+	if _, err := os.Stat(SidecarPath(d.TargetPath)); !os.IsNotExist(err) {
+		t.Errorf("sidecar left behind: %v", err)
+	}
+}
+
+func TestOversizedFileStartsOver(t *testing.T) {
+	content := testContent(16 * 1024)
+	var mu sync.Mutex
+	gets := 0
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			return
+		}
+		mu.Lock()
+		gets++
+		first := gets == 1
+		if spec := r.Header.Get("Range"); spec != "" {
+			ranges = append(ranges, spec)
+		}
+		mu.Unlock()
+
+		// The first response carries more than the announced size
+		if first {
+			w.Write(append(append([]byte(nil), content...), content...))
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+
+	if err := wait(t, start(context.Background(), d)); err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) > 0 {
+		t.Errorf("retry after discarding the file asked for ranges %v, want the whole file", ranges)
+	}
+	got, err := os.ReadFile(d.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("file has %d bytes, want the %d served", len(got), len(content))
+	}
+}
+
+// newCuttingServer serves content, but the first cuts GET requests stall
+// for stall and then lose their connection before a response is sent
+func newCuttingServer(t *testing.T, content []byte, cuts int, stall time.Duration) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		mu.Lock()
+		cut := cuts > 0
+		cuts--
+		mu.Unlock()
+		if cut {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(stall):
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCutConnectionIsRetried(t *testing.T) {
+	content := testContent(16 * 1024)
+	srv := newCuttingServer(t, content, 2, 20*time.Millisecond)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+
+	if err := wait(t, start(context.Background(), d)); err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	// The transport itself retries a request whose reused connection is cut,
+	// so not every cut costs a retry
+	if got := d.GetRetryCount(); got < 1 || got > 2 {
+		t.Errorf("retry count = %d, want 1 or 2", got)
+	}
+	got, err := os.ReadFile(d.TargetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("file differs from the content served")
+	}
+}
+
+func TestTimeoutFailsAfterRetries(t *testing.T) {
+	srv := newCuttingServer(t, testContent(1024), 100, time.Second)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+	d.MaxRetries = 2
+	d.client = &http.Client{Transport: &http.Transport{ResponseHeaderTimeout: 20 * time.Millisecond}}
+
+	err := wait(t, start(context.Background(), d))
+	if ErrorKind(err) != KindTimeout {
+		t.Fatalf("Start returned %v, want a timeout", err)
+	}
+	if s := d.GetStatus(); s != StatusError {
+		t.Errorf("status = %s, want %s", s, StatusError)
+	}
+	if d.IsRunning() {
+		t.Error("download still running after Start returned")
+	}
+	if got := d.GetRetryCount(); got != 2 {
+		t.Errorf("retry count = %d, want 2", got)
+	}
+}
+
+func TestActiveTimeSkipsPauses(t *testing.T) {
+	content := testContent(256 * 1024)
+	srv := newSlowServer(t, content)
+	d := newTestDownload(t, srv.URL+"/file.bin")
+
+	begun := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := start(ctx, d)
+	waitFor(t, "first bytes", func() bool { return d.Snapshot().Downloaded > 0 })
+	first := d.Snapshot().StartTime
+	d.Pause()
+	const paused = 300 * time.Millisecond
+	time.Sleep(paused / 2)
+
+	// A run that ends while paused is started again later
+	cancel()
+	wait(t, done)
+	time.Sleep(paused / 2)
+	if err := wait(t, start(context.Background(), d)); err != nil {
+		t.Fatalf("second Start returned %v", err)
+	}
+	total := time.Since(begun)
+
+	s := d.Snapshot()
+	if !s.StartTime.Equal(first) {
+		t.Errorf("start time moved from %v to %v on the second run", first, s.StartTime)
+	}
+	// Stopping the transfer takes a moment, so allow for some of the pause
+	if s.ActiveTime <= 0 || s.ActiveTime > total-paused/2 {
+		t.Errorf("active time %v includes the %v pause (%v in total)", s.ActiveTime, paused, total)
+	}
+}
@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+)
+
+// ErrSizeMismatch is wrapped by the error of a download whose transfer
+// ended with a different size than the server announced
+var ErrSizeMismatch = errors.New("file size mismatch")
+
+// HTTPStatusError is returned when the server answers a download request
+// with a status other than 2xx
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("server responded with status: %s", e.Status)
+}
+
+// Kinds of errors reported by ErrorKind
+const (
+	KindChecksum   = "checksum"
+	KindHTTPStatus = "http_status"
+	KindTimeout    = "timeout"
+	KindNetwork    = "network"
+	KindIncomplete = "incomplete"
+	KindFilesystem = "filesystem"
+	KindOther      = "other"
+)
+
+// ErrorKind sorts the error of a download attempt into a few kinds, so
+// failures can be counted without a series per message. It returns "" for
+// a nil error.
+func ErrorKind(err error) string {
+	var statusErr *HTTPStatusError
+	var netErr net.Error
+	var pathErr *fs.PathError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrChecksumMismatch):
+		return KindChecksum
+	case errors.As(err, &statusErr):
+		return KindHTTPStatus
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return KindNetwork
+	case errors.Is(err, ErrSizeMismatch):
+		return KindIncomplete
+	case errors.As(err, &pathErr):
+		return KindFilesystem
+	}
+	return KindOther
+}
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorKind(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"checksum", fmt.Errorf("%w: expected a, got b", ErrChecksumMismatch), KindChecksum},
+		{"http status", &HTTPStatusError{StatusCode: 404, Status: "404 Not Found"}, KindHTTPStatus},
+		{"deadline", fmt.Errorf("failed: %w", context.DeadlineExceeded), KindTimeout},
+		{"net timeout", &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{}}, KindTimeout},
+		{"refused", fmt.Errorf("failed to send GET request: %w", &url.Error{Op: "Get", URL: "http://example.com", Err: refused}), KindNetwork},
+		{"unexpected EOF", fmt.Errorf("error reading from response: %w", io.ErrUnexpectedEOF), KindNetwork},
+		{"size mismatch", fmt.Errorf("%w: got 1 of 2 bytes", ErrSizeMismatch), KindIncomplete},
+		{"path", fmt.Errorf("error writing to file: %w", &os.PathError{Op: "write", Path: "/x", Err: errors.New("no space left on device")}), KindFilesystem},
+		{"after retries", fmt.Errorf("download failed after 3 retries: %w", &HTTPStatusError{StatusCode: 500, Status: "500 Internal Server Error"}), KindHTTPStatus},
+		// Messages don't decide the kind
+		{"message only", errors.New("server responded with status: 404 Not Found"), KindOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorKind(tt.err); got != tt.want {
+				t.Errorf("ErrorKind(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedEventCarriesKind(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	bus := events.NewBus()
+	sub := bus.Subscribe(64, events.DropNewest)
+	defer sub.Close()
+
+	d := newTestDownload(t, srv.URL+"/missing.bin")
+	d.MaxRetries = 1
+	d.SetEventBus(bus)
+	err := wait(t, start(context.Background(), d))
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Start returned %v, want an HTTPStatusError for 404", err)
+	}
+
+	kinds := map[events.Type]string{}
+	for len(sub.Events()) > 0 {
+		e := <-sub.Events()
+		if e.Type == events.Retrying || e.Type == events.Failed {
+			kinds[e.Type] = e.ErrorKind
+		}
+	}
+	for _, typ := range []events.Type{events.Retrying, events.Failed} {
+		if kinds[typ] != KindHTTPStatus {
+			t.Errorf("%s event has kind %q, want %q", typ, kinds[typ], KindHTTPStatus)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseHeader splits a request header given as "Name: value", like curl
+// and aria2 take them
+func ParseHeader(h string) (name, value string, err error) {
+	name, value, ok := strings.Cut(h, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+		return "", "", fmt.Errorf("header %q is not of the form \"Name: value\"", h)
+	}
+	return name, value, nil
+}
+
+// setHeaders adds the extra headers of the download to req, replacing the
+// defaults of the same name. The headers were checked when the download was
+// added, so any that don't parse are skipped.
+func (d *Download) setHeaders(req *http.Request) {
+	for _, h := range d.Headers {
+		if name, value, err := ParseHeader(h); err == nil {
+			req.Header.Set(name, value)
+		}
+	}
+}
@@ -1,92 +1,134 @@
 package downloader
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
 )
 
+// RateLimiter is a token bucket with one token per byte. It fills at
+// tokensPerSecond and holds up to one second's worth, so a transfer can
+// burst briefly after a pause but averages the configured rate. A limiter
+// may be shared by several transfers, which then share the rate.
 type RateLimiter struct {
-	tokensPerSecond int64
+	tokensPerSecond int64 // 0 while limiting is off
 	bucketSize      int64
 	currentTokens   int64
 	lastRefill      time.Time
 	mutex           sync.Mutex
-	tokenChan       chan struct{}
 	stopChan        chan struct{}
+	stopOnce        sync.Once
 }
 
+// NewRateLimiter creates a limiter for bytesPerSecond, which is off if that
+// is 0 or less
 func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
-	bucketSize := bytesPerSecond
-	limiter := &RateLimiter{
-		tokensPerSecond: bytesPerSecond,
-		bucketSize:      bucketSize,
-		currentTokens:   bucketSize,
-		lastRefill:      time.Now(),
-		tokenChan:       make(chan struct{}, 1000),
-		stopChan:        make(chan struct{}),
-	}
-	go limiter.generateTokens()
-	return limiter
+	r := &RateLimiter{stopChan: make(chan struct{})}
+	r.SetRate(bytesPerSecond)
+	return r
 }
 
-func (r *RateLimiter) generateTokens() {
-	ticker := time.NewTicker(time.Millisecond * 100)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-r.stopChan:
-			return
-		case <-ticker.C:
-			r.refillTokens()
-		}
+// SetRate changes the rate to bytesPerSecond, or turns limiting off if it is
+// 0 or less. The bucket starts full at the new rate.
+func (r *RateLimiter) SetRate(bytesPerSecond int64) {
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
 	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokensPerSecond = bytesPerSecond
+	r.bucketSize = bytesPerSecond
+	r.currentTokens = bytesPerSecond
+	r.lastRefill = time.Now()
 }
 
-func (r *RateLimiter) refillTokens() {
+// Rate returns the rate in bytes per second, 0 while limiting is off
+func (r *RateLimiter) Rate() int64 {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	return r.tokensPerSecond
+}
 
+// refillTokens adds the tokens generated since the last refill. The caller
+// must hold r.mutex.
+func (r *RateLimiter) refillTokens() {
 	now := time.Now()
-	elapsed := now.Sub(r.lastRefill).Seconds()
-	r.lastRefill = now
-
-	newTokens := int64(float64(r.tokensPerSecond) * elapsed)
-	if r.currentTokens+newTokens > r.bucketSize {
-		newTokens = r.bucketSize - r.currentTokens
+	newTokens := int64(float64(r.tokensPerSecond) * now.Sub(r.lastRefill).Seconds())
+	if newTokens <= 0 {
+		return
 	}
+	r.lastRefill = now
 	r.currentTokens += newTokens
-
-	for r.currentTokens > 0 {
-		select {
-		case r.tokenChan <- struct{}{}:
-			r.currentTokens--
-		default:
-			return
-		}
+	if r.currentTokens > r.bucketSize {
+		r.currentTokens = r.bucketSize
 	}
 }
 
 func (r *RateLimiter) GetToken(bytes int64) {
-	tokensNeeded := bytes
-	for tokensNeeded > 0 {
-		<-r.tokenChan
-		tokensNeeded--
+	r.WaitToken(context.Background(), bytes)
+}
+
+// WaitToken blocks until bytes tokens are available and takes them. It gives
+// up when ctx is done and returns right away once the limiter is stopped or
+// while limiting is off.
+func (r *RateLimiter) WaitToken(ctx context.Context, bytes int64) error {
+	for bytes > 0 {
+		r.mutex.Lock()
+		if r.tokensPerSecond <= 0 {
+			r.mutex.Unlock()
+			return nil
+		}
+
+		// Requests larger than the bucket are served in bucket-sized parts
+		take := bytes
+		if take > r.bucketSize {
+			take = r.bucketSize
+		}
+
+		r.refillTokens()
+		if r.currentTokens >= take {
+			r.currentTokens -= take
+			r.mutex.Unlock()
+			bytes -= take
+			continue
+		}
+		missing := take - r.currentTokens
+		wait := time.Duration(float64(missing) / float64(r.tokensPerSecond) * float64(time.Second))
+		r.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-r.stopChan:
+			timer.Stop()
+			return nil
+		}
 	}
+	return nil
 }
 
 // Read reads data from reader and applies rate limiting
 // It implements a rate-limited reader for the download process
-func (r *RateLimiter) Read(reader io.Reader, buffer []byte) (int, error) {
+func (r *RateLimiter) Read(ctx context.Context, reader io.Reader, buffer []byte) (int, error) {
 	// First read data from the original reader
 	n, err := reader.Read(buffer)
 	if n > 0 {
 		// If data was read, limit the rate by getting tokens
-		r.GetToken(int64(n))
+		if werr := r.WaitToken(ctx, int64(n)); werr != nil {
+			return n, werr
+		}
 	}
 	return n, err
 }
 
+// Stop releases everyone waiting for tokens
 func (r *RateLimiter) Stop() {
-	close(r.stopChan)
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+	})
 }
@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedLimiterSplitsRate(t *testing.T) {
+	const rate = 1 << 20
+	limiter := NewRateLimiter(rate)
+	defer limiter.Stop()
+
+	// Two transfers take 1 MiB each; the first second's worth is in the
+	// full bucket, the rest has to wait about a second
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for taken := 0; taken < rate; taken += 32 * 1024 {
+				if err := limiter.WaitToken(context.Background(), 32*1024); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 3*time.Second {
+		t.Fatalf("2 MiB at 1 MiB/s with a full bucket took %v, want about 1s", elapsed)
+	}
+}
+
+func TestLimiterOff(t *testing.T) {
+	limiter := NewRateLimiter(0)
+	defer limiter.Stop()
+	if limiter.Rate() != 0 {
+		t.Fatalf("rate = %d, want 0", limiter.Rate())
+	}
+	done := make(chan struct{})
+	go func() {
+		limiter.WaitToken(context.Background(), 1<<30)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WaitToken blocked while limiting is off")
+	}
+}
+
+// A transfer waiting for tokens when the limit is lifted stops waiting once
+// its current wait, at most the second the bucket holds, is over
+func TestSetRateOffEndsWaits(t *testing.T) {
+	limiter := NewRateLimiter(1024)
+	defer limiter.Stop()
+	limiter.WaitToken(context.Background(), 1024) // Empty the bucket
+
+	done := make(chan error, 1)
+	go func() { done <- limiter.WaitToken(context.Background(), 1<<20) }()
+	time.Sleep(50 * time.Millisecond)
+	limiter.SetRate(0)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("turning the limit off didn't end the wait of a transfer")
+	}
+}
+
+func TestWaitTokenStopsWithContext(t *testing.T) {
+	limiter := NewRateLimiter(1)
+	defer limiter.Stop()
+	limiter.WaitToken(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.WaitToken(ctx, 1<<20); err != context.DeadlineExceeded {
+		t.Fatalf("WaitToken returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
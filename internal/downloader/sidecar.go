@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// sidecarSuffix is appended to a download's target path to name the file
+// holding its resume state
+const sidecarSuffix = ".dmstate"
+
+// sidecar is the resume state kept next to a partial file, so it can be
+// picked up again after a restart or crash
+type sidecar struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	Downloaded     int64     `json:"downloaded"`
+	TotalSize      int64     `json:"total_size"`
+	SupportsRanges bool      `json:"supports_ranges"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SidecarPath returns the path of the resume state file for targetPath
+func SidecarPath(targetPath string) string {
+	return targetPath + sidecarSuffix
+}
+
+// readSidecar loads the resume state stored next to targetPath
+func readSidecar(targetPath string) (*sidecar, error) {
+	data, err := os.ReadFile(SidecarPath(targetPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var state sidecar
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse sidecar: %w", err)
+	}
+	return &state, nil
+}
+
+// writeSidecarLocked stores the resume state next to the target file. It
+// writes to a temporary file first so a crash never leaves a torn sidecar.
+// The caller must hold d.mutex.
+func (d *Download) writeSidecarLocked() error {
+	if d.TargetPath == "" || d.isCancelled {
+		return nil
+	}
+
+	data, err := json.Marshal(sidecar{
+		ID:             d.ID,
+		URL:            d.URL,
+		Downloaded:     d.Downloaded,
+		TotalSize:      d.TotalSize,
+		SupportsRanges: d.supportsRanges,
+		UpdatedAt:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	path := SidecarPath(d.TargetPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeSidecarLocked deletes the resume state once it is no longer needed.
+// The caller must hold d.mutex.
+func (d *Download) removeSidecarLocked() {
+	if d.TargetPath == "" {
+		return
+	}
+	os.Remove(SidecarPath(d.TargetPath))
+}
+
+// Checkpoint writes the download's resume state to its sidecar file
+func (d *Download) Checkpoint() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.writeSidecarLocked()
+}
+
+// Reconcile brings a download loaded from saved state in line with what is
+// on disk. Downloads that were in flight when the program stopped are reset
+// to pending so their queue restarts them, and progress is taken from the
+// partial file, since that is what a resumed transfer continues from, if
+// the download's sidecar shows the file is its own. It reports whether
+// anything changed.
+func (d *Download) Reconcile() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.running || d.Status.IsTerminal() {
+		return false
+	}
+
+	// A file at the target path is only ours to continue if our sidecar is
+	// next to it; anything else, like a file another download or program
+	// left there, is overwritten from the start
+	var size int64
+	if state, err := readSidecar(d.TargetPath); err == nil && state.ID == d.ID {
+		if info, err := os.Stat(d.TargetPath); err == nil {
+			size = info.Size()
+		}
+		// The sidecar may know the total size if the config was saved
+		// before the first response arrived
+		if d.TotalSize == 0 {
+			d.TotalSize = state.TotalSize
+		}
+		d.supportsRanges = state.SupportsRanges
+	}
+
+	if d.TotalSize > 0 && size > d.TotalSize {
+		// A file larger than the download can't be resumed; start over
+		os.Remove(d.TargetPath)
+		size = 0
+	}
+	if size == 0 {
+		d.removeSidecarLocked()
+	}
+
+	changed := d.Downloaded != size || d.Speed != 0
+	d.Downloaded = size
+	d.Speed = 0
+	if d.TotalSize > 0 {
+		d.Progress = float64(size) / float64(d.TotalSize) * 100
+	} else {
+		d.Progress = 0
+	}
+
+	if d.Status.IsActive() {
+		d.transitionLocked(StatusPending)
+		changed = true
+	}
+
+	if changed {
+		logger.LogDownloadEvent("RECOVER", fmt.Sprintf("Recovered download %s as %s with %d bytes on disk", d.URL, d.Status, size))
+	}
+	return changed
+}
+
+// AdoptPartial takes over the partial file that an interrupted download of
+// the same URL left at the target path, so Start continues it rather than
+// starting over. It reports whether there was such a file. It must be
+// called before Start.
+func (d *Download) AdoptPartial() bool {
+	state, err := readSidecar(d.TargetPath)
+	if err != nil || state.URL != d.URL {
+		return false
+	}
+	if _, err := os.Stat(d.TargetPath); err != nil {
+		return false
+	}
+
+	d.mutex.Lock()
+	d.ID = state.ID
+	d.mutex.Unlock()
+	d.Reconcile()
+	return true
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileTrustsOnlyOwnSidecar(t *testing.T) {
+	tests := []struct {
+		name      string
+		sidecarID string // ID in the sidecar next to the file, "" for none
+		want      int64
+	}{
+		{"no sidecar", "", 0},
+		{"sidecar of another download", "other", 0},
+		{"own sidecar", "own", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.bin")
+			if err := os.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.sidecarID != "" {
+				owner := New("http://example.com/file.bin", path, "default", 0, time.Time{})
+				owner.ID = tt.sidecarID
+				owner.TotalSize = 4000
+				if err := owner.Checkpoint(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := New("http://example.com/file.bin", path, "default", 0, time.Time{})
+			d.ID = "own"
+			d.Status = StatusDownloading
+			d.Downloaded = 500
+			d.Reconcile()
+
+			s := d.Snapshot()
+			if s.Downloaded != tt.want {
+				t.Errorf("Downloaded = %d, want %d", s.Downloaded, tt.want)
+			}
+			if s.Status != StatusPending {
+				t.Errorf("status = %s, want %s", s.Status, StatusPending)
+			}
+			if tt.want > 0 && s.TotalSize != 4000 {
+				t.Errorf("TotalSize = %d, want the sidecar's 4000", s.TotalSize)
+			}
+		})
+	}
+}
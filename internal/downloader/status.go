@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is the lifecycle state of a download
+type Status string
+
+const (
+	StatusPending        Status = "pending"         // Waiting for a free slot in its queue
+	StatusScheduled      Status = "scheduled"       // Started, waiting for its scheduled start time
+	StatusDownloading    Status = "downloading"     // Transferring data
+	StatusPaused         Status = "paused"          // Stopped by the user or the queue's time window
+	StatusVerifying      Status = "verifying"       // Transfer finished, checking the file
+	StatusPostProcessing Status = "post-processing" // Verified, running post-download steps
+	StatusCompleted      Status = "completed"
+	StatusError          Status = "error"
+	StatusCancelled      Status = "cancelled"
+)
+
+// maxHistory is the number of transitions kept per download
+const maxHistory = 50
+
+// transitions lists the statuses each status may change to
+var transitions = map[Status][]Status{
+	StatusPending:        {StatusScheduled, StatusDownloading, StatusPaused, StatusError, StatusCancelled},
+	StatusScheduled:      {StatusDownloading, StatusPending, StatusPaused, StatusCancelled},
+	StatusDownloading:    {StatusPaused, StatusVerifying, StatusCompleted, StatusError, StatusCancelled, StatusPending},
+	StatusPaused:         {StatusDownloading, StatusScheduled, StatusPending, StatusError, StatusCancelled},
+	StatusVerifying:      {StatusPostProcessing, StatusCompleted, StatusDownloading, StatusPending, StatusError, StatusCancelled},
+	StatusPostProcessing: {StatusCompleted, StatusPending, StatusError, StatusCancelled},
+	StatusCompleted:      {},
+	StatusError:          {StatusPending, StatusDownloading, StatusCancelled},
+	StatusCancelled:      {},
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a download may change from s to next
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether no further transitions are possible from s
+func (s Status) IsTerminal() bool {
+	return s.Valid() && len(transitions[s]) == 0
+}
+
+// IsActive reports whether a download in status s is using a transfer slot
+func (s Status) IsActive() bool {
+	switch s {
+	case StatusScheduled, StatusDownloading, StatusVerifying, StatusPostProcessing:
+		return true
+	}
+	return false
+}
+
+// Transition records a status change of a download
+type Transition struct {
+	From Status    `json:"from"`
+	To   Status    `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// TransitionError is returned when a download is asked to make a status
+// change the transition table doesn't allow
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid status transition from %s to %s", e.From, e.To)
+}
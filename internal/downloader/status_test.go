@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+var allStatuses = []Status{
+	StatusPending,
+	StatusScheduled,
+	StatusDownloading,
+	StatusPaused,
+	StatusVerifying,
+	StatusPostProcessing,
+	StatusCompleted,
+	StatusError,
+	StatusCancelled,
+}
+
+func TestTransitions(t *testing.T) {
+	// The transitions a download may make, written out separately from the
+	// table in status.go so a change to either is caught
+	allowed := map[Status][]Status{
+		StatusPending:        {StatusScheduled, StatusDownloading, StatusPaused, StatusError, StatusCancelled},
+		StatusScheduled:      {StatusDownloading, StatusPending, StatusPaused, StatusCancelled},
+		StatusDownloading:    {StatusPaused, StatusVerifying, StatusCompleted, StatusError, StatusCancelled, StatusPending},
+		StatusPaused:         {StatusDownloading, StatusScheduled, StatusPending, StatusError, StatusCancelled},
+		StatusVerifying:      {StatusPostProcessing, StatusCompleted, StatusDownloading, StatusPending, StatusError, StatusCancelled},
+		StatusPostProcessing: {StatusCompleted, StatusPending, StatusError, StatusCancelled},
+		StatusCompleted:      {},
+		StatusError:          {StatusPending, StatusDownloading, StatusCancelled},
+		StatusCancelled:      {},
+	}
+	if len(transitions) != len(allStatuses) {
+		t.Fatalf("transition table has %d statuses, want %d", len(transitions), len(allStatuses))
+	}
+
+	type transitionTest struct {
+		from, to Status
+		want     bool
+	}
+	var tests []transitionTest
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			tests = append(tests, transitionTest{from, to, want})
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Fatalf("CanTransitionTo = %v, want %v", got, tt.want)
+			}
+
+			d := &Download{Status: tt.from}
+			err := d.SetStatus(tt.to)
+			if !tt.want {
+				var transitionErr *TransitionError
+				if !errors.As(err, &transitionErr) || transitionErr.From != tt.from || transitionErr.To != tt.to {
+					t.Fatalf("SetStatus returned %v, want a TransitionError from %s to %s", err, tt.from, tt.to)
+				}
+				if d.Status != tt.from || len(d.History) != 0 {
+					t.Fatalf("rejected transition changed the download: status %s, %d history entries", d.Status, len(d.History))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetStatus returned %v", err)
+			}
+			if d.Status != tt.to {
+				t.Fatalf("status = %s, want %s", d.Status, tt.to)
+			}
+			if len(d.History) != 1 || d.History[0].From != tt.from || d.History[0].To != tt.to {
+				t.Fatalf("history = %+v, want one transition from %s to %s", d.History, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestStatusPredicates(t *testing.T) {
+	tests := []struct {
+		status             Status
+		terminal, isActive bool
+	}{
+		{StatusPending, false, false},
+		{StatusScheduled, false, true},
+		{StatusDownloading, false, true},
+		{StatusPaused, false, false},
+		{StatusVerifying, false, true},
+		{StatusPostProcessing, false, true},
+		{StatusCompleted, true, false},
+		{StatusError, false, false},
+		{StatusCancelled, true, false},
+		{Status("bogus"), false, false},
+		{Status(""), false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.status.IsTerminal(); got != tt.terminal {
+			t.Errorf("%q.IsTerminal() = %v, want %v", tt.status, got, tt.terminal)
+		}
+		if got := tt.status.IsActive(); got != tt.isActive {
+			t.Errorf("%q.IsActive() = %v, want %v", tt.status, got, tt.isActive)
+		}
+		if got, want := tt.status.Valid(), tt.status != "bogus" && tt.status != ""; got != want {
+			t.Errorf("%q.Valid() = %v, want %v", tt.status, got, want)
+		}
+	}
+}
+
+func TestUnknownStatusCantTransition(t *testing.T) {
+	for _, to := range allStatuses {
+		if Status("bogus").CanTransitionTo(to) {
+			t.Errorf("unknown status may change to %s", to)
+		}
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	d := &Download{Status: StatusPending}
+	for i := 0; i < maxHistory+10; i++ {
+		next := StatusPaused
+		if d.Status == StatusPaused {
+			next = StatusPending
+		}
+		if err := d.SetStatus(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.History) != maxHistory {
+		t.Fatalf("history has %d entries, want %d", len(d.History), maxHistory)
+	}
+	if last := d.History[len(d.History)-1]; last.To != d.Status {
+		t.Fatalf("last history entry goes to %s, want the current status %s", last.To, d.Status)
+	}
+}
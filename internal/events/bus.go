@@ -0,0 +1,237 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type identifies what happened to a download
+type Type string
+
+const (
+	Added     Type = "added"
+	Started   Type = "started"
+	Progress  Type = "progress"
+	Paused    Type = "paused"
+	Resumed   Type = "resumed"
+	Retrying  Type = "retrying"
+	Completed Type = "completed"
+	Failed    Type = "failed"
+	Cancelled Type = "cancelled"
+	Removed   Type = "removed"
+
+	// Events about the manager rather than a single download have no DownloadID
+	ConfigReloaded  Type = "config-reloaded"  // Settings changed on disk were applied
+	ConfigRejected  Type = "config-rejected"  // Settings changed on disk were invalid and ignored
+	ConfigUpdated   Type = "config-updated"   // Settings were changed through the manager
+	NetworkLost     Type = "network-lost"     // Active downloads were paused until the network is back
+	NetworkRestored Type = "network-restored" // Downloads paused for the network were resumed
+	Disconnected    Type = "disconnected"     // The daemon a client was attached to went away
+)
+
+// Event describes a change in a download's lifecycle
+type Event struct {
+	Seq        uint64    `json:"seq"` // Assigned by the bus, increases by one per published event
+	Type       Type      `json:"type"`
+	DownloadID string    `json:"download_id"`
+	URL        string    `json:"url"`
+	Queue      string    `json:"queue"`
+	Status     string    `json:"status"`
+	Downloaded int64     `json:"downloaded"`
+	TotalSize  int64     `json:"total_size"`
+	Speed      int64     `json:"speed"` // bytes per second
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"` // What sort of error Error is, e.g. "network"
+	Message    string    `json:"message,omitempty"`    // Human-readable details, e.g. what a reload changed
+	Time       time.Time `json:"time"`
+}
+
+// DropPolicy decides what happens when a subscriber's buffer is full
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being published
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room
+	DropOldest
+)
+
+// Bus fans published events out to any number of subscribers. Publishing
+// never blocks: slow subscribers lose events according to their DropPolicy.
+// A nil *Bus is valid and discards everything.
+type Bus struct {
+	mutex sync.Mutex
+	epoch int64 // When the bus was created, telling its sequence numbers from those of other runs
+	seq   uint64
+	subs  map[*Subscription]struct{}
+
+	// Recent events other than progress, oldest first, for SubscribeSince
+	retain    int
+	recent    []Event
+	forgotten uint64 // Seq of the newest event dropped from recent
+}
+
+// Subscription receives events from a Bus until it is closed
+type Subscription struct {
+	bus     *Bus
+	ch      chan Event
+	policy  DropPolicy
+	dropped uint64
+}
+
+// NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{epoch: time.Now().UnixNano(), subs: make(map[*Subscription]struct{})}
+}
+
+// Epoch identifies the bus's sequence numbers. Those of a bus with another
+// epoch, like the one of a daemon that has since restarted, count from 1
+// again and can't be compared.
+func (b *Bus) Epoch() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.epoch
+}
+
+// Subscribe registers a subscriber with room for buffer pending events
+func (b *Bus) Subscribe(buffer int, policy DropPolicy) *Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.subscribe(buffer, policy)
+}
+
+// subscribe registers a subscriber. The caller must hold the bus mutex.
+func (b *Bus) subscribe(buffer int, policy DropPolicy) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &Subscription{
+		bus:    b,
+		ch:     make(chan Event, buffer),
+		policy: policy,
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Retain makes the bus remember the last n events other than progress, so
+// subscribers that were away can catch up on them with SubscribeSince.
+// Progress isn't kept since only the latest of it matters.
+func (b *Bus) Retain(n int) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.retain = n
+	b.trim()
+}
+
+// trim drops the oldest retained events beyond the limit. The caller must
+// hold the bus mutex.
+func (b *Bus) trim() {
+	if excess := len(b.recent) - b.retain; excess > 0 {
+		b.forgotten = b.recent[excess-1].Seq
+		b.recent = append(b.recent[:0], b.recent[excess:]...)
+	}
+}
+
+// SubscribeSince is like Subscribe, but also returns the retained events
+// published after seq, so that together with the subscription nothing after
+// seq is missed. last is the Seq of the latest event published so far. ok is
+// false if events after seq are no longer retained, or seq is from another
+// epoch than the bus's, e.g. from a previous run; the subscriber must then
+// start over from the current state.
+func (b *Bus) SubscribeSince(epoch int64, seq uint64, buffer int, policy DropPolicy) (s *Subscription, missed []Event, last uint64, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s = b.subscribe(buffer, policy)
+	if epoch != b.epoch || seq > b.seq || seq < b.forgotten {
+		return s, nil, b.seq, false
+	}
+	for _, e := range b.recent {
+		if e.Seq > seq {
+			missed = append(missed, e)
+		}
+	}
+	return s, missed, b.seq, true
+}
+
+// Publish stamps the event with the next sequence number (and the current
+// time if unset) and delivers it to every subscriber
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if e.Type != Progress {
+		if b.retain > 0 {
+			b.recent = append(b.recent, e)
+			b.trim()
+		} else {
+			b.forgotten = e.Seq
+		}
+	}
+
+	for s := range b.subs {
+		s.deliver(e)
+	}
+}
+
+// deliver hands an event to the subscriber. The caller must hold the bus mutex.
+func (s *Subscription) deliver(e Event) {
+	for {
+		select {
+		case s.ch <- e:
+			return
+		default:
+		}
+
+		if s.policy == DropNewest {
+			s.dropped++
+			return
+		}
+
+		// Make room by discarding the oldest event, then try again
+		select {
+		case <-s.ch:
+			s.dropped++
+		default:
+		}
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns how many events were discarded because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	return s.dropped
+}
+
+// Close unsubscribes and closes the events channel
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,42 @@
+package events
+
+import "testing"
+
+func TestSubscribeSince(t *testing.T) {
+	b := NewBus()
+	b.Retain(2)
+	for i := 0; i < 4; i++ {
+		b.Publish(Event{Type: Added})
+	}
+
+	tests := []struct {
+		name   string
+		epoch  int64
+		seq    uint64
+		ok     bool
+		missed []uint64
+	}{
+		{"caught up", b.Epoch(), 4, true, nil},
+		{"missed retained events", b.Epoch(), 2, true, []uint64{3, 4}},
+		{"missed forgotten events", b.Epoch(), 1, false, nil},
+		{"from the future", b.Epoch(), 5, false, nil},
+		{"from another run", b.Epoch() - 1, 3, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, missed, last, ok := b.SubscribeSince(tt.epoch, tt.seq, 1, DropOldest)
+			defer s.Close()
+			if ok != tt.ok || last != 4 {
+				t.Fatalf("ok = %v, last = %d, want %v and 4", ok, last, tt.ok)
+			}
+			if len(missed) != len(tt.missed) {
+				t.Fatalf("missed %d events, want %v", len(missed), tt.missed)
+			}
+			for i, e := range missed {
+				if e.Seq != tt.missed[i] {
+					t.Errorf("missed[%d] has seq %d, want %d", i, e.Seq, tt.missed[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/fsutil"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// Outcomes of finished downloads
+const (
+	OutcomeCompleted = "completed"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// Entry describes one finished, failed or cancelled download
+type Entry struct {
+	DownloadID string        `json:"download_id"`
+	URL        string        `json:"url"`
+	Path       string        `json:"path"`
+	Queue      string        `json:"queue"`
+	Size       int64         `json:"size"`               // Bytes on disk when the download ended
+	Duration   time.Duration `json:"duration"`           // Time spent downloading, over all runs
+	AvgSpeed   int64         `json:"avg_speed"`          // Bytes per second
+	Checksum   string        `json:"checksum,omitempty"` // SHA-256 of completed files
+	Outcome    string        `json:"outcome"`
+	Error      string        `json:"error,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+}
+
+// Retention limits how much history is kept. Zero values mean no limit.
+type Retention struct {
+	MaxAge     time.Duration
+	MaxEntries int
+}
+
+// Filter selects history entries. Empty fields match everything.
+type Filter struct {
+	Query string    // Case-insensitive text matched against URL, path, queue, outcome and error
+	From  time.Time // Only entries finished at or after From
+	To    time.Time // Only entries finished before To
+}
+
+// Stats summarizes a set of history entries
+type Stats struct {
+	Count      int
+	Completed  int
+	Failed     int
+	Cancelled  int
+	TotalBytes int64 // Bytes of completed downloads
+	AvgSpeed   int64 // Average speed of completed downloads in bytes per second
+}
+
+// Store keeps the download history in a JSON Lines file, one entry per line
+type Store struct {
+	path      string
+	retention Retention
+	entries   []Entry // Oldest first
+	mutex     sync.Mutex
+}
+
+// Open loads the history at path and applies the retention limits
+func Open(path string, retention Retention) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path, retention: retention}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.pruneLocked(time.Now()) {
+		if err := s.rewriteLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads all entries from disk, skipping lines that don't parse
+func (s *Store) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logger.LogDownloadError("HISTORY", "", fmt.Sprintf("Skipping unreadable history entry: %v", err))
+			continue
+		}
+		s.entries = append(s.entries, e)
+	}
+	return scanner.Err()
+}
+
+// SetRetention changes the retention limits and prunes the history accordingly
+func (s *Store) SetRetention(retention Retention) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.retention = retention
+	if s.pruneLocked(time.Now()) {
+		return s.rewriteLocked()
+	}
+	return nil
+}
+
+// Record appends an entry to the history
+func (s *Store) Record(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = append(s.entries, e)
+	if s.pruneLocked(time.Now()) {
+		return s.rewriteLocked()
+	}
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// pruneLocked drops entries beyond the retention limits and reports whether
+// any were dropped. The caller must hold s.mutex.
+func (s *Store) pruneLocked(now time.Time) bool {
+	keep := s.entries
+	if s.retention.MaxAge > 0 {
+		cutoff := now.Add(-s.retention.MaxAge)
+		i := 0
+		for i < len(keep) && keep[i].FinishedAt.Before(cutoff) {
+			i++
+		}
+		keep = keep[i:]
+	}
+	if s.retention.MaxEntries > 0 && len(keep) > s.retention.MaxEntries {
+		keep = keep[len(keep)-s.retention.MaxEntries:]
+	}
+
+	if len(keep) == len(s.entries) {
+		return false
+	}
+	s.entries = append([]Entry(nil), keep...)
+	return true
+}
+
+// rewriteLocked replaces the file with the entries in memory. The caller must hold s.mutex.
+func (s *Store) rewriteLocked() error {
+	var buf bytes.Buffer
+	for _, e := range s.entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return fsutil.WriteFileAtomic(s.path, buf.Bytes(), 0644)
+}
+
+// Entries returns the entries matching filter, newest first
+func (s *Store) Entries(filter Filter) []Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+	var result []Entry
+	for _, e := range s.entries {
+		if !filter.From.IsZero() && e.FinishedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !e.FinishedAt.Before(filter.To) {
+			continue
+		}
+		if query != "" && !e.matches(query) {
+			continue
+		}
+		result = append(result, e)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].FinishedAt.After(result[j].FinishedAt)
+	})
+	return result
+}
+
+// matches reports whether the lowercase query occurs in any of the entry's text fields
+func (e Entry) matches(query string) bool {
+	for _, field := range []string{e.URL, e.Path, e.Queue, e.Outcome, e.Error} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarize computes statistics over entries
+func Summarize(entries []Entry) Stats {
+	var stats Stats
+	var totalDuration time.Duration
+	for _, e := range entries {
+		stats.Count++
+		switch e.Outcome {
+		case OutcomeCompleted:
+			stats.Completed++
+			stats.TotalBytes += e.Size
+			totalDuration += e.Duration
+		case OutcomeFailed:
+			stats.Failed++
+		case OutcomeCancelled:
+			stats.Cancelled++
+		}
+	}
+	if totalDuration > 0 {
+		stats.AvgSpeed = int64(float64(stats.TotalBytes) / totalDuration.Seconds())
+	}
+	return stats
+}
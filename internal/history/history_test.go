@@ -0,0 +1,221 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// open opens the history at path or fails the test
+func open(t *testing.T, path string, retention Retention) *Store {
+	t.Helper()
+	s, err := Open(path, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// entry returns an entry for url that finished at finished
+func entry(url, outcome string, finished time.Time) Entry {
+	return Entry{
+		DownloadID: url,
+		URL:        "http://example.com/" + url,
+		Path:       "/tmp/" + url,
+		Queue:      "default",
+		Outcome:    outcome,
+		StartedAt:  finished.Add(-time.Minute),
+		FinishedAt: finished,
+	}
+}
+
+func record(t *testing.T, s *Store, entries ...Entry) {
+	t.Helper()
+	for _, e := range entries {
+		if err := s.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ids returns the download IDs of entries, in order
+func ids(entries []Entry) string {
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.DownloadID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func expectEntries(t *testing.T, s *Store, want string) {
+	t.Helper()
+	if got := ids(s.Entries(Filter{})); got != want {
+		t.Fatalf("entries %q, want %q", got, want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "history.jsonl")
+	s := open(t, path, Retention{})
+	now := time.Now().Truncate(time.Second)
+	first := entry("a", OutcomeCompleted, now.Add(-time.Hour))
+	first.Size = 1024
+	first.Duration = 2 * time.Second
+	first.AvgSpeed = 512
+	first.Checksum = "abc"
+	failed := entry("b", OutcomeFailed, now)
+	failed.Error = "server responded with status: 404 Not Found"
+	record(t, s, first, failed)
+
+	// The entries survive a reopen, newest first
+	s = open(t, path, Retention{})
+	got := s.Entries(Filter{})
+	if ids(got) != "b a" {
+		t.Fatalf("entries %q, want %q", ids(got), "b a")
+	}
+	a := got[1]
+	if a.Size != 1024 || a.Duration != 2*time.Second || a.AvgSpeed != 512 || a.Checksum != "abc" || !a.FinishedAt.Equal(first.FinishedAt) {
+		t.Errorf("reloaded entry = %+v, want %+v", a, first)
+	}
+	if got[0].Error != failed.Error {
+		t.Errorf("reloaded error = %q, want %q", got[0].Error, failed.Error)
+	}
+}
+
+func TestUnreadableLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := open(t, path, Retention{})
+	record(t, s, entry("a", OutcomeCompleted, time.Now()))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+
+	s = open(t, path, Retention{})
+	record(t, s, entry("b", OutcomeCompleted, time.Now()))
+	expectEntries(t, open(t, path, Retention{}), "b a")
+}
+
+func TestEntriesFilter(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "history.jsonl"), Retention{})
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	iso := entry("ubuntu.iso", OutcomeCompleted, day.Add(1*time.Hour))
+	missing := entry("missing.bin", OutcomeFailed, day.Add(2*time.Hour))
+	missing.Error = "404 Not Found"
+	video := entry("video.mp4", OutcomeCancelled, day.Add(26*time.Hour))
+	video.Queue = "Night"
+	record(t, s, iso, missing, video)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"everything", Filter{}, "video.mp4 missing.bin ubuntu.iso"},
+		{"url", Filter{Query: "ISO"}, "ubuntu.iso"},
+		{"error", Filter{Query: "not found"}, "missing.bin"},
+		{"queue", Filter{Query: "night"}, "video.mp4"},
+		{"outcome", Filter{Query: "cancelled"}, "video.mp4"},
+		{"surrounding spaces", Filter{Query: "  mp4 "}, "video.mp4"},
+		{"no match", Filter{Query: "nothing"}, ""},
+		{"from", Filter{From: day.Add(2 * time.Hour)}, "video.mp4 missing.bin"},
+		{"to is exclusive", Filter{To: day.Add(2 * time.Hour)}, "ubuntu.iso"},
+		{"one day", Filter{From: day, To: day.Add(24 * time.Hour)}, "missing.bin ubuntu.iso"},
+		{"day and query", Filter{Query: "example.com", From: day, To: day.Add(24 * time.Hour)}, "missing.bin ubuntu.iso"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(s.Entries(tt.filter)); got != tt.want {
+				t.Errorf("Entries(%+v) = %q, want %q", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Now()
+	old := entry("old", OutcomeCompleted, now.Add(-10*24*time.Hour))
+	recent := func(i int) Entry {
+		return entry(fmt.Sprint(i), OutcomeCompleted, now.Add(time.Duration(i-10)*time.Minute))
+	}
+
+	t.Run("max age on open", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		record(t, open(t, path, Retention{}), old, recent(1), recent(2))
+
+		open(t, path, Retention{MaxAge: 7 * 24 * time.Hour})
+		// The pruned entries are gone from the file too
+		expectEntries(t, open(t, path, Retention{}), "2 1")
+	})
+
+	t.Run("max entries on open", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		record(t, open(t, path, Retention{}), old, recent(1), recent(2))
+
+		open(t, path, Retention{MaxEntries: 1})
+		expectEntries(t, open(t, path, Retention{}), "2")
+	})
+
+	t.Run("max entries on record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		s := open(t, path, Retention{MaxEntries: 2})
+		record(t, s, recent(1), recent(2), recent(3))
+		expectEntries(t, s, "3 2")
+		expectEntries(t, open(t, path, Retention{}), "3 2")
+	})
+
+	t.Run("max age on record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		s := open(t, path, Retention{MaxAge: 24 * time.Hour})
+		record(t, s, old)
+		expectEntries(t, s, "")
+		record(t, s, recent(1))
+		expectEntries(t, open(t, path, Retention{}), "1")
+	})
+
+	t.Run("set retention", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.jsonl")
+		s := open(t, path, Retention{})
+		record(t, s, old, recent(1), recent(2), recent(3))
+
+		if err := s.SetRetention(Retention{MaxAge: 24 * time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+		expectEntries(t, s, "3 2 1")
+		if err := s.SetRetention(Retention{MaxAge: 24 * time.Hour, MaxEntries: 2}); err != nil {
+			t.Fatal(err)
+		}
+		expectEntries(t, s, "3 2")
+		expectEntries(t, open(t, path, Retention{}), "3 2")
+
+		// Lifting the limits doesn't bring entries back
+		s.SetRetention(Retention{})
+		expectEntries(t, s, "3 2")
+	})
+}
+
+func TestSummarize(t *testing.T) {
+	now := time.Now()
+	a := entry("a", OutcomeCompleted, now)
+	a.Size, a.Duration = 3000, time.Second
+	b := entry("b", OutcomeCompleted, now)
+	b.Size, b.Duration = 1000, time.Second
+	failed := entry("c", OutcomeFailed, now)
+	failed.Size, failed.Duration = 5000, time.Second
+	cancelled := entry("d", OutcomeCancelled, now)
+
+	stats := Summarize([]Entry{a, b, failed, cancelled})
+	want := Stats{Count: 4, Completed: 2, Failed: 1, Cancelled: 1, TotalBytes: 4000, AvgSpeed: 2000}
+	if stats != want {
+		t.Errorf("Summarize = %+v, want %+v", stats, want)
+	}
+	if stats := Summarize(nil); stats != (Stats{}) {
+		t.Errorf("Summarize(nil) = %+v, want zero", stats)
+	}
+}
@@ -0,0 +1,166 @@
+// Package metrics writes metrics in the Prometheus text exposition format,
+// without depending on the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Kind is the type of a metric family
+type Kind string
+
+const (
+	Counter Kind = "counter"
+	Gauge   Kind = "gauge"
+)
+
+// Writer writes metric families and their samples. The first error is kept
+// and every write after it is skipped.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter creates a writer to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Family starts a metric family. Its samples must follow before the next one.
+func (w *Writer) Family(name, help string, kind Kind) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+}
+
+// Sample writes a sample of the current family. labels are pairs of names
+// and values.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	w.printf("%s %s\n", b.String(), formatValue(value))
+}
+
+// Err returns the first error that occurred while writing
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+// formatValue formats a sample value the way the text format spells them
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	case v == math.Trunc(v) && math.Abs(v) < 1<<53:
+		// Byte counts read better without an exponent
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// CounterVec is a family of counters that differ in their label values. It
+// is safe for concurrent use.
+type CounterVec struct {
+	labels []string
+
+	mutex  sync.Mutex
+	values map[string]*counter // By label values joined with labelSeparator
+}
+
+type counter struct {
+	labels []string
+	value  float64
+}
+
+// labelSeparator can't appear in valid UTF-8, so it can't be part of a value
+const labelSeparator = "\xff"
+
+// NewCounterVec creates counters with the given label names
+func NewCounterVec(labels ...string) *CounterVec {
+	return &CounterVec{labels: labels, values: make(map[string]*counter)}
+}
+
+// Add adds v, which must not be negative, to the counter with the given
+// label values, one for each label name
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 || len(values) != len(c.labels) {
+		return
+	}
+	key := strings.Join(values, labelSeparator)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ctr, ok := c.values[key]
+	if !ok {
+		ctr = &counter{labels: append([]string(nil), values...)}
+		c.values[key] = ctr
+	}
+	ctr.value += v
+}
+
+// Write writes the family and its counters, ordered by label values
+func (c *CounterVec) Write(w *Writer, name, help string) {
+	c.mutex.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counters := make([]counter, 0, len(keys))
+	for _, key := range keys {
+		counters = append(counters, *c.values[key])
+	}
+	c.mutex.Unlock()
+
+	w.Family(name, help, Counter)
+	for _, ctr := range counters {
+		pairs := make([]string, 0, 2*len(c.labels))
+		for i, label := range c.labels {
+			pairs = append(pairs, label, ctr.labels[i])
+		}
+		w.Sample(name, ctr.value, pairs...)
+	}
+}
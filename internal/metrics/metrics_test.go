@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriterGolden(t *testing.T) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Family("dm_up", "Whether it runs.", Gauge)
+	w.Sample("dm_up", 1)
+	w.Family("dm_bytes_total", "Bytes read\nfrom a \\ path.", Counter)
+	w.Sample("dm_bytes_total", 1<<40, "queue", "default", "host", "example.com")
+	w.Sample("dm_bytes_total", 0.5, "queue", `say "hi"`, "host", "back\\slash\nnewline")
+	w.Family("dm_ratio", "Odd values.", Gauge)
+	w.Sample("dm_ratio", math.Inf(1), "v", "inf")
+	w.Sample("dm_ratio", math.Inf(-1), "v", "-inf")
+	w.Sample("dm_ratio", math.NaN(), "v", "nan")
+	w.Sample("dm_ratio", -3, "v", "negative")
+	w.Sample("dm_ratio", 1e300, "v", "huge")
+
+	want := `# HELP dm_up Whether it runs.
+# TYPE dm_up gauge
+dm_up 1
+# HELP dm_bytes_total Bytes read\nfrom a \\ path.
+# TYPE dm_bytes_total counter
+dm_bytes_total{queue="default",host="example.com"} 1099511627776
+dm_bytes_total{queue="say \"hi\"",host="back\\slash\nnewline"} 0.5
+# HELP dm_ratio Odd values.
+# TYPE dm_ratio gauge
+dm_ratio{v="inf"} +Inf
+dm_ratio{v="-inf"} -Inf
+dm_ratio{v="nan"} NaN
+dm_ratio{v="negative"} -3
+dm_ratio{v="huge"} 1e+300
+`
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecGolden(t *testing.T) {
+	c := NewCounterVec("queue", "host")
+	c.Add(10, "work", "b.example.com")
+	c.Add(5, "default", "z.example.com")
+	c.Add(1, "work", "a.example.com")
+	c.Add(2, "default", "z.example.com")
+	c.Add(-4, "default", "z.example.com") // Counters don't go down
+	c.Add(7, "missing host")              // Wrong number of values
+
+	var b strings.Builder
+	c.Write(NewWriter(&b), "dm_bytes_total", "Bytes downloaded.")
+	want := `# HELP dm_bytes_total Bytes downloaded.
+# TYPE dm_bytes_total counter
+dm_bytes_total{queue="default",host="z.example.com"} 7
+dm_bytes_total{queue="work",host="a.example.com"} 1
+dm_bytes_total{queue="work",host="b.example.com"} 10
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmptyCounterVecWritesFamily(t *testing.T) {
+	var b strings.Builder
+	NewCounterVec("kind").Write(NewWriter(&b), "dm_errors_total", "Errors.")
+	want := "# HELP dm_errors_total Errors.\n# TYPE dm_errors_total counter\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type failingWriter struct{ writes int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriterKeepsFirstError(t *testing.T) {
+	f := &failingWriter{}
+	w := NewWriter(f)
+	w.Family("dm_up", "Up.", Gauge)
+	w.Sample("dm_up", 1)
+	if w.Err() == nil {
+		t.Fatal("Err() = nil after a failed write")
+	}
+	if f.writes != 1 {
+		t.Errorf("%d writes, want none after the first failed", f.writes)
+	}
+}
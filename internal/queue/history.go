@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/fsutil"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// History returns the store of finished downloads, or nil if none is kept
+func (m *Manager) History() *history.Store {
+	return m.history
+}
+
+// HistoryEntries returns the finished downloads matching filter, newest
+// first, or nothing if no history is kept
+func (m *Manager) HistoryEntries(filter history.Filter) []history.Entry {
+	if m.history == nil {
+		return nil
+	}
+	return m.history.Entries(filter)
+}
+
+// recordHistory adds the final state of a download to the history. Completed
+// files are checksummed, so avoid calling it with m.mutex held for those.
+func (m *Manager) recordHistory(s downloader.Snapshot, outcome string) {
+	if m.history == nil {
+		return
+	}
+
+	finished := time.Now()
+	if outcome == history.OutcomeCompleted && !s.CompletionTime.IsZero() {
+		finished = s.CompletionTime
+	}
+	entry := history.Entry{
+		DownloadID: s.ID,
+		URL:        s.URL,
+		Path:       s.TargetPath,
+		Queue:      s.Queue,
+		Size:       s.Downloaded,
+		Outcome:    outcome,
+		StartedAt:  s.StartTime,
+		FinishedAt: finished,
+	}
+	// Time spent paused or waiting for a retry doesn't count. Downloads
+	// saved before active time was kept only have their start time.
+	entry.Duration = s.ActiveTime
+	if entry.Duration == 0 && !s.StartTime.IsZero() {
+		entry.Duration = finished.Sub(s.StartTime)
+	}
+	if seconds := entry.Duration.Seconds(); seconds > 0 {
+		entry.AvgSpeed = int64(float64(s.Downloaded) / seconds)
+	}
+	if outcome == history.OutcomeFailed {
+		entry.Error = s.Error
+	}
+	if outcome == history.OutcomeCompleted {
+		checksum, err := fsutil.SHA256(s.TargetPath)
+		if err != nil {
+			logger.LogDownloadError(s.URL, s.Queue, fmt.Sprintf("Failed to checksum download: %v", err))
+		}
+		entry.Checksum = checksum
+	}
+
+	if err := m.history.Record(entry); err != nil {
+		logger.LogDownloadError(s.URL, s.Queue, fmt.Sprintf("Failed to record download history: %v", err))
+	}
+}
+
+// cancelDownload cancels a download and records the cancellation in the
+// history. The caller must hold m.mutex.
+func (m *Manager) cancelDownload(d *downloader.Download) error {
+	wasCancelled := d.GetStatus() == downloader.StatusCancelled
+	err := d.Cancel()
+	if !wasCancelled && d.GetStatus() == downloader.StatusCancelled {
+		m.recordHistory(d.Snapshot(), history.OutcomeCancelled)
+	}
+	return err
+}
+
+// Redownload adds the URL of a history entry again, saving it to the same
+// path in the same queue. If that queue no longer exists the default queue
+// is used. It returns the ID of the new download.
+func (m *Manager) Redownload(e history.Entry) (string, error) {
+	m.mutex.Lock()
+	queueName := e.Queue
+	q := m.config.GetQueue(queueName)
+	if q == nil {
+		queueName = m.config.DefaultQueue
+		q = m.config.GetQueue(queueName)
+	}
+	var maxBandwidth int64
+	if q != nil {
+		maxBandwidth = q.SpeedLimit
+	}
+	targetPath := e.Path
+	if targetPath == "" {
+		targetPath = filepath.Join(m.config.SavePath, filepath.Base(e.URL))
+	}
+	m.mutex.Unlock()
+
+	d := downloader.New(e.URL, targetPath, queueName, maxBandwidth, time.Time{})
+	if err := m.AddDownload(d); err != nil {
+		return "", err
+	}
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Re-downloading %s from history", e.URL))
+	return d.ID, nil
+}
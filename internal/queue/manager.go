@@ -1,8 +1,10 @@
 package queue
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -11,48 +13,170 @@ import (
 
 	"github.com/mahdiXak47/Download-Manager/internal/config"
 	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
 )
 
+// ErrShuttingDown is returned for work submitted after Shutdown has begun
+var ErrShuttingDown = errors.New("queue manager is shutting down")
+
+// ErrInvalidURL is wrapped by errors about URLs that can't be downloaded as
+// requested, including with malformed request headers or a file name that
+// would leave the directory
+var ErrInvalidURL = errors.New("invalid URL")
+
+// ErrNotFound is wrapped by errors about downloads and queues that don't exist
+var ErrNotFound = errors.New("not found")
+
+// Manager owns all download state. Downloads are only touched while holding
+// mutex; everyone else reads them through Snapshot. Their state is persisted
+// in the download store whenever it changes. Until Start is called, the
+// manager only edits the state and never starts a transfer, which is how the
+// command line changes downloads.
 type Manager struct {
 	config     *config.Config
+	db         *store.Store
+	history    *history.Store                  // Finished downloads, nil to keep no history
+	all        []*downloader.Download          // All downloads, in the order they were added
 	activeJobs map[string]int                  // queue name -> active download count
-	downloads  map[string]*downloader.Download // URL -> Download for quick lookup
+	slots      map[string]string               // download ID -> queue whose slot it holds
+	downloads  map[string]*downloader.Download // ID -> Download for quick lookup
+	events     *events.Bus                     // Lifecycle events of all downloads
+	limiter    *downloader.RateLimiter         // Global speed limit shared by all downloads
+	ctx        context.Context                 // Parent of all running downloads, cancelled by Stop
+	cancel     context.CancelFunc
+	started    bool           // Set by Start; transfers may run
+	closing    bool           // Set by Shutdown; no new work is accepted
+	running    sync.WaitGroup // Goroutines started by startDownload
 	mutex      sync.Mutex
 	ticker     *time.Ticker
 }
 
-func NewManager(cfg *config.Config) *Manager {
+// NewManager creates a manager for the downloads in db. The manager takes
+// ownership of db and closes it on Shutdown. Finished, failed and cancelled
+// downloads are recorded in hist unless it is nil.
+func NewManager(cfg *config.Config, db *store.Store, hist *history.Store) (*Manager, error) {
+	downloads, err := db.Downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	m := &Manager{
+		ctx:        ctx,
+		cancel:     cancel,
 		config:     cfg,
+		db:         db,
+		history:    hist,
+		all:        downloads,
 		activeJobs: make(map[string]int),
+		slots:      make(map[string]string),
 		downloads:  make(map[string]*downloader.Download),
+		events:     events.NewBus(),
+		limiter:    downloader.NewRateLimiter(cfg.Limits.SpeedLimit * 1024),
 		ticker:     time.NewTicker(10 * time.Second),
 	}
 
-	// Initialize existing downloads
-	for i := range cfg.Downloads {
-		d := &cfg.Downloads[i]
-		m.downloads[d.URL] = d
-		if d.Status == "downloading" {
-			m.activeJobs[d.Queue]++
+	// Initialize existing downloads. Nothing is running yet, so downloads
+	// that were active when the program last stopped are reconciled with
+	// their files on disk and no slots are taken; the queues restart them.
+	recovered := 0
+	for _, d := range m.all {
+		d.Initialize()
+		d.SetEventBus(m.events)
+		d.SetSharedLimiter(m.limiter)
+		m.downloads[d.ID] = d
+		if d.Reconcile() {
+			m.persist(d)
+			recovered++
 		}
 	}
+	if recovered > 0 {
+		logger.LogDownloadEvent("SYSTEM", fmt.Sprintf("Recovered %d downloads from saved state", recovered))
+	}
 
-	logger.LogDownloadEvent("SYSTEM", fmt.Sprintf("Queue Manager initialized with %d downloads", len(cfg.Downloads)))
-	return m
+	logger.LogDownloadEvent("SYSTEM", fmt.Sprintf("Queue Manager initialized with %d downloads", len(m.all)))
+	return m, nil
 }
 
 // Start begins the queue manager's operation
 func (m *Manager) Start() {
+	m.mutex.Lock()
+	m.started = true
+	m.mutex.Unlock()
+
 	logger.LogDownloadEvent("SYSTEM", "Queue Manager started")
 	go m.run()
 }
 
-// Stop stops the queue manager
+// Stop stops the queue manager and interrupts all running downloads, which
+// are left paused
 func (m *Manager) Stop() {
 	logger.LogDownloadEvent("SYSTEM", "Queue Manager stopped")
 	m.ticker.Stop()
+	m.cancel()
+}
+
+// Shutdown stops accepting work, pauses every active transfer and waits for
+// them to flush their files and resume state, then saves the download store.
+// If ctx ends before all transfers have stopped, the state is saved anyway
+// and ctx's error is returned.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.closing {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.closing = true
+	m.mutex.Unlock()
+
+	logger.LogDownloadEvent("SYSTEM", "Queue Manager shutting down")
+	m.Stop()
+
+	// Running downloads pause themselves once their context is cancelled
+	stopped := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(stopped)
+	}()
+
+	var waitErr error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+		logger.LogDownloadError("SYSTEM", "", fmt.Sprintf("Timed out waiting for downloads to stop: %v", waitErr))
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Record the final state of every download, and the resume state of
+	// everything that didn't finish
+	errs := []error{waitErr}
+	for _, d := range m.all {
+		if s := d.Snapshot(); !s.Status.IsTerminal() && s.Downloaded > 0 {
+			if err := d.Checkpoint(); err != nil {
+				logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Failed to write resume state: %v", err))
+			}
+		}
+		if err := m.db.Put(d); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save download %s: %w", d.ID, err))
+		}
+	}
+	if err := m.db.Compact(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.db.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// The settings were saved whenever they changed. Saving them again could
+	// overwrite edits made to the file by hand that were rejected on reload.
+	logger.LogDownloadEvent("SYSTEM", "Queue Manager shut down")
+	return errors.Join(errs...)
 }
 
 // run is the main loop that processes downloads
@@ -61,79 +185,324 @@ func (m *Manager) run() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.processQueues()
+	// Pick up recovered and pending downloads right away
+	m.processQueues()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.processQueues()
+		}
 	}
 }
 
-// PauseDownload pauses a specific download
-func (m *Manager) PauseDownload(url string) {
+// Events returns the bus on which lifecycle events of all downloads are published
+func (m *Manager) Events() *events.Bus {
+	return m.events
+}
+
+// publish emits a manager-level event for a download
+func (m *Manager) publish(t events.Type, d *downloader.Download) {
+	s := d.Snapshot()
+	m.events.Publish(events.Event{
+		Type:       t,
+		DownloadID: s.ID,
+		URL:        s.URL,
+		Queue:      s.Queue,
+		Status:     string(s.Status),
+		Downloaded: s.Downloaded,
+		TotalSize:  s.TotalSize,
+		Speed:      s.Speed,
+	})
+}
+
+// Snapshot returns a copy of every download's current state, in the order
+// they were added
+func (m *Manager) Snapshot() []downloader.Snapshot {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if d, exists := m.downloads[url]; exists {
-		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Attempting to pause download %s in queue %s (current status: %s)", url, d.Queue, d.Status))
+	snapshots := make([]downloader.Snapshot, 0, len(m.all))
+	for _, d := range m.all {
+		snapshots = append(snapshots, d.Snapshot())
+	}
+	return snapshots
+}
 
-		if d.Status == "downloading" {
-			d.Pause()
-			m.activeJobs[d.Queue]--
-			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Successfully paused download %s in queue %s", url, d.Queue))
+// AddDownload takes ownership of a new download and schedules it
+func (m *Manager) AddDownload(d *downloader.Download) error {
+	m.mutex.Lock()
 
-			// Save state
-			if err := config.SaveConfig(m.config); err != nil {
-				logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config when pausing: %v", err))
+	if m.closing {
+		m.mutex.Unlock()
+		return ErrShuttingDown
+	}
+
+	if _, exists := m.downloads[d.ID]; exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("download %s already exists", d.ID)
+	}
+
+	// Nobody else has d yet, so its fields can be set directly
+	if path := m.uniqueTargetPath(d.TargetPath); path != d.TargetPath {
+		d.TargetPath = path
+		d.Filename = filepath.Base(path)
+	}
+
+	d.SetEventBus(m.events)
+	d.SetSharedLimiter(m.limiter)
+	m.all = append(m.all, d)
+	m.downloads[d.ID] = d
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Added download %s to queue %s", d.URL, d.Queue))
+	m.publish(events.Added, d)
+
+	err := m.persist(d)
+	m.mutex.Unlock()
+
+	// Start it right away if the queue has room
+	m.ProcessAllQueues()
+	return err
+}
+
+// uniqueTargetPath returns path, or a numbered variant of it if an unfinished
+// download already writes there (e.g. the same URL added twice to one queue).
+// The caller must hold m.mutex.
+func (m *Manager) uniqueTargetPath(path string) string {
+	inUse := func(p string) bool {
+		for _, d := range m.all {
+			if d.TargetPath == p && !d.GetStatus().IsTerminal() {
+				return true
 			}
-		} else {
-			logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Cannot pause download: invalid status %s", d.Status))
 		}
-	} else {
-		logger.LogDownloadError(url, "", "Cannot pause download: download not found")
+		return false
 	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; inUse(candidate); i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return candidate
 }
 
-// ResumeDownload resumes a specific download
-func (m *Manager) ResumeDownload(url string) {
+// SaveConfig persists the settings, e.g. after queues were edited
+func (m *Manager) SaveConfig() error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
+	return m.saveConfig()
+}
+
+// UpdateConfig applies update to a copy of the settings and validates the
+// result, also against the queues of existing downloads. Only a valid result
+// replaces the settings and is saved; otherwise a *config.ValidationError
+// is returned and nothing changes. Like ApplyConfig, new speed limits and
+// history retention apply to existing downloads right away.
+func (m *Manager) UpdateConfig(update func(cfg *config.Config)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	next := m.config.Clone()
+	update(next)
+	if err := next.ValidateDownloads(m.all); err != nil {
+		return err
+	}
+
+	changes := m.applyLocked(next)
+	if err := m.saveConfig(); err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		m.events.Publish(events.Event{Type: events.ConfigUpdated, Message: strings.Join(changes, "\n")})
+
+		// Raised limits may let waiting downloads start
+		m.ProcessAllQueues()
+	}
+	return nil
+}
+
+// GlobalRate returns the rate in bytes per second that all downloads
+// together are limited to, 0 if they aren't
+func (m *Manager) GlobalRate() int64 {
+	return m.limiter.Rate()
+}
+
+// Config returns a copy of the current settings
+func (m *Manager) Config() *config.Config {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.config.Clone()
+}
+
+// Validate checks the settings and reports downloads whose queue no longer exists
+func (m *Manager) Validate() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.config.ValidateDownloads(m.all)
+}
+
+// saveConfig persists the settings. The caller must hold m.mutex.
+func (m *Manager) saveConfig() error {
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError("", "", fmt.Sprintf("Failed to save config: %v", err))
+		return err
+	}
+	return nil
+}
+
+// hasGlobalRoom reports whether another download may start under the global
+// limit of active downloads. The caller must hold m.mutex.
+func (m *Manager) hasGlobalRoom() bool {
+	return m.config.Limits.MaxActive == 0 || len(m.slots) < m.config.Limits.MaxActive
+}
+
+// persist records the current state of a download in the store. The caller must hold m.mutex.
+func (m *Manager) persist(d *downloader.Download) error {
+	if err := m.db.Put(d); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Failed to save download state: %v", err))
+		return err
+	}
+	return nil
+}
+
+// hasRoom tells whether a download may take a slot in q now: the queue is
+// enabled, inside its time window and below its concurrency limit. The
+// caller must hold m.mutex.
+func (m *Manager) hasRoom(q *config.QueueConfig) bool {
+	return q.Enabled && q.IsTimeAllowed() && m.activeJobs[q.Name] < q.MaxConcurrent
+}
+
+// takeSlot records that a download occupies a slot in queueName. The caller must hold m.mutex.
+func (m *Manager) takeSlot(id, queueName string) {
+	if _, holds := m.slots[id]; holds {
+		return
+	}
+	m.slots[id] = queueName
+	m.activeJobs[queueName]++
+}
+
+// releaseSlot frees the slot held by a download, if any. The caller must hold m.mutex.
+func (m *Manager) releaseSlot(id string) {
+	queueName, holds := m.slots[id]
+	if !holds {
+		return
+	}
+	delete(m.slots, id)
+	m.activeJobs[queueName]--
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d",
+		queueName, m.activeJobs[queueName]))
+}
+
+// PauseDownload pauses a specific download. A pending download is held back
+// from starting.
+func (m *Manager) PauseDownload(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if d, exists := m.downloads[id]; exists {
+		url := d.URL
+		status := d.GetStatus()
+		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Attempting to pause download %s in queue %s (current status: %s)", url, d.Queue, status))
+
+		switch status {
+		case downloader.StatusDownloading, downloader.StatusScheduled:
+			d.Pause()
+			m.releaseSlot(id)
+		case downloader.StatusPending:
+			if _, holds := m.slots[id]; holds {
+				// Started, but Start hasn't changed its status yet
+				return fmt.Errorf("download %s is starting, try again", id)
+			}
+			if err := d.SetStatus(downloader.StatusPaused); err != nil {
+				return err
+			}
+		default:
+			logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Cannot pause download: invalid status %s", status))
+			return fmt.Errorf("cannot pause download %s: it is %s", id, status)
+		}
+		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Successfully paused download %s in queue %s", url, d.Queue))
+
+		// Save state
+		return m.persist(d)
+	}
+
+	logger.LogDownloadError(id, "", "Cannot pause download: download not found")
+	return fmt.Errorf("download %s %w", id, ErrNotFound)
+}
+
+// ResumeDownload resumes a specific download. If the manager hasn't been
+// started, the download is made pending so its queue starts it later.
+func (m *Manager) ResumeDownload(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closing {
+		return ErrShuttingDown
+	}
 
-	if d, exists := m.downloads[url]; exists {
-		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Attempting to resume download %s in queue %s (current status: %s)", url, d.Queue, d.Status))
+	if d, exists := m.downloads[id]; exists {
+		url := d.URL
+		status := d.GetStatus()
+		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Attempting to resume download %s in queue %s (current status: %s)", url, d.Queue, status))
+
+		if _, holds := m.slots[id]; holds {
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Download %s is already being started", url))
+		} else if status == downloader.StatusPaused {
+			if !m.started {
+				if err := d.SetStatus(downloader.StatusPending); err != nil {
+					return err
+				}
+				return m.persist(d)
+			}
 
-		if d.Status == "paused" {
 			// Check if we can resume based on queue limits
 			queueCfg := m.config.GetQueue(d.Queue)
 			if queueCfg == nil {
 				logger.LogDownloadError(url, d.Queue, "Cannot resume: queue configuration not found")
-				return
+				return fmt.Errorf("queue %s %w", d.Queue, ErrNotFound)
 			}
 
 			if !queueCfg.IsTimeAllowed() {
 				logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: outside allowed time window (%s-%s)",
 					queueCfg.StartTime, queueCfg.EndTime))
-				return
+				return fmt.Errorf("queue %s is outside its time window (%s-%s)", d.Queue, queueCfg.StartTime, queueCfg.EndTime)
 			}
 
 			if m.activeJobs[d.Queue] >= queueCfg.MaxConcurrent {
 				logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: queue at maximum capacity (%d downloads)",
 					queueCfg.MaxConcurrent))
-				return
+				return fmt.Errorf("queue %s is at maximum capacity (%d downloads)", d.Queue, queueCfg.MaxConcurrent)
+			}
+
+			if !m.hasGlobalRoom() {
+				logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: global limit of %d active downloads reached",
+					m.config.Limits.MaxActive))
+				return fmt.Errorf("the global limit of %d active downloads is reached", m.config.Limits.MaxActive)
 			}
 
-			// Resume the download
-			d.Resume()
-			m.activeJobs[d.Queue]++
+			// Resume the download; one that isn't running anymore, e.g. because
+			// it was loaded paused from disk, has to be started again
+			if d.IsRunning() {
+				d.Resume()
+				m.takeSlot(id, d.Queue)
+			} else {
+				m.startDownload(d, queueCfg)
+			}
 			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Successfully resumed download %s in queue %s", url, d.Queue))
 
 			// Save state
-			if err := config.SaveConfig(m.config); err != nil {
-				logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config when resuming: %v", err))
-			}
+			return m.persist(d)
 		} else {
-			logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Cannot resume download: invalid status %s", d.Status))
+			logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Cannot resume download: invalid status %s", status))
+			return fmt.Errorf("cannot resume download %s: it is %s", id, status)
 		}
-	} else {
-		logger.LogDownloadError(url, "", "Cannot resume download: download not found")
+		return nil
 	}
+
+	logger.LogDownloadError(id, "", "Cannot resume download: download not found")
+	return fmt.Errorf("download %s %w", id, ErrNotFound)
 }
 
 // processQueues checks each queue and starts eligible downloads
@@ -141,9 +510,14 @@ func (m *Manager) processQueues() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.closing || !m.started {
+		return
+	}
+
 	logger.LogDownloadEvent("SYSTEM", "Processing queues")
 
-	for _, queueCfg := range m.config.Queues {
+	for i := range m.config.Queues {
+		queueCfg := &m.config.Queues[i]
 		if !queueCfg.Enabled {
 			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Disabled", queueCfg.Name))
 			continue
@@ -154,11 +528,13 @@ func (m *Manager) processQueues() {
 				queueCfg.Name, queueCfg.StartTime, queueCfg.EndTime))
 
 			// Pause any active downloads in this queue that are outside the time window
-			for _, download := range m.downloads {
-				if download.Queue == queueCfg.Name && download.Status == "downloading" {
+			for _, download := range m.all {
+				status := download.GetStatus()
+				if download.Queue == queueCfg.Name && (status == downloader.StatusDownloading || status == downloader.StatusScheduled) {
 					download.Pause()
-					m.activeJobs[queueCfg.Name]--
+					m.releaseSlot(download.ID)
 					logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Paused download %s: Outside allowed time window", download.URL))
+					m.persist(download)
 				}
 			}
 			continue
@@ -172,11 +548,18 @@ func (m *Manager) processQueues() {
 		}
 
 		// Resume any paused downloads that were paused due to time restrictions
-		for _, download := range m.downloads {
-			if download.Queue == queueCfg.Name && download.Status == "paused" {
-				if activeCount < queueCfg.MaxConcurrent {
-					download.Resume()
-					m.activeJobs[queueCfg.Name]++
+		for _, download := range m.all {
+			if _, holds := m.slots[download.ID]; holds {
+				continue
+			}
+			if download.Queue == queueCfg.Name && download.GetStatus() == downloader.StatusPaused {
+				if activeCount < queueCfg.MaxConcurrent && m.hasGlobalRoom() {
+					if download.IsRunning() {
+						download.Resume()
+						m.takeSlot(download.ID, queueCfg.Name)
+					} else {
+						m.startDownload(download, queueCfg)
+					}
 					activeCount++
 					logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Resumed download %s: Within allowed time window", download.URL))
 				}
@@ -186,13 +569,15 @@ func (m *Manager) processQueues() {
 		// Find pending downloads for this queue
 		pendingCount := 0
 		startedCount := 0
-		for i := range m.config.Downloads {
-			download := &m.config.Downloads[i]
-			if download.Queue == queueCfg.Name && download.Status == "pending" {
+		for _, download := range m.all {
+			if _, holds := m.slots[download.ID]; holds {
+				// Started, but Start hasn't changed its status yet
+				continue
+			}
+			if download.Queue == queueCfg.Name && download.GetStatus() == downloader.StatusPending {
 				pendingCount++
-				if activeCount < queueCfg.MaxConcurrent {
-					m.startDownload(download, &queueCfg)
-					m.downloads[download.URL] = download
+				if activeCount < queueCfg.MaxConcurrent && m.hasGlobalRoom() {
+					m.startDownload(download, queueCfg)
 					activeCount++
 					startedCount++
 				}
@@ -204,90 +589,261 @@ func (m *Manager) processQueues() {
 	}
 }
 
-// startDownload begins a new download
+// startDownload begins a new download. Its status changes once Start runs;
+// the slot taken here marks it as started until then. The caller must hold m.mutex.
 func (m *Manager) startDownload(d *downloader.Download, q *config.QueueConfig) {
-	d.Status = "downloading"
-	m.activeJobs[q.Name]++
+	if m.closing {
+		return
+	}
+	m.takeSlot(d.ID, q.Name)
+	m.running.Add(1)
 
 	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Starting download %s in queue %s", d.URL, q.Name))
 
 	go func() {
-		// Start the actual download
-		err := d.Start()
+		defer m.running.Done()
+
+		// Start the actual download; it sets its own final status
+		err := d.Start(m.ctx)
+
+		// Record the outcome before taking the lock, since completed files
+		// are checksummed. Cancellations are recorded by whoever cancelled.
+		switch status := d.GetStatus(); {
+		case err == nil:
+			m.recordHistory(d.Snapshot(), history.OutcomeCompleted)
+		case status == downloader.StatusError:
+			m.recordHistory(d.Snapshot(), history.OutcomeFailed)
+		}
 
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 
-		// Update download status
-		if err != nil && d.Status != "cancelled" {
-			d.Status = "error"
-			d.Error = err.Error()
-			logger.LogDownloadError(d.URL, q.Name, fmt.Sprintf("Download failed: %v", err))
-		} else if d.Status != "cancelled" {
-			d.Status = "completed"
-			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Download %s completed in queue %s", d.URL, q.Name))
+		switch {
+		case err == nil:
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Download %s completed in queue %s", d.URL, d.Queue))
+		case errors.Is(err, downloader.ErrCancelled), m.ctx.Err() != nil:
+			// Cancelled, or interrupted by Stop and left paused
+		default:
+			logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Download failed: %v", err))
 		}
 
-		// Decrease active job count
-		m.activeJobs[q.Name]--
-		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d/%d",
-			q.Name, m.activeJobs[q.Name], q.MaxConcurrent))
+		// Free the slot, which may belong to another queue than q if the
+		// download was moved while running
+		m.releaseSlot(d.ID)
 
-		// Save the updated state
-		if err := config.SaveConfig(m.config); err != nil {
-			logger.LogDownloadError(d.URL, q.Name, fmt.Sprintf("Failed to save config after download: %v", err))
+		// Save the updated state, unless the download was removed meanwhile
+		if m.downloads[d.ID] == d {
+			m.persist(d)
 		}
 	}()
 }
 
-// RemoveDownload removes a download from the queue
-func (m *Manager) RemoveDownload(url string) {
+// CancelDownload stops a download, deletes its partial file and removes it
+func (m *Manager) CancelDownload(id string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// Find the download first to log its details and update active jobs
-	var queueName string
-	for _, d := range m.config.Downloads {
-		if d.URL == url {
-			queueName = d.Queue
-			// Update active jobs count if needed
-			if d.Status == "downloading" {
-				m.activeJobs[d.Queue]--
-				logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d",
-					d.Queue, m.activeJobs[d.Queue]))
-			}
-			break
-		}
+	d, exists := m.downloads[id]
+	if !exists {
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
+	}
+
+	err := m.cancelDownload(d)
+	if removeErr := m.removeDownload(id); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// RemoveDownload removes a download from the queue, stopping it if it is running
+func (m *Manager) RemoveDownload(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d, exists := m.downloads[id]
+	if !exists {
+		logger.LogDownloadError(id, "", "Cannot remove download: download not found")
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
 	}
 
-	// Remove from active downloads
-	delete(m.downloads, url)
+	// Stop the transfer so it doesn't keep running without an owner
+	var err error
+	if status := d.GetStatus(); status.IsActive() || status == downloader.StatusPaused {
+		err = m.cancelDownload(d)
+	}
+
+	if removeErr := m.removeDownload(id); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
+}
 
-	// Remove from config downloads
-	for i, d := range m.config.Downloads {
-		if d.URL == url {
-			m.config.Downloads = append(m.config.Downloads[:i], m.config.Downloads[i+1:]...)
-			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Removed download %s from queue %s", url, queueName))
+// removeDownload drops a download from all bookkeeping and the store. The
+// caller must hold m.mutex.
+func (m *Manager) removeDownload(id string) error {
+	m.releaseSlot(id)
+	delete(m.downloads, id)
+
+	for i, d := range m.all {
+		if d.ID == id {
+			m.all = append(m.all[:i], m.all[i+1:]...)
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Removed download %s (%s) from queue %s", d.URL, id, d.Queue))
+			m.publish(events.Removed, d)
 			break
 		}
 	}
+
+	if err := m.db.Delete(id); err != nil {
+		logger.LogDownloadError(id, "", fmt.Sprintf("Failed to remove download from store: %v", err))
+		return err
+	}
+	return nil
 }
 
-// ProcessDownload processes a specific download (used for retrying downloads)
-func (m *Manager) ProcessDownload(url string) {
+// RetryDownload puts a failed download back to pending and tries to start it
+func (m *Manager) RetryDownload(id string) error {
+	m.mutex.Lock()
+	d, exists := m.downloads[id]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
+	}
+	err := d.Retry()
+	if err == nil {
+		m.persist(d)
+	}
+	m.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("cannot retry download %s: %w", id, err)
+	}
+	m.ProcessDownload(id)
+	return nil
+}
+
+// ResetRetryCount resets the retry counter of a download
+func (m *Manager) ResetRetryCount(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d, exists := m.downloads[id]
+	if !exists {
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
+	}
+	d.ResetRetryCount()
+	return m.persist(d)
+}
+
+// SetSpeedLimit changes the speed limit of a download in KB/s, 0 for
+// unlimited. A running download picks it up on its next read.
+func (m *Manager) SetSpeedLimit(id string, limit int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d, exists := m.downloads[id]
+	if !exists {
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
+	}
+	if limit < 0 {
+		return fmt.Errorf("speed limit must not be negative, got %d", limit)
+	}
+	d.SetMaxBandwidth(limit)
+	return m.persist(d)
+}
+
+// MoveDownloads moves downloads to another queue. Active downloads take a slot
+// in the target queue if it is enabled, in its time window and has one free,
+// and are paused otherwise; a download that is starting or being verified
+// can't be paused and isn't moved. Every moved download gets the target
+// queue's speed limit. If relocate is set, partial and completed files are
+// moved into the target queue's path.
+func (m *Manager) MoveDownloads(ids []string, queueName string, relocate bool) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if d, exists := m.downloads[url]; exists && d.Status == "pending" {
-		// Find the queue configuration
-		var queueCfg *config.QueueConfig
-		for _, q := range m.config.Queues {
-			if q.Name == d.Queue {
-				queueCfg = &q
-				break
+	target := m.config.GetQueue(queueName)
+	if target == nil {
+		return fmt.Errorf("queue %s %w", queueName, ErrNotFound)
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := m.moveDownload(id, target, relocate); err != nil {
+			logger.LogDownloadError(id, queueName, fmt.Sprintf("Failed to move download: %v", err))
+			errs = append(errs, err)
+		}
+		// Save state, also of downloads whose move failed halfway
+		if d, exists := m.downloads[id]; exists {
+			if err := m.persist(d); err != nil {
+				errs = append(errs, err)
 			}
 		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// moveDownload moves a single download to the target queue. The caller must hold m.mutex.
+func (m *Manager) moveDownload(id string, target *config.QueueConfig, relocate bool) error {
+	d, exists := m.downloads[id]
+	if !exists {
+		return fmt.Errorf("download %s %w", id, ErrNotFound)
+	}
+
+	oldQueue := d.Queue
+	_, holds := m.slots[id]
+	moving := oldQueue != target.Name
+
+	// Without a free slot in the target queue, an active download waits
+	// there paused. Its slot is only given up once the transfer stopped, so
+	// a download that is starting or being verified can't be moved yet.
+	if moving && holds && !m.hasRoom(target) {
+		if status := d.GetStatus(); status == downloader.StatusDownloading || status == downloader.StatusScheduled {
+			d.Pause()
+		}
+		if status := d.GetStatus(); status != downloader.StatusPaused {
+			return fmt.Errorf("download %s is %s and can't wait for a slot in queue %s, try again", id, status, target.Name)
+		}
+		m.releaseSlot(id)
+		holds = false
+		logger.LogDownloadPending(d.URL, target.Name, "Paused after move: target queue has no free slot")
+	}
+
+	if relocate && target.Path != "" {
+		if err := d.Relocate(filepath.Join(target.Path, d.Filename)); err != nil {
+			return err
+		}
+	}
+
+	if !moving {
+		return nil
+	}
+
+	d.SetQueue(target.Name)
+	d.SetMaxBandwidth(target.SpeedLimit)
+
+	// Transfer the active slot to the target queue
+	if holds {
+		m.releaseSlot(id)
+		m.takeSlot(id, target.Name)
+	}
+
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Moved download %s from queue %s to queue %s", d.URL, oldQueue, target.Name))
+	return nil
+}
+
+// ProcessDownload processes a specific download (used for retrying downloads)
+func (m *Manager) ProcessDownload(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.started {
+		return
+	}
 
+	if d, exists := m.downloads[id]; exists && d.GetStatus() == downloader.StatusPending {
+		url := d.URL
+		queueCfg := m.config.GetQueue(d.Queue)
 		if queueCfg == nil {
 			logger.LogDownloadError(url, d.Queue, "Cannot process: queue configuration not found")
 			return
@@ -307,6 +863,12 @@ func (m *Manager) ProcessDownload(url string) {
 			return
 		}
 
+		if !m.hasGlobalRoom() {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot process: global limit of %d active downloads reached",
+				m.config.Limits.MaxActive))
+			return
+		}
+
 		// Process the download
 		m.startDownload(d, queueCfg)
 
@@ -323,28 +885,93 @@ func (m *Manager) ProcessAllQueues() {
 	}()
 }
 
-// AddURL adds a URL to the queue with error handling
-func (m *Manager) AddURL(rawURL string) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// AddOptions are the optional settings of a download added by URL
+type AddOptions struct {
+	Queue    string    `json:"queue,omitempty"`    // The default queue if empty
+	Path     string    `json:"path,omitempty"`     // File to save to, overriding Dir and Filename
+	Dir      string    `json:"dir,omitempty"`      // Directory to save in, the queue's if empty
+	Filename string    `json:"filename,omitempty"` // Name of the file in Dir, named after the URL if empty
+	Headers  []string  `json:"headers,omitempty"`  // Extra request headers as "Name: value"
+	Start    time.Time `json:"start,omitempty"`    // Scheduled start, zero to start as soon as the queue has room
+}
+
+// CheckFilename reports why name can't be the name of a file inside a
+// directory: it must not be a path, absolute or relative, nor . or .., which
+// name directories. Dots elsewhere, as in "v1..2.tar.gz", are fine.
+func CheckFilename(name string) error {
+	if name == "." || name == ".." {
+		return fmt.Errorf("file name %q names a directory", name)
+	}
+	if strings.ContainsAny(name, `/\`) || filepath.VolumeName(name) != "" || filepath.Base(name) != name {
+		return fmt.Errorf("file name %q must not contain a path", name)
+	}
+	return nil
+}
+
+// AddURL adds a URL to the default queue with error handling and returns the
+// ID of the new download. The same URL may be added more than once.
+func (m *Manager) AddURL(rawURL string) (string, error) {
+	return m.Add(rawURL, AddOptions{})
+}
 
+// Add is like AddURL, but with the queue, file, headers and start time in opts
+func (m *Manager) Add(rawURL string, opts AddOptions) (string, error) {
 	// Validate URL format
 	parsedURL, err := url.ParseRequestURI(rawURL)
 	if err != nil {
-		return errors.New("invalid URL format")
+		return "", fmt.Errorf("%w format", ErrInvalidURL)
 	}
 
 	// Check for supported protocol
 	if !strings.HasPrefix(parsedURL.Scheme, "http") {
-		return errors.New("unsupported URL protocol")
+		return "", fmt.Errorf("%w: unsupported protocol %q", ErrInvalidURL, parsedURL.Scheme)
 	}
+	for _, h := range opts.Headers {
+		if _, _, err := downloader.ParseHeader(h); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+		}
+	}
+	if opts.Filename != "" {
+		if err := CheckFilename(opts.Filename); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+		}
+	}
+
+	m.mutex.Lock()
+	queueName := opts.Queue
+	if queueName == "" {
+		queueName = m.config.DefaultQueue
+	}
+	targetDir := m.config.SavePath
+	var maxBandwidth int64
+	q := m.config.GetQueue(queueName)
+	if q != nil {
+		maxBandwidth = q.SpeedLimit
+		if q.Path != "" {
+			targetDir = q.Path
+		}
+	}
+	m.mutex.Unlock()
 
-	// Check for duplicates
-	if _, exists := m.downloads[rawURL]; exists {
-		return errors.New("URL already in queue")
+	if q == nil {
+		return "", fmt.Errorf("queue %s %w", queueName, ErrNotFound)
 	}
 
-	// Add URL to the downloads map
-	m.downloads[rawURL] = &downloader.Download{URL: rawURL}
-	return nil
+	targetPath := opts.Path
+	if targetPath == "" {
+		if opts.Dir != "" {
+			targetDir = opts.Dir
+		}
+		filename := opts.Filename
+		if filename == "" {
+			filename = filepath.Base(parsedURL.Path)
+		}
+		targetPath = filepath.Join(targetDir, filename)
+	}
+	d := downloader.New(rawURL, targetPath, queueName, maxBandwidth, opts.Start)
+	d.Headers = opts.Headers
+	if err := m.AddDownload(d); err != nil {
+		return "", err
+	}
+	return d.ID, nil
 }
@@ -0,0 +1,398 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// newTestManager returns a manager on a default config and empty store in a
+// temporary directory
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfig, filepath.Join(dir, "config.json"))
+	t.Setenv(config.EnvDataDir, filepath.Join(dir, "data"))
+	t.Setenv(config.EnvDownloadDir, filepath.Join(dir, "downloads"))
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.Open(config.GetDownloadsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(cfg, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown(context.Background()) })
+	return m
+}
+
+// newStallingServer answers every download with a few bytes and then waits
+// until the client goes away, so downloads stay active
+func newStallingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(make([]byte, 100))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// countStatus returns how many downloads of m have status
+func countStatus(m *Manager, status downloader.Status) int {
+	n := 0
+	for _, s := range m.Snapshot() {
+		if s.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForCount polls until n downloads of m have status or fails the test
+func waitForCount(t *testing.T, m *Manager, status downloader.Status, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for countStatus(m, status) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d downloads are %s, want %d", countStatus(m, status), status, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMaxActiveLimitsAllQueues(t *testing.T) {
+	srv := newStallingServer(t)
+	m := newTestManager(t)
+	if err := m.UpdateConfig(func(cfg *config.Config) { cfg.Limits.MaxActive = 2 }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Four downloads in a queue that would run three at once, and one more
+	// in a queue of its own; the default config's second queue only runs at
+	// night, so add one that is always open
+	err := m.UpdateConfig(func(cfg *config.Config) {
+		cfg.Queues = append(cfg.Queues, config.QueueConfig{Name: "other", MaxConcurrent: 3, StartTime: "00:00", EndTime: "23:59", Enabled: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, queueName := range []string{"default", "default", "default", "default", "other"} {
+		if _, err := m.Add(srv.URL+"/file.bin", AddOptions{Queue: queueName, Filename: fmt.Sprintf("file%d.bin", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Start()
+
+	waitForCount(t, m, downloader.StatusDownloading, 2)
+	time.Sleep(100 * time.Millisecond)
+	m.ProcessAllQueues()
+	if n := countStatus(m, downloader.StatusDownloading); n != 2 {
+		t.Fatalf("%d downloads running under a global limit of 2", n)
+	}
+	m.mutex.Lock()
+	slots := len(m.slots)
+	m.mutex.Unlock()
+	if slots != 2 {
+		t.Fatalf("%d slots taken under a global limit of 2", slots)
+	}
+
+	// Raising the limit lets waiting downloads start right away
+	if err := m.UpdateConfig(func(cfg *config.Config) { cfg.Limits.MaxActive = 0 }); err != nil {
+		t.Fatal(err)
+	}
+	waitForCount(t, m, downloader.StatusDownloading, 4)
+	if n := countStatus(m, downloader.StatusPending); n != 1 {
+		t.Fatalf("%d downloads pending, want the one beyond the default queue's 3", n)
+	}
+}
+
+func TestGlobalSpeedLimitIsShared(t *testing.T) {
+	m := newTestManager(t)
+	if got := m.GlobalRate(); got != 0 {
+		t.Fatalf("default global rate = %d, want 0", got)
+	}
+	if err := m.UpdateConfig(func(cfg *config.Config) { cfg.Limits.SpeedLimit = 256 }); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GlobalRate(); got != 256*1024 {
+		t.Fatalf("global rate = %d, want %d", got, 256*1024)
+	}
+
+}
+
+func TestMoveActiveDownload(t *testing.T) {
+	srv := newStallingServer(t)
+	m := newTestManager(t)
+	err := m.UpdateConfig(func(cfg *config.Config) {
+		cfg.Queues = append(cfg.Queues,
+			config.QueueConfig{Name: "open", MaxConcurrent: 1, StartTime: "00:00", EndTime: "23:59", Enabled: true},
+			config.QueueConfig{Name: "off", MaxConcurrent: 1, StartTime: "00:00", EndTime: "23:59", Enabled: false})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Add(srv.URL+"/file.bin", AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	waitForCount(t, m, downloader.StatusDownloading, 1)
+
+	slot := func() (string, bool) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		q, ok := m.slots[id]
+		return q, ok
+	}
+
+	// A queue with room takes over the slot and the transfer goes on
+	if err := m.MoveDownloads([]string{id}, "open", false); err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := slot(); !ok || q != "open" {
+		t.Fatalf("slot in %q (held %v) after moving to a queue with room", q, ok)
+	}
+	if s := m.Snapshot()[0]; s.Status != downloader.StatusDownloading || s.Queue != "open" {
+		t.Fatalf("download is %s in queue %s after the move", s.Status, s.Queue)
+	}
+
+	// A disabled queue has no room, so the download is paused and gives up
+	// its slot
+	if err := m.MoveDownloads([]string{id}, "off", false); err != nil {
+		t.Fatal(err)
+	}
+	if q, ok := slot(); ok {
+		t.Fatalf("download still holds a slot in %s after moving to a disabled queue", q)
+	}
+	if s := m.Snapshot()[0]; s.Status != downloader.StatusPaused || s.Queue != "off" {
+		t.Fatalf("download is %s in queue %s after moving to a disabled queue", s.Status, s.Queue)
+	}
+}
+
+func TestMoveStartingDownloadKeepsSlot(t *testing.T) {
+	m := newTestManager(t)
+	err := m.UpdateConfig(func(cfg *config.Config) {
+		cfg.Queues = append(cfg.Queues, config.QueueConfig{Name: "off", MaxConcurrent: 1, StartTime: "00:00", EndTime: "23:59", Enabled: false})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.AddURL("http://example.com/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Started, but Start hasn't changed its status yet, so it can't be paused
+	m.mutex.Lock()
+	m.takeSlot(id, "default")
+	m.mutex.Unlock()
+
+	if err := m.MoveDownloads([]string{id}, "off", false); err == nil {
+		t.Fatal("moving a starting download into a queue without room succeeded")
+	}
+	m.mutex.Lock()
+	q, ok := m.slots[id]
+	m.mutex.Unlock()
+	if !ok || q != "default" {
+		t.Fatalf("slot in %q (held %v), want it kept in default", q, ok)
+	}
+	if s := m.Snapshot()[0]; s.Queue != "default" {
+		t.Fatalf("download moved to %s", s.Queue)
+	}
+}
+
+func TestCutConnectionFreesSlot(t *testing.T) {
+	// While broken, requests stall and then lose their connection
+	var broken atomic.Bool
+	broken.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if broken.Load() {
+			time.Sleep(20 * time.Millisecond)
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write(make([]byte, 1000))
+	}))
+	t.Cleanup(srv.Close)
+
+	m := newTestManager(t)
+	d := downloader.New(srv.URL+"/file.bin", filepath.Join(t.TempDir(), "file.bin"), "default", 0, time.Time{})
+	d.MaxRetries = 1
+	d.RetryDelay = 10 * time.Millisecond
+	if err := m.AddDownload(d); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+
+	waitForCount(t, m, downloader.StatusError, 1)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		m.mutex.Lock()
+		_, holds := m.slots[d.ID]
+		active := m.activeJobs["default"]
+		m.mutex.Unlock()
+		if !holds && active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed download still holds its slot (%d active in its queue)", active)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	broken.Store(false)
+	if err := m.RetryDownload(d.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitForCount(t, m, downloader.StatusCompleted, 1)
+}
+
+func TestUpdateConfigAppliesQueueSpeedLimit(t *testing.T) {
+	m := newTestManager(t)
+	inDefault, err := m.AddURL("http://example.com/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inNight, err := m.Add("http://example.com/b.bin", AddOptions{Queue: "night"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.UpdateConfig(func(cfg *config.Config) { cfg.GetQueue("default").SpeedLimit = 100 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{inDefault: 100, inNight: 0}
+	for _, s := range m.Snapshot() {
+		if s.MaxBandwidth != want[s.ID] {
+			t.Errorf("download in %s has a speed limit of %d KB/s, want %d", s.Queue, s.MaxBandwidth, want[s.ID])
+		}
+	}
+
+	// The new limit is saved with the download
+	downloads, err := store.ReadDownloads(config.GetDownloadsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range downloads {
+		if d.MaxBandwidth != want[d.ID] {
+			t.Errorf("stored download in %s has a speed limit of %d KB/s, want %d", d.Queue, d.MaxBandwidth, want[d.ID])
+		}
+	}
+}
+
+func TestCheckFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"file.bin", true},
+		{"v1..2.tar.gz", true},
+		{"notes..txt", true},
+		{"..hidden", true},
+		{".config", true},
+		{".", false},
+		{"..", false},
+		{"../file.bin", false},
+		{"dir/file.bin", false},
+		{`dir\file.bin`, false},
+		{"/etc/passwd", false},
+		{"file.bin/", false},
+	}
+	for _, tt := range tests {
+		if err := CheckFilename(tt.name); (err == nil) != tt.ok {
+			t.Errorf("CheckFilename(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	m := newTestManager(t)
+	hist, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"), history.Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.history = hist
+
+	path := filepath.Join(t.TempDir(), "done.bin")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Now().Add(-time.Minute).Truncate(time.Second)
+	firstStart := finished.Add(-time.Hour)
+
+	// Resumed over an hour, but transferring for only 4 seconds
+	m.recordHistory(downloader.Snapshot{
+		ID: "done", URL: "http://example.com/done.bin", TargetPath: path, Queue: "default",
+		Downloaded: 4000, StartTime: firstStart, ActiveTime: 4 * time.Second, CompletionTime: finished,
+	}, history.OutcomeCompleted)
+	// Saved before active time was kept
+	m.recordHistory(downloader.Snapshot{
+		ID: "old", URL: "http://example.com/old.bin", Queue: "default",
+		Downloaded: 3600, StartTime: time.Now().Add(-time.Hour), Error: "boom",
+	}, history.OutcomeFailed)
+	// Cancelled before it started
+	m.recordHistory(downloader.Snapshot{ID: "never", URL: "http://example.com/never.bin"}, history.OutcomeCancelled)
+
+	entries := m.HistoryEntries(history.Filter{})
+	byID := map[string]history.Entry{}
+	for _, e := range entries {
+		byID[e.DownloadID] = e
+	}
+	if len(byID) != 3 {
+		t.Fatalf("%d entries recorded, want 3", len(entries))
+	}
+
+	done := byID["done"]
+	if !done.StartedAt.Equal(firstStart) || !done.FinishedAt.Equal(finished) || done.Duration != 4*time.Second || done.AvgSpeed != 1000 || done.Size != 4000 {
+		t.Errorf("completed entry = %+v, want the first start, 4s and 1000 B/s", done)
+	}
+	if sum := sha256.Sum256([]byte("hello")); done.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum = %q", done.Checksum)
+	}
+
+	old := byID["old"]
+	if old.Duration < time.Hour || old.Duration > time.Hour+time.Minute || old.Error != "boom" {
+		t.Errorf("failed entry = %+v, want an hour from its start time and the error", old)
+	}
+
+	never := byID["never"]
+	if never.Duration != 0 || never.AvgSpeed != 0 || never.Error != "" {
+		t.Errorf("cancelled entry = %+v, want no duration or speed", never)
+	}
+
+	// Searching finds entries by their error
+	if got := m.HistoryEntries(history.Filter{Query: "boom"}); len(got) != 1 || got[0].DownloadID != "old" {
+		t.Errorf("search for the error found %v", got)
+	}
+}
@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+	"github.com/mahdiXak47/Download-Manager/internal/network"
+)
+
+// networkCheckInterval is how often WatchNetwork looks at the monitor
+const networkCheckInterval = 2 * time.Second
+
+// WatchNetwork pauses the active downloads when monitor reports the network
+// down and resumes them once it is back, until the manager is stopped. Only
+// connectivity changes are acted on, so downloads paused by the user stay
+// paused.
+func (m *Manager) WatchNetwork(monitor *network.Monitor) {
+	go func() {
+		ticker := time.NewTicker(networkCheckInterval)
+		defer ticker.Stop()
+
+		connected := true
+		var paused []string // IDs of downloads paused because the network went down
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if monitor.IsConnected() == connected {
+				continue
+			}
+			connected = !connected
+			logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Network status changed: connected=%v", connected))
+
+			if !connected {
+				paused = m.pauseForNetwork()
+				m.events.Publish(events.Event{Type: events.NetworkLost, Message: fmt.Sprintf("Paused %d download(s)", len(paused))})
+			} else {
+				resumed := m.resumeAfterNetwork(paused)
+				paused = nil
+				m.events.Publish(events.Event{Type: events.NetworkRestored, Message: fmt.Sprintf("Resumed %d download(s)", resumed)})
+			}
+		}
+	}()
+}
+
+// pauseForNetwork pauses every download that is transferring and returns their IDs
+func (m *Manager) pauseForNetwork() []string {
+	var ids []string
+	for _, s := range m.Snapshot() {
+		if s.Status != downloader.StatusDownloading {
+			continue
+		}
+		logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Pausing download due to network loss: %s", s.URL))
+		if err := m.PauseDownload(s.ID); err == nil {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+// resumeAfterNetwork resumes the downloads in ids that are still paused and
+// returns how many it resumed
+func (m *Manager) resumeAfterNetwork(ids []string) int {
+	wasPaused := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wasPaused[id] = true
+	}
+
+	resumed := 0
+	for _, s := range m.Snapshot() {
+		if !wasPaused[s.ID] || s.Status != downloader.StatusPaused {
+			continue
+		}
+		logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Resuming download after network restore: %s", s.URL))
+		if err := m.ResumeDownload(s.ID); err == nil {
+			resumed++
+		}
+	}
+	return resumed
+}
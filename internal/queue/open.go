@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+)
+
+// Open creates a manager for the downloads and history of the active
+// profile, first importing any downloads an old config file held. The
+// manager isn't started. While it is open, no other process
+// can open the same downloads; store.ErrLocked is returned instead.
+func Open(cfg *config.Config) (*Manager, error) {
+	db, err := store.Open(config.GetDownloadsPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open download store: %w", err)
+	}
+	if err := config.ImportLegacyDownloads(cfg, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to import downloads: %w", err)
+	}
+
+	hist, err := history.Open(config.GetHistoryPath(), history.Retention{
+		MaxAge:     cfg.History.MaxAge(),
+		MaxEntries: cfg.History.MaxEntries,
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open download history: %w", err)
+	}
+
+	m, err := NewManager(cfg, db, hist)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load downloads: %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// WatchConfig reloads the settings whenever the file at path changes, until
+// the manager is stopped
+func (m *Manager) WatchConfig(path string) {
+	watcher := config.NewWatcher(path)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-watcher.Changes():
+				m.reloadConfig(path)
+			}
+		}
+	}()
+}
+
+// reloadConfig reads the settings at path and applies them. Invalid settings
+// are reported and the running ones kept.
+func (m *Manager) reloadConfig(path string) {
+	next, err := config.ReadConfig(path)
+	if os.IsNotExist(err) {
+		// Deleted, or in the middle of being replaced; a new file is reported again
+		return
+	}
+
+	var changes []string
+	if err == nil {
+		changes, err = m.ApplyConfig(next)
+	}
+	if err != nil {
+		logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Ignoring changed config: %v", err))
+		m.events.Publish(events.Event{Type: events.ConfigRejected, Error: err.Error()})
+		return
+	}
+	if len(changes) == 0 {
+		// Usually our own save
+		return
+	}
+
+	for _, change := range changes {
+		logger.LogDownloadEvent("CONFIG", "Reloaded "+change)
+	}
+	m.events.Publish(events.Event{Type: events.ConfigReloaded, Message: strings.Join(changes, "\n")})
+}
+
+// ApplyConfig replaces the running settings with next and returns what
+// changed. next is validated against the existing downloads first and
+// rejected with a *config.ValidationError if it doesn't fit. Active
+// transfers keep running: new speed limits apply to them right away, while
+// changed windows and concurrency limits take effect as the queues are
+// processed, which happens immediately.
+func (m *Manager) ApplyConfig(next *config.Config) ([]string, error) {
+	m.mutex.Lock()
+
+	if err := next.ValidateDownloads(m.all); err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+
+	changes := m.applyLocked(next)
+	m.mutex.Unlock()
+
+	if len(changes) > 0 {
+		m.ProcessAllQueues()
+	}
+	return changes, nil
+}
+
+// applyLocked makes next the running settings and passes what changed on
+// to the existing downloads, the shared limiter and the history. It returns
+// what changed. The caller must hold m.mutex and have validated next.
+func (m *Manager) applyLocked(next *config.Config) []string {
+	changes := config.Diff(m.config, next)
+	old := m.config.Clone()
+	*m.config = *next.Clone()
+
+	for _, d := range m.all {
+		prev, cur := old.GetQueue(d.Queue), m.config.GetQueue(d.Queue)
+		if prev != nil && prev.SpeedLimit != cur.SpeedLimit {
+			d.SetMaxBandwidth(cur.SpeedLimit)
+			m.persist(d)
+		}
+	}
+
+	m.limiter.SetRate(m.config.Limits.SpeedLimit * 1024)
+
+	if m.history != nil && old.History != m.config.History {
+		if err := m.history.SetRetention(history.Retention{
+			MaxAge:     m.config.History.MaxAge(),
+			MaxEntries: m.config.History.MaxEntries,
+		}); err != nil {
+			logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Failed to apply history retention: %v", err))
+		}
+	}
+	return changes
+}
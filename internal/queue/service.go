@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
+)
+
+// Service is what the interface and the commands work on: a *Manager in
+// this process, or a client of a daemon that runs one. Errors keep their
+// meaning across both, so ErrNotFound, ErrInvalidURL, ErrShuttingDown and
+// *config.ValidationError can be checked for either way.
+type Service interface {
+	// Snapshot returns the state of every download, in the order they were added
+	Snapshot() []downloader.Snapshot
+	// Config returns a copy of the current settings
+	Config() *config.Config
+	// Events returns the bus on which download and settings events are published
+	Events() *events.Bus
+
+	Add(rawURL string, opts AddOptions) (string, error)
+	PauseDownload(id string) error
+	ResumeDownload(id string) error
+	CancelDownload(id string) error
+	RemoveDownload(id string) error
+	RetryDownload(id string) error
+	ResetRetryCount(id string) error
+	MoveDownloads(ids []string, queueName string, relocate bool) error
+
+	UpdateConfig(update func(cfg *config.Config)) error
+	Validate() error
+
+	HistoryEntries(filter history.Filter) []history.Entry
+	Redownload(e history.Entry) (string, error)
+
+	// Shutdown releases the service. A manager stops its downloads and saves
+	// their state; a client detaches and leaves the daemon running.
+	Shutdown(ctx context.Context) error
+}
+
+var _ Service = (*Manager)(nil)
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// lockFile is not available on this platform; the store isn't protected
+// against being opened by two processes
+func lockFile(file *os.File) error {
+	return nil
+}
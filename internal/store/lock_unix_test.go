@@ -0,0 +1,27 @@
+//go:build unix
+
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downloads.jsonl")
+	s := open(t, path)
+	s.Put(newDownload("a"))
+
+	if _, err := Open(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Open returned %v, want %v", err, ErrLocked)
+	}
+	// Reading works while the store is open
+	downloads, err := ReadDownloads(path)
+	if err != nil || len(downloads) != 1 {
+		t.Fatalf("ReadDownloads = %d downloads, %v", len(downloads), err)
+	}
+
+	s.Close()
+	open(t, path)
+}
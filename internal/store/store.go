@@ -0,0 +1,299 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/fsutil"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// Record operations in the journal
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// ErrLocked is returned by Open when another process has the store open
+var ErrLocked = errors.New("download state is in use by another process")
+
+// compactSlack is the number of records the journal may hold beyond twice
+// the number of live downloads before it is compacted
+const compactSlack = 64
+
+// record is one line of the journal
+type record struct {
+	Op       string          `json:"op"`
+	ID       string          `json:"id"`
+	Download json.RawMessage `json:"download,omitempty"`
+}
+
+// Store persists download state in an append-only journal. Every change
+// appends the latest state of one download; replaying the journal yields the
+// current state. Once the journal has grown well beyond the number of live
+// downloads it is compacted into one record per download.
+type Store struct {
+	path    string
+	file    *os.File
+	lock    *os.File                   // Held open while the store is, see Open
+	latest  map[string]json.RawMessage // ID -> latest encoded state
+	order   []string                   // IDs in the order they were first stored
+	records int                        // Records in the journal file
+	damaged bool                       // Replay skipped unreadable lines
+	mutex   sync.Mutex
+}
+
+// Open loads the journal at path, creating it if it doesn't exist. Only one
+// process can have the store open at a time; while another one has, Open
+// returns ErrLocked. The lock is a separate file, since compaction replaces
+// the journal.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		path:   path,
+		lock:   lock,
+		latest: make(map[string]json.RawMessage),
+	}
+	if err := s.replay(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.file = file
+
+	// Appending after a torn line would run the next record into it, so a
+	// damaged journal is rewritten before anything is added
+	if s.damaged {
+		if err := s.compact(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ReadDownloads loads the downloads stored in the journal at path without
+// opening the store, so it works while another process has it open. The
+// result reflects the last change that process saved.
+func ReadDownloads(path string) ([]*downloader.Download, error) {
+	s := &Store{
+		path:   path,
+		latest: make(map[string]json.RawMessage),
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	return s.Downloads()
+}
+
+// replay rebuilds the current state from the journal. A torn last line, left
+// by a crash in the middle of an append, is skipped and marks the journal as
+// damaged.
+func (s *Store) replay() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// Even a readable last line is torn if its newline is missing
+		s.damaged = true
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.ID == "" {
+			logger.LogDownloadError("STORE", "", fmt.Sprintf("Skipping unreadable journal record at line %d", line))
+			s.damaged = true
+			continue
+		}
+		s.apply(r)
+		s.records++
+	}
+	return scanner.Err()
+}
+
+// apply updates the in-memory state with one record. The caller must hold
+// s.mutex or have exclusive access to s.
+func (s *Store) apply(r record) {
+	switch r.Op {
+	case opPut:
+		if _, exists := s.latest[r.ID]; !exists {
+			s.order = append(s.order, r.ID)
+		}
+		s.latest[r.ID] = r.Download
+	case opDelete:
+		if _, exists := s.latest[r.ID]; !exists {
+			return
+		}
+		delete(s.latest, r.ID)
+		for i, id := range s.order {
+			if id == r.ID {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Downloads decodes the stored downloads, in the order they were added
+func (s *Store) Downloads() ([]*downloader.Download, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	downloads := make([]*downloader.Download, 0, len(s.order))
+	for _, id := range s.order {
+		var d downloader.Download
+		if err := json.Unmarshal(s.latest[id], &d); err != nil {
+			return nil, fmt.Errorf("failed to decode download %s: %w", id, err)
+		}
+		downloads = append(downloads, &d)
+	}
+	return downloads, nil
+}
+
+// Has reports whether a download with the given ID is stored
+func (s *Store) Has(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.latest[id]
+	return exists
+}
+
+// Put records the current state of a download
+func (s *Store) Put(d *downloader.Download) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.append(record{Op: opPut, ID: d.ID, Download: data})
+}
+
+// Delete records that a download was removed
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.latest[id]; !exists {
+		return nil
+	}
+	return s.append(record{Op: opDelete, ID: id})
+}
+
+// append writes a record to the journal and applies it, compacting the
+// journal when it has grown too large. The caller must hold s.mutex.
+func (s *Store) append(r record) error {
+	if s.file == nil {
+		return fmt.Errorf("download store is closed")
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.apply(r)
+	s.records++
+
+	if s.records > 2*len(s.latest)+compactSlack {
+		return s.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the journal with a single record per stored download
+func (s *Store) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("download store is closed")
+	}
+	return s.compact()
+}
+
+// compact rewrites the journal atomically and reopens it for appending.
+// The caller must hold s.mutex.
+func (s *Store) compact() error {
+	var buf bytes.Buffer
+	for _, id := range s.order {
+		line, err := json.Marshal(record{Op: opPut, ID: id, Download: s.latest[id]})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	// The old handle points at the replaced file
+	s.file.Close()
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.file = nil
+		return err
+	}
+	s.file = file
+	s.records = len(s.order)
+	logger.LogDownloadEvent("STORE", fmt.Sprintf("Compacted download journal to %d records", s.records))
+	return nil
+}
+
+// Close closes the journal file and releases the store to other processes
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The journal may already be closed after a failed compaction
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	if s.lock != nil {
+		s.lock.Close()
+		s.lock = nil
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+)
+
+func newDownload(id string) *downloader.Download {
+	d := downloader.New("http://example.com/"+id, "/tmp/"+id, "default", 0, time.Time{})
+	d.ID = id
+	return d
+}
+
+// open opens the store at path and closes it when the test ends
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// ids returns the IDs of the downloads in s, in order
+func ids(t *testing.T, s *Store) []string {
+	t.Helper()
+	downloads, err := s.Downloads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, d := range downloads {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func expectIDs(t *testing.T, s *Store, want ...string) {
+	t.Helper()
+	if got := ids(t, s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("downloads %v, want %v", got, want)
+	}
+}
+
+// countLines returns the number of records in the journal at path
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downloads.jsonl")
+	s := open(t, path)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Put(newDownload(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated := newDownload("a")
+	updated.Downloaded = 42
+	if err := s.Put(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, path)
+	expectIDs(t, s, "a", "b", "c")
+	downloads, _ := s.Downloads()
+	if downloads[0].Downloaded != 42 {
+		t.Errorf("a has %d bytes, want the latest state's 42", downloads[0].Downloaded)
+	}
+	if !s.Has("b") || s.Has("z") {
+		t.Errorf("Has(b) = %v, Has(z) = %v", s.Has("b"), s.Has("z"))
+	}
+}
+
+func TestDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downloads.jsonl")
+	s := open(t, path)
+	for _, id := range []string{"a", "b", "c"} {
+		s.Put(newDownload(id))
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	lines := countLines(t, path)
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := countLines(t, path); got != lines {
+		t.Errorf("deleting a missing download wrote %d records", got-lines)
+	}
+	expectIDs(t, s, "a", "c")
+
+	// A download put again after its deletion counts as added last
+	s.Put(newDownload("b"))
+	s.Close()
+	expectIDs(t, open(t, path), "a", "c", "b")
+}
+
+func TestTornTail(t *testing.T) {
+	whole, err := json.Marshal(newDownload("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tail string
+		want []string
+	}{
+		{"half a record", `{"op":"put","id":"c","downl`, []string{"a", "b", "d"}},
+		{"record without newline", `{"op":"put","id":"c","download":` + string(whole) + `}`, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "downloads.jsonl")
+			s := open(t, path)
+			s.Put(newDownload("a"))
+			s.Put(newDownload("b"))
+			s.Close()
+
+			// A crash in the middle of an append
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			s = open(t, path)
+			if err := s.Put(newDownload("d")); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+
+			// The record appended after the torn line survives
+			expectIDs(t, open(t, path), tt.want...)
+		})
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "downloads.jsonl")
+	s := open(t, path)
+	s.Put(newDownload("a"))
+	s.Put(newDownload("b"))
+
+	// The journal may hold 2*2+compactSlack records before it is compacted
+	limit := 2*2 + compactSlack
+	for countLines(t, path) < limit {
+		if err := s.Put(newDownload("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := countLines(t, path); got != limit {
+		t.Fatalf("journal has %d records, want %d before compaction", got, limit)
+	}
+	if err := s.Put(newDownload("b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := countLines(t, path); got != 2 {
+		t.Fatalf("journal has %d records after compaction, want 2", got)
+	}
+
+	// Appends go to the compacted journal
+	s.Put(newDownload("c"))
+	s.Close()
+	if got := countLines(t, path); got != 3 {
+		t.Fatalf("journal has %d records, want 3", got)
+	}
+	expectIDs(t, open(t, path), "a", "b", "c")
+}
+
+func TestClosedStore(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "downloads.jsonl"))
+	s.Close()
+	if err := s.Put(newDownload("a")); err == nil {
+		t.Error("Put on a closed store succeeded")
+	}
+	if err := s.Compact(); err == nil {
+		t.Error("Compact on a closed store succeeded")
+	}
+}
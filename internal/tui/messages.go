@@ -1,15 +1,18 @@
 package tui
 
+import "github.com/mahdiXak47/Download-Manager/internal/events"
+
 // Custom messages for our application
 type StartDownloadMsg struct {
 	URL   string
+	URLs  []string // The URLs a pattern in URL expanded to, added as a group
 	Queue string
 }
 
 type TickMsg struct{}
 
 type DownloadProgressMsg struct {
-	URL      string
+	ID       string
 	Progress float64
 	Speed    int64
 }
@@ -17,3 +20,13 @@ type DownloadProgressMsg struct {
 type ErrorMsg struct {
 	Error error
 }
+
+// DownloadEventMsg carries a lifecycle event published by the queue manager
+type DownloadEventMsg struct {
+	Event events.Event
+}
+
+// ProfileSwitchedMsg carries the model of the profile that was switched to
+type ProfileSwitchedMsg struct {
+	Model Model
+}
@@ -1,21 +1,33 @@
 package tui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	// "net/http"
-	// "strings"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/daemon"
 	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
 	"github.com/mahdiXak47/Download-Manager/internal/network"
 	"github.com/mahdiXak47/Download-Manager/internal/queue"
+	"github.com/mahdiXak47/Download-Manager/internal/store"
+	"github.com/mahdiXak47/Download-Manager/internal/urllist"
 )
 
+// profileSwitchTimeout bounds how long the downloads of a profile get to
+// checkpoint when switching to another one
+const profileSwitchTimeout = 10 * time.Second
+
 // TabID represents different tabs in the application
 type TabID int
 
@@ -24,8 +36,22 @@ const (
 	DownloadListTab
 	QueueListTab
 	SettingsTab
+	HistoryTab
 )
 
+// historyRanges are the date filters the History tab cycles through. Days is
+// the number of calendar days included, counting today; 0 means all time.
+var historyRanges = []struct {
+	Label string
+	Days  int
+}{
+	{"All time", 0},
+	{"Today", 1},
+	{"Last 7 days", 7},
+	{"Last 30 days", 30},
+	{"Last 365 days", 365},
+}
+
 // Model represents the application state
 type Model struct {
 	// Core state
@@ -41,9 +67,27 @@ type Model struct {
 	AddDownloadMessage string // Message shown after an add download operation
 	AddDownloadSuccess bool   // Whether the last add was successful (for coloring)
 
+	// Import state
+	ImportMode       bool            // Whether the queue being selected is for importing a list
+	ImportPathMode   bool            // Whether we're typing the path of a list to import
+	InputImportPath  string          // Path of the list to import
+	ImportEntries    []urllist.Entry // Entries of the list being previewed, nil when not previewing
+	ImportSelected   int             // Entry the preview is scrolled to
+	ImportDuplicates bool            // Whether importing also adds duplicate entries
+
 	// Download List state
-	DownloadListMessage string // Message shown in the download list tab
-	DownloadListSuccess bool   // Whether the last download list operation was successful (for coloring)
+	DownloadListMessage string          // Message shown in the download list tab
+	DownloadListSuccess bool            // Whether the last download list operation was successful (for coloring)
+	Marked              map[string]bool // IDs of downloads marked for bulk operations
+	MoveQueueMode       bool            // Whether we're picking a target queue for moving downloads
+	MoveRelocate        bool            // Whether moving also relocates files to the target queue's path
+
+	// History state
+	HistoryEntries    []history.Entry // Entries matching the current search and date filter, newest first
+	HistorySelected   int             // Currently selected history entry
+	HistoryQuery      string          // Text search applied to the history
+	HistorySearchMode bool            // Whether we're typing a history search
+	HistoryRange      int             // Index into historyRanges
 
 	// Input fields
 	InputURL   string
@@ -64,9 +108,11 @@ type Model struct {
 	InputScheduledStartTime string // New field for scheduled start time
 
 	// Data
-	Downloads    []downloader.Download
-	Config       *config.Config
-	QueueManager *queue.Manager
+	Downloads    []downloader.Snapshot // Latest state published by QueueManager
+	Config       *config.Config        // Copy of QueueManager's settings, refreshed when they change
+	QueueManager queue.Service         // A manager in this process, or a client of a daemon
+	Attached     bool                  // Whether QueueManager is a client of a daemon
+	Events       *events.Subscription  // Download lifecycle events from QueueManager
 	ErrorMessage string
 
 	// UI State
@@ -83,7 +129,16 @@ type Model struct {
 	PopupVisible bool
 	PopupType    string // "error", "success", "info"
 
-	NetworkMonitor *network.Monitor
+	// Profile state
+	Profile          string   // Name of the active profile
+	Profiles         []string // Known profiles, listed in the Settings tab
+	ProfileSelected  int      // Currently selected profile
+	ProfileInputMode bool     // Whether we're typing the name of a new profile
+	InputProfile     string   // Name of the new profile being typed
+	SwitchingProfile bool     // Whether the previous profile is still shutting down
+
+	NetworkMonitor *network.Monitor // Only set when the downloads run in this process
+	NetworkDown    bool             // Whether the network was reported down
 }
 
 // NewModel creates and initializes a new model
@@ -91,31 +146,59 @@ func NewModel() Model {
 	// Load config
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		return Model{
-			ActiveTab:    DownloadListTab,
-			Menu:         "list",
-			Downloads:    make([]downloader.Download, 0),
-			Selected:     0,
-			Width:        80,
-			Height:       24,
-			ErrorMessage: "Failed to load config: " + err.Error(),
+		return newErrorModel("Failed to load config: " + err.Error())
+	}
+
+	// Attach to a daemon serving this profile, or run the downloads here
+	var queueManager queue.Service
+	var networkMonitor *network.Monitor
+	client, err := daemon.Dial(config.GetSocketPath())
+	switch {
+	case err == nil:
+		queueManager = client
+		cfg = client.Config()
+	case errors.Is(err, daemon.ErrNotRunning):
+		manager, err := queue.Open(cfg)
+		if err != nil {
+			message := "Could not load downloads: " + err.Error()
+			if errors.Is(err, store.ErrLocked) {
+				message += "\nIs another download-manager running with this profile?"
+			}
+			return newErrorModel(message)
 		}
+		manager.Start()
+		manager.WatchConfig(config.GetConfigPath())
+
+		// Pause downloads while the network is down, checking every 2 seconds
+		networkMonitor = network.NewMonitor(2*time.Second, "google.com")
+		networkMonitor.Start()
+		manager.WatchNetwork(networkMonitor)
+
+		queueManager = manager
+		cfg = manager.Config()
+	default:
+		return newErrorModel(err.Error())
 	}
 
-	// Create queue manager
-	queueManager := queue.NewManager(cfg)
-	queueManager.Start()
+	// Point out downloads left in queues that no longer exist
+	var errorMessage string
+	if err := queueManager.Validate(); err != nil {
+		errorMessage = err.Error()
+	}
 
-	// Initialize network monitor
-	networkMonitor := network.NewMonitor(2*time.Second, "google.com") // Check every 2 seconds
-	networkMonitor.Start()
+	profile, profiles := loadProfiles()
 
 	return Model{
+		Profile:            profile,
+		Profiles:           profiles,
+		ErrorMessage:       errorMessage,
 		ActiveTab:          DownloadListTab,
 		Menu:               "list",
-		Downloads:          cfg.Downloads,
+		Downloads:          queueManager.Snapshot(),
 		Config:             cfg,
 		QueueManager:       queueManager,
+		Attached:           client != nil,
+		Events:             queueManager.Events().Subscribe(256, events.DropOldest),
 		Selected:           0,
 		QueueSelected:      0,
 		QueueSelectionMode: false,
@@ -128,9 +211,164 @@ func NewModel() Model {
 	}
 }
 
+// newErrorModel returns a model that only shows an error message, for when
+// the application state couldn't be loaded
+func newErrorModel(message string) Model {
+	// Another profile can still be picked in the Settings tab
+	profile, profiles := loadProfiles()
+
+	return Model{
+		Profile:      profile,
+		Profiles:     profiles,
+		ActiveTab:    DownloadListTab,
+		Menu:         "list",
+		Downloads:    make([]downloader.Snapshot, 0),
+		Selected:     0,
+		Width:        80,
+		Height:       24,
+		ErrorMessage: message,
+	}
+}
+
+// loadProfiles returns the active profile and all known profiles
+func loadProfiles() (string, []string) {
+	profile := config.GetProfile()
+	profiles, err := config.ListProfiles()
+	if err != nil {
+		logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Failed to list profiles: %v", err))
+	}
+	for _, p := range profiles {
+		if p == profile {
+			return profile, profiles
+		}
+	}
+	// A new profile has no config file until it is saved
+	return profile, append(profiles, profile)
+}
+
+// switchProfile shuts down the current profile, pausing and checkpointing
+// its downloads, and loads the named one in the background. The new model
+// arrives as a ProfileSwitchedMsg.
+func (m Model) switchProfile(name string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), profileSwitchTimeout)
+		defer cancel()
+		if err := m.Shutdown(ctx); err != nil {
+			logger.LogDownloadError("CONFIG", "", fmt.Sprintf("Profile %s did not shut down cleanly: %v", m.Profile, err))
+		}
+
+		// The old profile is gone either way, so load whichever is active now
+		err := config.SetProfile(name)
+		next := NewModel()
+		if err != nil && next.ErrorMessage == "" {
+			next.ErrorMessage = err.Error()
+		}
+		return ProfileSwitchedMsg{Model: next}
+	}
+}
+
+// Shutdown stops the background workers and lets the queue manager pause and
+// checkpoint all active downloads before saving their state. When attached
+// to a daemon, it only detaches and the downloads keep running there.
+func (m Model) Shutdown(ctx context.Context) error {
+	if m.NetworkMonitor != nil {
+		m.NetworkMonitor.Stop()
+	}
+	if m.Events != nil {
+		m.Events.Close()
+	}
+	if m.QueueManager == nil {
+		return nil
+	}
+	return m.QueueManager.Shutdown(ctx)
+}
+
 // Init runs any initial IO
 func (m Model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(tickCmd(), waitForEvent(m.Events))
+}
+
+// waitForEvent delivers the next download event as a message
+func waitForEvent(sub *events.Subscription) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		e, ok := <-sub.Events()
+		if !ok {
+			return nil
+		}
+		return DownloadEventMsg{Event: e}
+	}
+}
+
+// RefreshDownloads replaces the rendered downloads with the queue manager's latest state
+func (m *Model) RefreshDownloads() {
+	if m.QueueManager == nil {
+		return
+	}
+	m.Downloads = m.QueueManager.Snapshot()
+	if m.Selected >= len(m.Downloads) {
+		m.Selected = len(m.Downloads) - 1
+	}
+	if m.Selected < 0 {
+		m.Selected = 0
+	}
+}
+
+// RefreshConfig replaces the rendered settings with the queue manager's current ones
+func (m *Model) RefreshConfig() {
+	if m.QueueManager == nil {
+		return
+	}
+	m.Config = m.QueueManager.Config()
+	if m.QueueSelected >= len(m.Config.Queues) {
+		m.QueueSelected = len(m.Config.Queues) - 1
+	}
+	if m.QueueSelected < 0 {
+		m.QueueSelected = 0
+	}
+}
+
+// RefreshHistory reloads the history entries matching the current search and date filter
+func (m *Model) RefreshHistory() {
+	if m.QueueManager == nil {
+		return
+	}
+	filter := history.Filter{Query: m.HistoryQuery}
+	if days := historyRanges[m.HistoryRange].Days; days > 0 {
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		filter.From = today.AddDate(0, 0, 1-days)
+	}
+	m.HistoryEntries = m.QueueManager.HistoryEntries(filter)
+	if m.HistorySelected >= len(m.HistoryEntries) {
+		m.HistorySelected = len(m.HistoryEntries) - 1
+	}
+	if m.HistorySelected < 0 {
+		m.HistorySelected = 0
+	}
+}
+
+// CycleHistoryRange switches the History tab to the next date filter
+func (m *Model) CycleHistoryRange() {
+	m.HistoryRange = (m.HistoryRange + 1) % len(historyRanges)
+	m.HistorySelected = 0
+	m.RefreshHistory()
+}
+
+// Redownload adds the URL of the selected history entry again
+func (m *Model) Redownload() {
+	if m.HistorySelected < 0 || m.HistorySelected >= len(m.HistoryEntries) {
+		return
+	}
+	entry := m.HistoryEntries[m.HistorySelected]
+	if _, err := m.QueueManager.Redownload(entry); err != nil {
+		m.ShowPopup(fmt.Sprintf("Error: %s", err.Error()), "error")
+		return
+	}
+	m.RefreshDownloads()
+	m.ShowPopup(fmt.Sprintf("Downloading %s again", filepath.Base(entry.Path)), "success")
 }
 
 // HandleInput processes text input when in input mode
@@ -221,77 +459,118 @@ func (m *Model) UpdateSize(width, height int) {
 }
 
 // AddDownload adds a new download to the model
-func (m *Model) AddDownload(url, queue string) {
-	if queue == "" {
-		queue = m.Config.DefaultQueue
+func (m *Model) AddDownload(url, queueName string) {
+	// Hand the download to the queue manager, which starts it when the queue has room
+	if _, err := m.QueueManager.Add(url, m.addOptions(queueName)); err != nil {
+		m.ErrorMessage = "Failed to add download: " + err.Error()
 	}
+	m.RefreshDownloads()
+}
 
-	// Get the queue configuration to set bandwidth limit
-	var maxBandwidth int64 = 0
-	for _, q := range m.Config.Queues {
-		if q.Name == queue {
-			maxBandwidth = q.SpeedLimit
-			break
+// AddDownloads adds a group of downloads, like the URLs a pattern expanded
+// to, and marks them in the download list so they can be handled together
+func (m *Model) AddDownloads(urls []string, queueName string) {
+	opts := m.addOptions(queueName)
+	marked := make(map[string]bool, len(urls))
+	failed := 0
+	var lastErr error
+	for _, url := range urls {
+		id, err := m.QueueManager.Add(url, opts)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
 		}
+		marked[id] = true
 	}
+	if lastErr != nil {
+		m.ErrorMessage = fmt.Sprintf("Failed to add %d of %d downloads: %v", failed, len(urls), lastErr)
+	}
+	m.Marked = marked
+	m.RefreshDownloads()
+}
 
-	// Get a proper target path from the URL
-	filename := filepath.Base(url)
-	queuePath := m.Config.SavePath // Default to the global SavePath
+// addOptions returns the options of downloads added to queueName. The queue
+// manager names the file after the URL and applies the queue's speed limit.
+func (m *Model) addOptions(queueName string) queue.AddOptions {
+	opts := queue.AddOptions{Queue: queueName}
+	if m.InputScheduledStartDate != "" && m.InputScheduledStartTime != "" {
+		opts.Start, _ = time.Parse("2006-01-02 15:04", m.InputScheduledStartDate+" "+m.InputScheduledStartTime)
+	}
+	return opts
+}
 
-	// Find the queue configuration
-	for _, q := range m.Config.Queues {
-		if q.Name == queue {
-			// If the queue has a configured Path, use it
-			if q.Path != "" {
-				queuePath = q.Path
-			}
-			break
+// PreviewImport reads the list at InputImportPath, marking entries that are
+// already downloaded as duplicates, and shows it for confirmation
+func (m *Model) PreviewImport() error {
+	path := m.InputImportPath
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
 		}
 	}
-
-	var targetPath string
-	if queuePath != "" {
-		targetPath = filepath.Join(queuePath, filename)
-	} else {
-		// Use current directory if no path specified
-		targetPath = filename
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	// Create and initialize download object
-	scheduledStartTime := time.Now() // Default to now
-	if m.InputScheduledStartDate != "" && m.InputScheduledStartTime != "" {
-		scheduledStartTime, _ = time.Parse("2006-01-02 15:04", m.InputScheduledStartDate+" "+m.InputScheduledStartTime)
+	known := make([]string, 0, len(m.Downloads))
+	for _, d := range m.Downloads {
+		known = append(known, d.URL)
 	}
-	download := downloader.New(url, targetPath, queue, maxBandwidth, scheduledStartTime)
-	m.Downloads = append(m.Downloads, *download)
-
-	// Add to queue manager's downloads map for tracking
-	m.QueueManager.ProcessDownload(url)
-
-	// Process all queues immediately to start the download
-	m.QueueManager.ProcessAllQueues()
-
-	// Set the start time
-	download.StartTime = time.Now()
+	entries, err := urllist.Parse(f, known)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s lists no URLs", m.InputImportPath)
+	}
+	m.ImportEntries = entries
+	m.ImportSelected = 0
+	m.ImportDuplicates = false
+	return nil
+}
 
-	// Update config with new download
-	if m.Config != nil {
-		m.Config.Downloads = m.Downloads
-		if err := config.SaveConfig(m.Config); err != nil {
-			m.ErrorMessage = "Failed to save config: " + err.Error()
+// ImportList adds the previewed entries to InputQueue, skipping invalid
+// ones and, unless ImportDuplicates is set, duplicates
+func (m *Model) ImportList() {
+	added, failed := 0, 0
+	var lastErr error
+	for _, e := range m.ImportEntries {
+		if e.Verdict == urllist.Invalid || (e.Verdict == urllist.Duplicate && !m.ImportDuplicates) {
+			continue
 		}
+		opts, err := e.AddOptions(m.InputQueue)
+		if err == nil {
+			_, err = m.QueueManager.Add(e.URL, opts)
+		}
+		if err != nil {
+			failed++
+			lastErr = fmt.Errorf("line %d: %w", e.Line, err)
+			continue
+		}
+		added++
 	}
+	m.RefreshDownloads()
+
+	skipped := len(m.ImportEntries) - added - failed
+	m.AddDownloadMessage = fmt.Sprintf("Imported %d download(s) to queue '%s', skipped %d", added, m.InputQueue, skipped)
+	m.AddDownloadSuccess = lastErr == nil
+	if lastErr != nil {
+		m.AddDownloadMessage = fmt.Sprintf("%s, %d failed (%v)", m.AddDownloadMessage, failed, lastErr)
+	}
+	m.ImportEntries = nil
+	m.InputImportPath = ""
 }
 
 // PauseDownload pauses the selected download
 func (m *Model) PauseDownload() {
 	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
-		if download.Status == "downloading" {
-			// Set completion time to zero if paused
-			download.CompletionTime = time.Time{}
-			m.QueueManager.PauseDownload(download.URL)
+		download := m.Downloads[m.Selected]
+		if download.Status == downloader.StatusDownloading {
+			m.QueueManager.PauseDownload(download.ID)
+			m.RefreshDownloads()
 			m.ShowPopup(fmt.Sprintf("Paused download: %s", download.Filename), "info")
 		} else {
 			m.ShowPopup("Can only pause downloads that are in progress", "error")
@@ -302,11 +581,10 @@ func (m *Model) PauseDownload() {
 // ResumeDownload resumes the selected download
 func (m *Model) ResumeDownload() {
 	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
-		if download.Status == "paused" {
-			// Reset start time when resuming
-			download.StartTime = time.Now()
-			m.QueueManager.ResumeDownload(download.URL)
+		download := m.Downloads[m.Selected]
+		if download.Status == downloader.StatusPaused {
+			m.QueueManager.ResumeDownload(download.ID)
+			m.RefreshDownloads()
 			m.ShowPopup(fmt.Sprintf("Resumed download: %s", download.Filename), "info")
 		} else {
 			m.ShowPopup("Can only resume downloads that are paused", "error")
@@ -319,34 +597,63 @@ func (m *Model) CancelDownload() {
 	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
 		download := m.Downloads[m.Selected]
 
-		// Set completion time if download is active
-		if download.Status == "downloading" || download.Status == "paused" {
-			download.CompletionTime = time.Now()
-			// Cancel the download if it's active
-			if download.Status == "downloading" || download.Status == "paused" {
-				download.Cancel()
+		// Only active downloads can be cancelled
+		if download.Status == downloader.StatusDownloading || download.Status == downloader.StatusPaused {
+			if err := m.QueueManager.CancelDownload(download.ID); err != nil {
+				m.ErrorMessage = "Failed to cancel download: " + err.Error()
 			}
+			m.RefreshDownloads()
+		}
+	}
+}
 
-			// Remove from queue manager
-			m.QueueManager.RemoveDownload(download.URL)
+// ToggleMark marks or unmarks the selected download for bulk operations
+func (m *Model) ToggleMark() {
+	if m.Selected < 0 || m.Selected >= len(m.Downloads) {
+		return
+	}
+	if m.Marked == nil {
+		m.Marked = make(map[string]bool)
+	}
+	id := m.Downloads[m.Selected].ID
+	if m.Marked[id] {
+		delete(m.Marked, id)
+	} else {
+		m.Marked[id] = true
+	}
+}
 
-			// Remove from downloads list
-			m.Downloads = append(m.Downloads[:m.Selected], m.Downloads[m.Selected+1:]...)
+// moveTargets returns the IDs of the marked downloads, or of the selected one if none are marked
+func (m *Model) moveTargets() []string {
+	var ids []string
+	for i := range m.Downloads {
+		if m.Marked[m.Downloads[i].ID] {
+			ids = append(ids, m.Downloads[i].ID)
+		}
+	}
+	if len(ids) == 0 && m.Selected >= 0 && m.Selected < len(m.Downloads) {
+		ids = append(ids, m.Downloads[m.Selected].ID)
+	}
+	return ids
+}
 
-			// Adjust selection if needed
-			if m.Selected >= len(m.Downloads) {
-				m.Selected = len(m.Downloads) - 1
-			}
+// MoveDownloads moves the marked (or selected) downloads to the given queue
+func (m *Model) MoveDownloads(queueName string) {
+	ids := m.moveTargets()
+	if len(ids) == 0 {
+		return
+	}
 
-			// Update config
-			if m.Config != nil {
-				m.Config.Downloads = m.Downloads
-				if err := config.SaveConfig(m.Config); err != nil {
-					m.ErrorMessage = "Failed to save config: " + err.Error()
-				}
-			}
-		}
+	err := m.QueueManager.MoveDownloads(ids, queueName, m.MoveRelocate)
+	m.RefreshDownloads()
+
+	if err != nil {
+		m.ShowPopup(fmt.Sprintf("Error moving downloads: %s", err.Error()), "error")
+		return
 	}
+
+	m.Marked = nil
+	m.ShowPopup(fmt.Sprintf("Moved %d download(s) to queue '%s'", len(ids), queueName), "success")
 }
 
 // CycleTheme switches to the next available theme
@@ -393,67 +700,68 @@ func (m *Model) CycleTheme() {
 	UpdateStyles()
 }
 
-// SaveQueueForm saves the current queue form values to the config
+// SaveQueueForm saves the current queue form values to the config. The
+// result is checked by the config validator and nothing is saved if it
+// finds a problem.
 func (m *Model) SaveQueueForm() error {
 	// Convert string inputs to appropriate types
-	maxConcurrent := 3 // Default
-	if m.InputQueueConcurrent != "" {
-		if val, err := strconv.Atoi(m.InputQueueConcurrent); err == nil && val > 0 {
-			maxConcurrent = val
-		}
-	}
-
-	var speedLimit int64 = 0 // Default - unlimited
-	if m.InputQueueSpeedLimit != "" {
-		if val, err := strconv.ParseInt(m.InputQueueSpeedLimit, 10, 64); err == nil && val >= 0 {
-			speedLimit = val
-		}
-	}
-
-	// Validate time formats
-	startTime := "00:00" // Default
-	if m.InputQueueStartTime != "" {
-		if _, err := time.Parse("15:04", m.InputQueueStartTime); err == nil {
-			startTime = m.InputQueueStartTime
-		}
+	maxConcurrent, err := strconv.Atoi(strings.TrimSpace(m.InputQueueConcurrent))
+	if err != nil {
+		return fmt.Errorf("max concurrent: %q is not a number", m.InputQueueConcurrent)
 	}
 
-	endTime := "23:59" // Default
-	if m.InputQueueEndTime != "" {
-		if _, err := time.Parse("15:04", m.InputQueueEndTime); err == nil {
-			endTime = m.InputQueueEndTime
-		}
+	speedLimit, err := strconv.ParseInt(strings.TrimSpace(m.InputQueueSpeedLimit), 10, 64)
+	if err != nil {
+		return fmt.Errorf("speed limit: %q is not a number", m.InputQueueSpeedLimit)
 	}
 
 	// Create the queue config
 	queue := config.QueueConfig{
-		Name:          m.InputQueueName,
+		Name:          strings.TrimSpace(m.InputQueueName),
 		Path:          m.InputQueuePath,
 		MaxConcurrent: maxConcurrent,
 		SpeedLimit:    speedLimit,
-		StartTime:     startTime,
-		EndTime:       endTime,
+		StartTime:     strings.TrimSpace(m.InputQueueStartTime),
+		EndTime:       strings.TrimSpace(m.InputQueueEndTime),
 		Enabled:       true,
 	}
 
-	// Check if we're editing an existing queue or creating a new one
-	found := false
-	for i, q := range m.Config.Queues {
-		if q.Name == m.InputQueueName {
-			// Update existing queue
-			m.Config.Queues[i] = queue
-			found = true
-			break
+	err = m.QueueManager.UpdateConfig(func(cfg *config.Config) {
+		// Check if we're editing an existing queue or creating a new one
+		if existing := cfg.GetQueue(queue.Name); existing != nil {
+			*existing = queue
+		} else {
+			cfg.Queues = append(cfg.Queues, queue)
 		}
+	})
+	if err != nil {
+		return err
 	}
+	m.RefreshConfig()
+	return nil
+}
 
-	if !found {
-		// Add new queue
-		m.Config.Queues = append(m.Config.Queues, queue)
+// DeleteQueue removes the selected queue from the config. The default queue
+// and queues that still have downloads are rejected by the config validator.
+func (m *Model) DeleteQueue() {
+	if m.QueueSelected < 0 || m.QueueSelected >= len(m.Config.Queues) {
+		return
 	}
+	name := m.Config.Queues[m.QueueSelected].Name
 
-	// Save config
-	return config.SaveConfig(m.Config)
+	err := m.QueueManager.UpdateConfig(func(cfg *config.Config) {
+		for i := range cfg.Queues {
+			if cfg.Queues[i].Name == name {
+				cfg.Queues = append(cfg.Queues[:i], cfg.Queues[i+1:]...)
+				break
+			}
+		}
+	})
+	if err != nil {
+		m.ShowPopup(fmt.Sprintf("Cannot delete queue '%s':\n%s", name, err.Error()), "error")
+		return
+	}
+	m.RefreshConfig()
 }
 
 // ShowPopup shows a popup message
@@ -473,69 +781,30 @@ func (m *Model) HidePopup() {
 // RetryDownload retries the selected download if it's in error state
 func (m *Model) RetryDownload() {
 	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
+		download := m.Downloads[m.Selected]
 
 		// Check if download is in error state
-		if download.Status == "error" {
+		if download.Status == downloader.StatusError {
 			// Check if retry count is less than max retries (3)
-			if download.GetRetryCount() < 3 {
-				// Retry the download
-				err := download.Retry()
+			if download.RetryCount < 3 {
+				// Retry the download; the queue manager starts it when the queue has room
+				err := m.QueueManager.RetryDownload(download.ID)
 				if err != nil {
 					m.ShowPopup(fmt.Sprintf("Error: %s", err.Error()), "error")
 				} else {
 					m.ShowPopup(fmt.Sprintf("Trying again to download file #%d (attempt %d of 3)",
-						m.Selected+1, download.GetRetryCount()), "success")
-
-					// Queue the download for processing
-					m.QueueManager.ProcessDownload(download.URL)
-
-					// Update config
-					if m.Config != nil {
-						if err := config.SaveConfig(m.Config); err != nil {
-							m.ShowPopup(fmt.Sprintf("Failed to save config: %s", err.Error()), "error")
-						}
-					}
+						m.Selected+1, download.RetryCount+1), "success")
 				}
 			} else {
 				// Max retries reached
 				m.ShowPopup("Error: Maximum retry attempts (3) reached for this download", "error")
 				// Reset retry count for future attempts
-				download.ResetRetryCount()
+				m.QueueManager.ResetRetryCount(download.ID)
 			}
+			m.RefreshDownloads()
 		} else {
 			// Not in error state
 			m.ShowPopup("Error: Only downloads in error state can be retried", "error")
 		}
 	}
 }
-
-// CheckNetworkStatus checks if the network is connected and updates the UI accordingly
-func (m *Model) CheckNetworkStatus() {
-	if m.NetworkMonitor == nil {
-		logger.LogDownloadEvent("NETWORK", "Network monitor is nil")
-		return
-	}
-
-	isConnected := m.NetworkMonitor.IsConnected()
-	logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Network status check: connected=%v", isConnected))
-
-	if !isConnected {
-		m.ShowPopup("Network connection lost. Downloads may be paused.", "error")
-		// Pause all active downloads when network is lost
-		for i := range m.Downloads {
-			if m.Downloads[i].Status == "downloading" {
-				logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Pausing download due to network loss: %s", m.Downloads[i].URL))
-				m.QueueManager.PauseDownload(m.Downloads[i].URL)
-			}
-		}
-	} else {
-		// Resume paused downloads when network is restored
-		for i := range m.Downloads {
-			if m.Downloads[i].Status == "paused" {
-				logger.LogDownloadEvent("NETWORK", fmt.Sprintf("Resuming download after network restore: %s", m.Downloads[i].URL))
-				m.QueueManager.ResumeDownload(m.Downloads[i].URL)
-			}
-		}
-	}
-}
@@ -220,7 +220,7 @@ func RenderProgressBar(width int, percent float64) string {
 // RenderStatus returns a styled status indicator
 func RenderStatus(status string) string {
 	switch status {
-	case "downloading":
+	case "downloading", "verifying", "post-processing":
 		return statusStyle.Copy().
 			Background(CurrentTheme.Special).
 			Foreground(lipgloss.Color(CurrentTheme.Background)).
@@ -8,6 +8,8 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/events"
+	"github.com/mahdiXak47/Download-Manager/internal/urlglob"
 )
 
 // Update handles all state updates
@@ -23,6 +25,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return handleStartDownload(m, msg)
 	case DownloadProgressMsg:
 		return handleProgress(m, msg)
+	case DownloadEventMsg:
+		return handleDownloadEvent(m, msg)
+	case ProfileSwitchedMsg:
+		return handleProfileSwitched(m, msg)
 	case ErrorMsg:
 		return handleError(m, msg)
 	}
@@ -37,6 +43,11 @@ func handleWindowSize(m Model, msg tea.WindowSizeMsg) (tea.Model, tea.Cmd) {
 
 // handleKeyPress handles all keyboard input
 func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// The previous profile is shutting down; its manager takes no more work
+	if m.SwitchingProfile {
+		return m, nil
+	}
+
 	// When in URL input mode, only handle Esc and Enter keys, pass everything else to text input handler
 	if m.URLInputMode {
 		switch msg.Type {
@@ -48,14 +59,22 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		case tea.KeyEnter:
 			// Validate and start download
 			if m.InputURL != "" {
-				// Check if the URL is valid
-				isValidURL := validateURL(m.InputURL)
-				if !isValidURL {
-					m.AddDownloadMessage = "Error: Invalid URL format"
+				// Expand [ranges] and {sets}, then check every URL
+				urls, err := urlglob.Expand(m.InputURL, urlglob.DefaultLimit)
+				if err != nil {
+					m.AddDownloadMessage = "Error: " + err.Error()
 					m.AddDownloadSuccess = false
 					m.URLInputMode = false
 					return m, nil
 				}
+				for _, u := range urls {
+					if !validateURL(u) {
+						m.AddDownloadMessage = "Error: Invalid URL format"
+						m.AddDownloadSuccess = false
+						m.URLInputMode = false
+						return m, nil
+					}
+				}
 
 				// Check if the queue has capacity
 				queueName := m.InputQueue
@@ -71,7 +90,7 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 					// Count active downloads in this queue
 					activeCount := 0
 					for _, d := range m.Downloads {
-						if d.Queue == queueName && d.Status == "downloading" {
+						if d.Queue == queueName && d.Status.IsActive() {
 							activeCount++
 						}
 					}
@@ -83,18 +102,17 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 						return m, nil
 					}
 
-					// Store URL before clearing it
-					url := m.InputURL
-
 					// All checks passed, start the download
+					msg := StartDownloadMsg{URL: urls[0], Queue: m.InputQueue}
+					m.AddDownloadMessage = fmt.Sprintf("Success: Download started in queue '%s'", queueName)
+					if len(urls) > 1 {
+						msg.URLs = urls
+						m.AddDownloadMessage = fmt.Sprintf("Success: %d downloads added to queue '%s' and marked in the download list", len(urls), queueName)
+					}
 					cmd := func() tea.Msg {
-						return StartDownloadMsg{
-							URL:   url,
-							Queue: m.InputQueue,
-						}
+						return msg
 					}
 
-					m.AddDownloadMessage = fmt.Sprintf("Success: Download started in queue '%s'", queueName)
 					m.AddDownloadSuccess = true
 					m.URLInputMode = false
 					m.InputURL = ""
@@ -140,24 +158,56 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 		case "enter":
 			if len(m.Config.Queues) > 0 {
-				// Select the queue and move to URL input
+				// Select the queue and move to URL or list path input
 				m.InputQueue = m.Config.Queues[m.QueueSelected].Name
 				m.QueueSelectionMode = false
-				m.URLInputMode = true
-				m.InputURL = ""
+				if m.ImportMode {
+					m.ImportMode = false
+					m.ImportPathMode = true
+					m.InputImportPath = ""
+				} else {
+					m.URLInputMode = true
+					m.InputURL = ""
+				}
 			}
 		case "esc":
 			// Cancel queue selection
 			m.QueueSelectionMode = false
+			m.ImportMode = false
 		}
 		return m, nil
 	}
 
+	// When typing the path of a list to import
+	if m.ImportPathMode {
+		return handleImportPathMode(m, msg)
+	}
+
+	// When previewing a list before importing it
+	if m.ImportEntries != nil {
+		return handleImportPreview(m, msg)
+	}
+
+	// When picking a target queue for moving downloads
+	if m.MoveQueueMode {
+		return handleMoveQueueMode(m, msg)
+	}
+
 	// When in Queue form mode, handle form input
 	if m.QueueFormMode {
 		return handleQueueFormInput(m, msg)
 	}
 
+	// When typing a history search
+	if m.HistorySearchMode {
+		return handleHistorySearchMode(m, msg)
+	}
+
+	// When typing the name of a new profile
+	if m.ProfileInputMode {
+		return handleProfileInputMode(m, msg)
+	}
+
 	// Handle global keys first (when not in any input mode)
 	switch msg.Type {
 	case tea.KeyCtrlC:
@@ -174,6 +224,13 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.AddDownloadSuccess = false
 			return m, nil
 		}
+		if m.ActiveTab == HistoryTab && m.HistoryQuery != "" {
+			// Clear the history search
+			m.HistoryQuery = ""
+			m.HistorySelected = 0
+			m.RefreshHistory()
+			return m, nil
+		}
 		return m, nil
 	}
 
@@ -200,6 +257,11 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.ActiveTab = SettingsTab
 		m.Menu = "settings"
 		return m, nil
+	case "5":
+		m.ActiveTab = HistoryTab
+		m.Menu = "history"
+		m.RefreshHistory()
+		return m, nil
 	case "q":
 		return m, tea.Quit
 	case "t":
@@ -217,6 +279,8 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return handleQueueListTab(m, msg)
 	case SettingsTab:
 		return handleSettingsTab(m, msg)
+	case HistoryTab:
+		return handleHistoryTab(m, msg)
 	}
 
 	return m, nil
@@ -282,7 +346,6 @@ func handleNavigationMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "s":
 		if m.Menu == "list" {
 			m.ResumeDownload()
-			return m, tickCmd()
 		}
 	case "c":
 		if m.Menu == "list" {
@@ -307,22 +370,18 @@ func handleNavigationMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 // handleStartDownload processes a new download request
 func handleStartDownload(m Model, msg StartDownloadMsg) (tea.Model, tea.Cmd) {
-	m.AddDownload(msg.URL, msg.Queue)
-
-	// Custom command to help with UI refresh after adding a download
-	var cmd tea.Cmd = func() tea.Msg {
-		// Wait briefly for download to start
-		time.Sleep(300 * time.Millisecond)
-		return TickMsg{}
+	if len(msg.URLs) > 0 {
+		m.AddDownloads(msg.URLs, msg.Queue)
+		return m, nil
 	}
-
-	return m, cmd
+	m.AddDownload(msg.URL, msg.Queue)
+	return m, nil
 }
 
 // handleProgress updates download progress
 func handleProgress(m Model, msg DownloadProgressMsg) (tea.Model, tea.Cmd) {
 	for i, d := range m.Downloads {
-		if d.URL == msg.URL {
+		if d.ID == msg.ID {
 			m.Downloads[i].Progress = msg.Progress
 			m.Downloads[i].Speed = msg.Speed
 			break
@@ -337,24 +396,39 @@ func handleError(m Model, msg ErrorMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-// Handles periodic updates (e.g., checking for active downloads).
-func handleTick(m Model) (tea.Model, tea.Cmd) {
-	// Check network status
-	m.CheckNetworkStatus()
-
-	// Update active downloads
-	hasActive := false
-	for _, d := range m.Downloads {
-		if d.Status == "downloading" || d.Status == "paused" {
-			hasActive = true
-			break
-		}
+// handleDownloadEvent re-renders from the queue manager's state whenever a download changes
+func handleDownloadEvent(m Model, msg DownloadEventMsg) (tea.Model, tea.Cmd) {
+	switch msg.Event.Type {
+	case events.ConfigReloaded:
+		m.ErrorMessage = ""
+		m.RefreshConfig()
+		m.ShowPopup("Settings reloaded from disk:\n"+msg.Event.Message, "info")
+	case events.ConfigUpdated:
+		// Also changes made by other clients of the same daemon
+		m.RefreshConfig()
+	case events.ConfigRejected:
+		m.ErrorMessage = "Config file changed but was not applied: " + msg.Event.Error
+	case events.NetworkLost:
+		m.NetworkDown = true
+		m.ShowPopup("Network connection lost. Downloads may be paused.", "error")
+	case events.NetworkRestored:
+		m.NetworkDown = false
+	case events.Disconnected:
+		m.ErrorMessage = "Lost the connection to the download daemon: " + msg.Event.Error + "\nRestart download-manager to continue."
 	}
+	m.RefreshDownloads()
+	return m, waitForEvent(m.Events)
+}
 
-	if hasActive {
-		return m, tickCmd()
+// Handles periodic updates (e.g., refreshing the history).
+func handleTick(m Model) (tea.Model, tea.Cmd) {
+	// Downloads are added to the history some time after their final event,
+	// once their files are checksummed, so poll while the history is shown
+	if m.ActiveTab == HistoryTab {
+		m.RefreshHistory()
 	}
-	return m, nil
+
+	return m, tickCmd()
 }
 
 // Schedules a periodic update
@@ -383,6 +457,18 @@ func handleAddDownloadTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.AddDownloadMessage = "Error: No queues configured. Please create a queue first."
 				m.AddDownloadSuccess = false
 			}
+		case "i":
+			// Pick the queue first, then the list to import into it
+			if len(m.Config.Queues) > 0 {
+				m.QueueSelectionMode = true
+				m.ImportMode = true
+				m.QueueSelected = 0
+				m.AddDownloadMessage = ""
+				m.AddDownloadSuccess = false
+			} else {
+				m.AddDownloadMessage = "Error: No queues configured. Please create a queue first."
+				m.AddDownloadSuccess = false
+			}
 		case "esc":
 			// Also clear any message when ESC is pressed in this context
 			m.AddDownloadMessage = ""
@@ -393,6 +479,53 @@ func handleAddDownloadTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// handleImportPathMode handles typing the path of a list to import
+func handleImportPathMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		if m.InputImportPath == "" {
+			return m, nil
+		}
+		m.ImportPathMode = false
+		if err := m.PreviewImport(); err != nil {
+			m.AddDownloadMessage = "Error: " + err.Error()
+			m.AddDownloadSuccess = false
+		}
+	case tea.KeyEsc:
+		m.ImportPathMode = false
+		m.InputImportPath = ""
+	case tea.KeyBackspace:
+		if len(m.InputImportPath) > 0 {
+			m.InputImportPath = m.InputImportPath[:len(m.InputImportPath)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.InputImportPath += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// handleImportPreview handles keys while a list is previewed before importing
+func handleImportPreview(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.ImportSelected > 0 {
+			m.ImportSelected--
+		}
+	case "down", "j":
+		if m.ImportSelected < len(m.ImportEntries)-1 {
+			m.ImportSelected++
+		}
+	case "d":
+		m.ImportDuplicates = !m.ImportDuplicates
+	case "enter":
+		m.ImportList()
+	case "esc":
+		m.ImportEntries = nil
+		m.InputImportPath = ""
+	}
+	return m, nil
+}
+
 // handleDownloadListTab handles keys for the Download List tab
 func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
@@ -408,12 +541,20 @@ func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.PauseDownload()
 	case "r":
 		m.ResumeDownload()
-		return m, tickCmd()
 	case "c":
 		m.CancelDownload()
 	case "y":
 		// Retry the selected download if it's in error state
 		m.RetryDownload()
+	case " ":
+		// Mark the selected download for moving
+		m.ToggleMark()
+	case "m":
+		// Pick a queue to move the marked (or selected) downloads to
+		if len(m.Downloads) > 0 && len(m.Config.Queues) > 0 {
+			m.MoveQueueMode = true
+			m.QueueSelected = 0
+		}
 	case "a":
 		// Switch to Add Download tab
 		m.ActiveTab = AddDownloadTab
@@ -421,12 +562,8 @@ func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "d":
 		// Delete the selected download
 		if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-			selectedDownload := m.Downloads[m.Selected]
-			m.QueueManager.RemoveDownload(selectedDownload.URL)
-			m.Downloads = append(m.Downloads[:m.Selected], m.Downloads[m.Selected+1:]...)
-			if m.Selected >= len(m.Downloads) {
-				m.Selected = len(m.Downloads) - 1
-			}
+			m.QueueManager.RemoveDownload(m.Downloads[m.Selected].ID)
+			m.RefreshDownloads()
 		}
 	case "esc":
 		// Clear any messages
@@ -439,6 +576,36 @@ func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// handleMoveQueueMode handles keys while picking the target queue for a move
+func handleMoveQueueMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.QueueSelected > 0 {
+			m.QueueSelected--
+		} else if len(m.Config.Queues) > 0 {
+			m.QueueSelected = len(m.Config.Queues) - 1
+		}
+	case "down", "j":
+		if m.QueueSelected < len(m.Config.Queues)-1 {
+			m.QueueSelected++
+		} else {
+			m.QueueSelected = 0
+		}
+	case "f":
+		// Toggle moving files into the target queue's path
+		m.MoveRelocate = !m.MoveRelocate
+	case "enter":
+		if m.QueueSelected >= 0 && m.QueueSelected < len(m.Config.Queues) {
+			m.MoveDownloads(m.Config.Queues[m.QueueSelected].Name)
+		}
+		m.MoveQueueMode = false
+	case "esc":
+		// Cancel the move
+		m.MoveQueueMode = false
+	}
+	return m, nil
+}
+
 // handleQueueListTab handles keys for the Queue List tab
 func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	if m.QueueFormMode {
@@ -457,9 +624,13 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				// Move to next field
 				m.QueueFormField++
 			} else {
-				// Submit form
-				m.SaveQueueForm()
-				m.QueueFormMode = false
+				// Submit form, keeping it open if the values are invalid
+				if err := m.SaveQueueForm(); err != nil {
+					m.ErrorMessage = fmt.Sprintf("Error saving queue: %v", err)
+				} else {
+					m.ErrorMessage = ""
+					m.QueueFormMode = false
+				}
 			}
 		default:
 			// Handle text input
@@ -504,16 +675,7 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 	case "d":
 		// Delete queue
-		if m.QueueSelected >= 0 && m.QueueSelected < len(m.Config.Queues) {
-			// Don't delete default queue
-			if m.Config.Queues[m.QueueSelected].Name != m.Config.DefaultQueue {
-				m.Config.Queues = append(m.Config.Queues[:m.QueueSelected], m.Config.Queues[m.QueueSelected+1:]...)
-				if m.QueueSelected >= len(m.Config.Queues) {
-					m.QueueSelected = len(m.Config.Queues) - 1
-				}
-				config.SaveConfig(m.Config)
-			}
-		}
+		m.DeleteQueue()
 	}
 
 	return m, nil
@@ -522,6 +684,125 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 // handleSettingsTab handles keys for the Settings tab
 func handleSettingsTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	// No need to handle 't' here as it's handled globally
+	switch msg.String() {
+	case "up", "k":
+		if m.ProfileSelected > 0 {
+			m.ProfileSelected--
+		}
+	case "down", "j":
+		if m.ProfileSelected < len(m.Profiles)-1 {
+			m.ProfileSelected++
+		}
+	case "enter":
+		// Switch to the selected profile
+		if m.ProfileSelected >= 0 && m.ProfileSelected < len(m.Profiles) {
+			return startProfileSwitch(m, m.Profiles[m.ProfileSelected])
+		}
+	case "n":
+		// Create a new profile
+		m.ProfileInputMode = true
+		m.InputProfile = ""
+	}
+	return m, nil
+}
+
+// handleProfileInputMode handles keys while typing the name of a new profile
+func handleProfileInputMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		name := strings.TrimSpace(m.InputProfile)
+		if err := config.ValidateProfileName(name); err != nil {
+			m.ShowPopup(err.Error(), "error")
+			return m, nil
+		}
+		m.ProfileInputMode = false
+		m.InputProfile = ""
+		return startProfileSwitch(m, name)
+	case tea.KeyEsc:
+		m.ProfileInputMode = false
+		m.InputProfile = ""
+	case tea.KeyBackspace:
+		if len(m.InputProfile) > 0 {
+			m.InputProfile = m.InputProfile[:len(m.InputProfile)-1]
+		}
+	case tea.KeyRunes:
+		m.InputProfile += string(msg.Runes)
+	}
+	return m, nil
+}
+
+// startProfileSwitch begins switching to the named profile unless it is already active
+func startProfileSwitch(m Model, name string) (tea.Model, tea.Cmd) {
+	if name == m.Profile {
+		return m, nil
+	}
+	m.SwitchingProfile = true
+	m.ShowPopup(fmt.Sprintf("Switching to profile '%s'...", name), "info")
+	return m, m.switchProfile(name)
+}
+
+// handleProfileSwitched replaces the model with the one of the new profile,
+// keeping the window and appearance
+func handleProfileSwitched(m Model, msg ProfileSwitchedMsg) (tea.Model, tea.Cmd) {
+	next := msg.Model
+	next.Width = m.Width
+	next.Height = m.Height
+	next.CurrentTheme = m.CurrentTheme
+	next.ActiveTab = SettingsTab
+	next.Menu = "settings"
+	for i, p := range next.Profiles {
+		if p == next.Profile {
+			next.ProfileSelected = i
+		}
+	}
+	next.ShowPopup(fmt.Sprintf("Switched to profile '%s'", next.Profile), "success")
+	return next, waitForEvent(next.Events)
+}
+
+// handleHistoryTab handles keys for the History tab
+func handleHistoryTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if m.HistorySelected > 0 {
+			m.HistorySelected--
+		}
+	case "down", "j":
+		if m.HistorySelected < len(m.HistoryEntries)-1 {
+			m.HistorySelected++
+		}
+	case "/":
+		// Start typing a search
+		m.HistorySearchMode = true
+	case "f":
+		// Cycle the date filter
+		m.CycleHistoryRange()
+	case "r":
+		// Download the selected entry again
+		m.Redownload()
+	}
+	return m, nil
+}
+
+// handleHistorySearchMode handles keys while typing a history search. The
+// list is filtered as the query changes.
+func handleHistorySearchMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.HistorySearchMode = false
+	case tea.KeyEsc:
+		m.HistorySearchMode = false
+		m.HistoryQuery = ""
+	case tea.KeyBackspace:
+		if len(m.HistoryQuery) > 0 {
+			m.HistoryQuery = m.HistoryQuery[:len(m.HistoryQuery)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.HistoryQuery += string(msg.Runes)
+	default:
+		return m, nil
+	}
+	m.HistorySelected = 0
+	m.RefreshHistory()
 	return m, nil
 }
 
@@ -541,17 +822,18 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			// Move to next field
 			m.QueueFormField++
 		} else {
-			// Submit form
+			// Submit form, keeping it open if the values are invalid
 			if err := m.SaveQueueForm(); err != nil {
 				m.ErrorMessage = fmt.Sprintf("Error saving queue: %v", err)
 			} else {
 				m.ErrorMessage = ""
+				m.QueueFormMode = false
 			}
-			m.QueueFormMode = false
 		}
 	case "esc":
 		// Cancel form
 		m.QueueFormMode = false
+		m.ErrorMessage = ""
 		m.InputQueueName = ""
 		m.InputQueuePath = ""
 		m.InputQueueConcurrent = ""
@@ -613,28 +895,23 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 func validateURL(urlStr string) bool {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
-		fmt.Println("Error parsing URL:", err)
 		return false
 	}
 
 	// Check scheme
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		fmt.Println("Invalid URL: Scheme must be http or https")
 		return false
 	}
 
 	// Check host
 	if parsedURL.Host == "" {
-		fmt.Println("Invalid URL: Missing host")
 		return false
 	}
 
 	// Optionally, check if host contains at least one dot
 	if !strings.Contains(parsedURL.Host, ".") {
-		fmt.Println("Invalid URL: Host does not look valid")
 		return false
 	}
 
-	fmt.Println("URL is valid")
 	return true
 }
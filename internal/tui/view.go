@@ -3,9 +3,13 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/history"
 	"github.com/mahdiXak47/Download-Manager/internal/tui/styles"
+	"github.com/mahdiXak47/Download-Manager/internal/urllist"
 )
 
 func (m Model) View() string {
@@ -13,7 +17,14 @@ func (m Model) View() string {
 	mainContainer := containerStyle.Width(m.Width - 4)
 
 	// Header with app name and version
-	header := titleStyle.Width(m.Width - 8).Render("Download Manager v0.1")
+	title := "Download Manager v0.1"
+	if m.Profile != "" && m.Profile != config.DefaultProfile {
+		title += " [" + m.Profile + "]"
+	}
+	if m.Attached {
+		title += " (daemon)"
+	}
+	header := titleStyle.Width(m.Width - 8).Render(title)
 
 	// Build the content
 	var content strings.Builder
@@ -35,6 +46,8 @@ func (m Model) View() string {
 		tabContent = renderQueueListTab(m)
 	case SettingsTab:
 		tabContent = renderSettingsTab(m)
+	case HistoryTab:
+		tabContent = renderHistoryTab(m)
 	}
 	content.WriteString("\n" + tabContent)
 
@@ -79,16 +92,17 @@ func (m Model) View() string {
 	return mainContainer.Render(content.String())
 }
 
-// renderTabBar creates the tab bar with number keys 1-4
+// renderTabBar creates the tab bar with number keys 1-5
 func renderTabBar(m Model) string {
 	width := m.Width - 12 // Account for margins/paddings
-	tabWidth := width / 4
+	tabWidth := width / 5
 
 	// Create tab styles based on active tab
 	tab1Style := tabStyle.Copy().Width(tabWidth)
 	tab2Style := tabStyle.Copy().Width(tabWidth)
 	tab3Style := tabStyle.Copy().Width(tabWidth)
 	tab4Style := tabStyle.Copy().Width(tabWidth)
+	tab5Style := tabStyle.Copy().Width(tabWidth)
 
 	// Highlight active tab with more distinctive styling
 	switch m.ActiveTab {
@@ -100,6 +114,8 @@ func renderTabBar(m Model) string {
 		tab3Style = activeTabStyle.Copy().Width(tabWidth)
 	case SettingsTab:
 		tab4Style = activeTabStyle.Copy().Width(tabWidth)
+	case HistoryTab:
+		tab5Style = activeTabStyle.Copy().Width(tabWidth)
 	}
 
 	// Render tabs with number key indicators
@@ -107,8 +123,9 @@ func renderTabBar(m Model) string {
 	tab2 := tab2Style.Render("2: Download List")
 	tab3 := tab3Style.Render("3: Queues List")
 	tab4 := tab4Style.Render("4: Settings")
+	tab5 := tab5Style.Render("5: History")
 
-	return lipgloss.JoinHorizontal(lipgloss.Top, tab1, tab2, tab3, tab4)
+	return lipgloss.JoinHorizontal(lipgloss.Top, tab1, tab2, tab3, tab4, tab5)
 }
 
 func renderAddDownloadTab(m Model) string {
@@ -137,7 +154,11 @@ func renderAddDownloadTab(m Model) string {
 
 	// Queue selection first
 	if m.QueueSelectionMode {
-		s.WriteString(centerContainer.Render(menuHeaderStyle.Render("Select Download Queue")))
+		heading := "Select Download Queue"
+		if m.ImportMode {
+			heading = "Select Queue to Import Into"
+		}
+		s.WriteString(centerContainer.Render(menuHeaderStyle.Render(heading)))
 		s.WriteString("\n\n")
 
 		// Available queues
@@ -153,7 +174,7 @@ func renderAddDownloadTab(m Model) string {
 
 			activeCount := 0
 			for _, d := range m.Downloads {
-				if d.Queue == q.Name && d.Status == "downloading" {
+				if d.Queue == q.Name && d.Status.IsActive() {
 					activeCount++
 				}
 			}
@@ -176,14 +197,97 @@ func renderAddDownloadTab(m Model) string {
 		s.WriteString(centerContainer.Render(inputBoxStyle.Render(
 			menuItemStyle.Render("URL: " + urlStyle.Render(m.InputURL+"_")),
 		)))
+		s.WriteString("\n\n" + centerContainer.Render(menuItemStyle.Render("Ranges like [001-120] and sets like {a,b,c} add a group of downloads")))
 
 		// Help text for input mode
 		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Enter ] Start Download   [ Esc ] Back"))
+	} else if m.ImportPathMode {
+		s.WriteString(centerContainer.Render(menuHeaderStyle.Render("Import a List of URLs")))
+		s.WriteString("\n\n")
+		s.WriteString(centerContainer.Render(menuItemStyle.Render("Selected Queue: " + urlStyle.Render(m.InputQueue))))
+		s.WriteString("\n\n")
+		s.WriteString(centerContainer.Render(inputBoxStyle.Render(
+			menuItemStyle.Render("File: " + urlStyle.Render(m.InputImportPath+"_")),
+		)))
+		s.WriteString("\n\n" + centerContainer.Render(menuItemStyle.Render("One URL per line, or a wget or aria2 input file")))
+		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Enter ] Preview   [ Esc ] Back"))
+	} else if m.ImportEntries != nil {
+		s.WriteString(renderImportPreview(m))
 	} else {
 		// Initial instructions
-		s.WriteString(centerContainer.Render(menuItemStyle.Render("Press Enter to add a new download")))
-		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Enter ] Start   [ Esc ] Back"))
+		s.WriteString(centerContainer.Render(menuItemStyle.Render("Press Enter to add a new download, or i to import a list of URLs")))
+		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Enter ] Start   [ i ] Import List   [ Esc ] Back"))
+	}
+
+	return s.String()
+}
+
+// importPreviewRows is how many entries of a list the import preview shows
+const importPreviewRows = 10
+
+// renderImportPreview shows the entries of a list before they are imported
+func renderImportPreview(m Model) string {
+	var s strings.Builder
+
+	centerContainer := centerStyle.Copy().Width(m.Width - 8)
+
+	counts := urllist.Count(m.ImportEntries)
+	adding := counts[urllist.New]
+	if m.ImportDuplicates {
+		adding += counts[urllist.Duplicate]
 	}
+	s.WriteString(centerContainer.Render(menuHeaderStyle.Render(fmt.Sprintf("Import %d of %d Entries into '%s'", adding, len(m.ImportEntries), m.InputQueue))))
+	s.WriteString("\n\n")
+	s.WriteString(centerContainer.Render(menuItemStyle.Render(fmt.Sprintf("%d new   %d duplicate   %d invalid",
+		counts[urllist.New], counts[urllist.Duplicate], counts[urllist.Invalid]))))
+	s.WriteString("\n\n")
+
+	// Keep the selected entry in view
+	start := 0
+	if m.ImportSelected >= importPreviewRows {
+		start = m.ImportSelected - importPreviewRows + 1
+	}
+	end := start + importPreviewRows
+	if end > len(m.ImportEntries) {
+		end = len(m.ImportEntries)
+	}
+
+	// Rows are aligned with each other and centered as a block
+	rowWidth := m.Width - 16
+	noteWidth := 32
+	urlWidth := rowWidth - noteWidth - 22
+	if urlWidth < 20 {
+		urlWidth = 20
+	}
+	rowStyle := lipgloss.NewStyle().Width(rowWidth).Align(lipgloss.Left)
+	for i := start; i < end; i++ {
+		e := m.ImportEntries[i]
+		mark := "+"
+		if e.Verdict == urllist.Invalid || (e.Verdict == urllist.Duplicate && !m.ImportDuplicates) {
+			mark = "-"
+		}
+		note := e.Reason
+		if e.Out != "" {
+			note = strings.TrimSpace("as " + e.Out + " " + note)
+		}
+		line := fmt.Sprintf("%s %4d  %-9s  %-*s  %s", mark, e.Line, e.Verdict, urlWidth, truncateString(e.URL, urlWidth), truncateString(note, noteWidth))
+
+		itemStyle := rowStyle
+		if i == m.ImportSelected {
+			itemStyle = rowStyle.Copy().Inherit(selectedItemStyle)
+		}
+		s.WriteString(centerContainer.Render(itemStyle.Render(line)) + "\n")
+	}
+	if len(m.ImportEntries) > importPreviewRows {
+		s.WriteString(centerContainer.Render(menuItemStyle.Render(fmt.Sprintf("Showing %d-%d of %d", start+1, end, len(m.ImportEntries)))) + "\n")
+	}
+
+	duplicates := "skip"
+	if m.ImportDuplicates {
+		duplicates = "add"
+	}
+	s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render("Duplicates: "+duplicates)))
+	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Scroll   [ d ] Toggle Duplicates   [ Enter ] Import   [ Esc ] Cancel"))
 
 	return s.String()
 }
@@ -211,6 +315,11 @@ func renderDownloadListTab(m Model) string {
 		s.WriteString(centerContainer.Render(msgStyle.Render(m.DownloadListMessage)) + "\n\n")
 	}
 
+	if m.MoveQueueMode {
+		s.WriteString(renderMoveQueuePicker(m))
+		return s.String()
+	}
+
 	if len(m.Downloads) == 0 {
 		s.WriteString(centerContainer.Render(menuItemStyle.Render("No downloads yet. Press '1' to switch to Add Download tab.")))
 	} else {
@@ -245,6 +354,12 @@ func renderDownloadListTab(m Model) string {
 				rowStyle = selectedRowStyle.Copy()
 			}
 
+			// Format index, flagging marked downloads
+			index := fmt.Sprintf("%d", i+1)
+			if m.Marked[d.ID] {
+				index = "*" + index
+			}
+
 			// Format progress
 			progress := fmt.Sprintf("%.1f%%", d.Progress)
 
@@ -260,8 +375,8 @@ func renderDownloadListTab(m Model) string {
 				width   int
 			}{
 				{d.TargetPath, 30},
-				{fmt.Sprintf("%d", i+1), 5},
-				{d.Status, 15},
+				{index, 5},
+				{string(d.Status), 15},
 				{d.Queue, 15},
 				{progress, 10},
 				{speed, 10},
@@ -298,7 +413,45 @@ func renderDownloadListTab(m Model) string {
 	}
 
 	// Help text
-	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ p ] Pause   [ r ] Resume   [ c ] Cancel   [ y ] Retry   [ d ] Delete"))
+	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ p ] Pause   [ r ] Resume   [ c ] Cancel   [ y ] Retry   [ d ] Delete   [ Space ] Mark   [ m ] Move"))
+
+	return s.String()
+}
+
+// renderMoveQueuePicker renders the target queue selection for moving downloads
+func renderMoveQueuePicker(m Model) string {
+	var s strings.Builder
+
+	centerContainer := centerStyle.Copy().Width(m.Width - 8)
+
+	count := 0
+	for i := range m.Downloads {
+		if m.Marked[m.Downloads[i].ID] {
+			count++
+		}
+	}
+	if count == 0 {
+		count = 1
+	}
+
+	s.WriteString(centerContainer.Render(menuHeaderStyle.Render(fmt.Sprintf("Move %d Download(s) to Queue", count))))
+	s.WriteString("\n\n")
+
+	for i, q := range m.Config.Queues {
+		itemStyle := menuItemStyle
+		if i == m.QueueSelected {
+			itemStyle = selectedItemStyle
+		}
+		s.WriteString(centerContainer.Render(itemStyle.Render(fmt.Sprintf("%s (%s)", q.Name, q.Path))) + "\n")
+	}
+
+	relocate := "no"
+	if m.MoveRelocate {
+		relocate = "yes"
+	}
+	s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render("Move files to queue path: "+relocate)))
+
+	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ f ] Toggle File Move   [ Enter ] Move   [ Esc ] Cancel"))
 
 	return s.String()
 }
@@ -425,7 +578,7 @@ func renderQueueListTab(m Model) string {
 				// Count active downloads
 				activeCount := 0
 				for _, d := range m.Downloads {
-					if d.Queue == q.Name && d.Status == "downloading" {
+					if d.Queue == q.Name && d.Status.IsActive() {
 						activeCount++
 					}
 				}
@@ -481,6 +634,125 @@ func renderQueueListTab(m Model) string {
 	return s.String()
 }
 
+func renderHistoryTab(m Model) string {
+	var s strings.Builder
+
+	// Center all content
+	centerContainer := centerStyle.Copy().Width(m.Width - 8)
+
+	s.WriteString(centerContainer.Render(menuHeaderStyle.Render("Download History")))
+	s.WriteString("\n\n")
+
+	// Search and date filter
+	search := m.HistoryQuery
+	if m.HistorySearchMode {
+		search += "_"
+	}
+	filterLine := fmt.Sprintf("Search: %s   Date: %s", urlStyle.Render(search), historyRanges[m.HistoryRange].Label)
+	s.WriteString(centerContainer.Render(menuItemStyle.Render(filterLine)))
+	s.WriteString("\n")
+
+	// Statistics of the matching entries
+	stats := history.Summarize(m.HistoryEntries)
+	statsLine := fmt.Sprintf("%d downloads: %d completed, %d failed, %d cancelled   %s downloaded   avg %s",
+		stats.Count, stats.Completed, stats.Failed, stats.Cancelled, formatSize(stats.TotalBytes), formatSpeed(stats.AvgSpeed))
+	s.WriteString(centerContainer.Render(menuItemStyle.Render(statsLine)))
+	s.WriteString("\n\n")
+
+	if len(m.HistoryEntries) == 0 {
+		s.WriteString(centerContainer.Render(menuItemStyle.Render("No finished downloads match.")))
+	} else {
+		headers := []struct {
+			content string
+			width   int
+		}{
+			{"Path", 30},
+			{"Outcome", 11},
+			{"Size", 10},
+			{"Duration", 10},
+			{"Avg Speed", 12},
+			{"Finished", 20},
+			{"SHA-256", 14},
+		}
+
+		var headerCells []string
+		for _, header := range headers {
+			headerCells = append(headerCells, headerStyle.Width(header.width).Render(header.content))
+		}
+		headerRow := lipgloss.JoinHorizontal(lipgloss.Center, headerCells...)
+
+		// Only render the rows around the selection
+		const maxRows = 15
+		first := 0
+		if m.HistorySelected >= maxRows {
+			first = m.HistorySelected - maxRows + 1
+		}
+		last := first + maxRows
+		if last > len(m.HistoryEntries) {
+			last = len(m.HistoryEntries)
+		}
+
+		var rows []string
+		for i := first; i < last; i++ {
+			e := m.HistoryEntries[i]
+			rowStyle := normalRowStyle.Copy()
+			if i == m.HistorySelected {
+				rowStyle = selectedRowStyle.Copy()
+			}
+
+			checksum := "-"
+			if e.Checksum != "" {
+				checksum = e.Checksum[:12]
+			}
+
+			cells := []struct {
+				content string
+				width   int
+			}{
+				{truncateString(e.Path, 28), 30},
+				{e.Outcome, 11},
+				{formatSize(e.Size), 10},
+				{e.Duration.Round(time.Second).String(), 10},
+				{formatSpeed(e.AvgSpeed), 12},
+				{e.FinishedAt.Format("2006-01-02 15:04:05"), 20},
+				{checksum, 14},
+			}
+
+			var rowCells []string
+			for _, cell := range cells {
+				rowCells = append(rowCells, rowStyle.Width(cell.width).Render(cell.content))
+			}
+			rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Center, rowCells...))
+		}
+
+		table := tableStyle.Render(
+			lipgloss.JoinVertical(lipgloss.Left,
+				headerRow,
+				lipgloss.JoinVertical(lipgloss.Left, rows...),
+			),
+		)
+		s.WriteString(centerContainer.Render(table))
+
+		// Details of the selected entry
+		if m.HistorySelected < len(m.HistoryEntries) {
+			e := m.HistoryEntries[m.HistorySelected]
+			s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render("URL: "+urlStyle.Render(e.URL))))
+			if e.Error != "" {
+				s.WriteString("\n" + centerContainer.Render(errorStyle.Render(e.Error)))
+			}
+		}
+	}
+
+	// Help text
+	if m.HistorySearchMode {
+		s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ Enter ] Done   [ Esc ] Clear Search"))
+	} else {
+		s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ / ] Search   [ f ] Date Filter   [ r ] Re-download   [ Esc ] Clear Search"))
+	}
+
+	return s.String()
+}
+
 func renderSettingsTab(m Model) string {
 	var s strings.Builder
 
@@ -498,6 +770,29 @@ func renderSettingsTab(m Model) string {
 	s.WriteString(centerStyle.Render("Current Theme: " + m.CurrentTheme))
 	s.WriteString("\n" + centerStyle.Render("Press 't' to cycle through available themes"))
 
+	// Profiles
+	s.WriteString("\n\n" + menuHeaderStyle.Copy().Width(m.Width-8).Align(lipgloss.Center).Render("Profiles"))
+	s.WriteString("\n")
+	profileStyle := lipgloss.NewStyle().Width(40).PaddingLeft(4).Align(lipgloss.Left)
+	for i, p := range m.Profiles {
+		line := "  " + p
+		if p == m.Profile {
+			line += " (active)"
+		}
+		itemStyle := profileStyle
+		if i == m.ProfileSelected {
+			line = "> " + line[2:]
+			itemStyle = profileStyle.Copy().Inherit(selectedItemStyle)
+		}
+		s.WriteString(centerStyle.Render(itemStyle.Render(line)) + "\n")
+	}
+	if m.ProfileInputMode {
+		s.WriteString(centerStyle.Render("New profile: "+m.InputProfile+"█") + "\n")
+		s.WriteString(centerStyle.Render("Enter: Create and switch • Esc: Cancel"))
+	} else {
+		s.WriteString(centerStyle.Render("↑/↓: Select • Enter: Switch profile • n: New profile"))
+	}
+
 	// Keyboard shortcuts
 	s.WriteString("\n\n" + menuHeaderStyle.Copy().Width(m.Width-8).Align(lipgloss.Center).Render("Keyboard Shortcuts"))
 	s.WriteString("\n")
@@ -514,16 +809,23 @@ func renderSettingsTab(m Model) string {
 		Align(lipgloss.Center)
 
 	shortcuts := []string{
-		"1-4:             Switch tabs",
+		"1-5:             Switch tabs",
 		"↑/↓ or j/k:      Navigate lists",
 		"Enter:           Confirm/Submit",
 		"Esc:             Cancel/Back",
+		"i:               Import URL list (Add tab)",
 		"p:               Pause download",
 		"r:               Resume download",
 		"c:               Cancel download",
-		"n:               New queue",
+		"Space:           Mark download",
+		"m:               Move download(s) to queue",
+		"n:               New queue / profile",
 		"e:               Edit queue",
 		"d:               Delete queue",
+		"/:               Search history",
+		"f:               Cycle history date filter",
+		"r:               Re-download (History tab)",
+		"Enter:           Switch profile (Settings tab)",
 		"t:               Change theme",
 		"q:               Quit application",
 	}
@@ -559,6 +861,18 @@ func formatSpeed(speed int64) string {
 	}
 }
 
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	} else if size < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	} else if size < 1024*1024*1024 {
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	} else {
+		return fmt.Sprintf("%.1f GB", float64(size)/(1024*1024*1024))
+	}
+}
+
 // Helper function to center text in a given width
 func centerText(text string, width int) string {
 	if width <= len(text) {
@@ -0,0 +1,237 @@
+// Package urlglob expands URL patterns like curl does: numeric and letter
+// ranges in brackets, like [001-120] or [a-z:2], and sets in braces, like
+// {a,b,c}, into the URLs they stand for.
+package urlglob
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultLimit is how many URLs a pattern may expand to unless the caller
+// asks for another limit, so a typo can't queue millions of downloads
+const DefaultLimit = 1000
+
+// special are the characters a backslash makes literal
+const special = `[]{},\`
+
+// ErrTooMany is wrapped by the error of a pattern that expands to more URLs
+// than allowed
+var ErrTooMany = errors.New("pattern expands to too many URLs")
+
+// Expand returns the URLs a pattern stands for, in the order curl fetches
+// them: the last range or set varies fastest. A backslash makes the next
+// bracket, brace, comma or backslash literal, and a bracketed IPv6 host is
+// left as it is. A pattern that would expand to more than limit URLs fails.
+func Expand(pattern string, limit int) ([]string, error) {
+	tooMany := fmt.Errorf("%w: more than %d", ErrTooMany, limit)
+	globs, err := parse(pattern, limit)
+	if errors.Is(err, ErrTooMany) {
+		return nil, tooMany
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	total := 1
+	for _, g := range globs {
+		total *= len(g)
+		if total > limit {
+			return nil, tooMany
+		}
+	}
+
+	urls := make([]string, 0, total)
+	index := make([]int, len(globs))
+	for {
+		var b strings.Builder
+		for i, g := range globs {
+			b.WriteString(g[index[i]])
+		}
+		urls = append(urls, b.String())
+
+		// Count up like an odometer, the last glob fastest
+		i := len(globs) - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < len(globs[i]) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			return urls, nil
+		}
+	}
+}
+
+// parse splits a pattern into globs, each the list of strings it stands
+// for. Literal text is a glob of one string. No glob has more than limit
+// strings.
+func parse(pattern string, limit int) ([][]string, error) {
+	var globs [][]string
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			globs = append(globs, []string{literal.String()})
+			literal.Reset()
+		}
+	}
+
+	// The host of an IPv6 URL is in brackets, which isn't a range
+	if scheme := strings.Index(pattern, "://"); scheme >= 0 && strings.HasPrefix(pattern[scheme+3:], "[") {
+		if end := strings.IndexByte(pattern[scheme+3:], ']'); end >= 0 {
+			literal.WriteString(pattern[:scheme+3+end+1])
+			pattern = pattern[scheme+3+end+1:]
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '\\':
+			if i+1 < len(pattern) && strings.IndexByte(special, pattern[i+1]) >= 0 {
+				i++
+			}
+			literal.WriteByte(pattern[i])
+		case '[', '{':
+			closer := byte(']')
+			if c == '{' {
+				closer = '}'
+			}
+			end := closing(pattern, i+1, closer)
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched %c at position %d", c, i+1)
+			}
+			body := pattern[i+1 : end]
+			var glob []string
+			var err error
+			if c == '[' {
+				glob, err = parseRange(body, limit)
+			} else {
+				glob, err = parseSet(body)
+			}
+			if errors.Is(err, ErrTooMany) {
+				return nil, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bad %c%s%c at position %d: %w", c, body, closer, i+1, err)
+			}
+			flush()
+			globs = append(globs, glob)
+			i = end
+		case ']', '}':
+			return nil, fmt.Errorf("unmatched %c at position %d", c, i+1)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return globs, nil
+}
+
+// closing returns the index of the unescaped closer from start on, or -1.
+// Globs don't nest, so another opening bracket or brace ends the search.
+func closing(pattern string, start int, closer byte) int {
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case closer:
+			return i
+		case '[', '{':
+			return -1
+		}
+	}
+	return -1
+}
+
+// parseSet parses the body of {a,b,c}
+func parseSet(body string) ([]string, error) {
+	if body == "" {
+		return nil, errors.New("empty set")
+	}
+	var set []string
+	var item strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; c {
+		case '\\':
+			if i+1 < len(body) {
+				i++
+			}
+			item.WriteByte(body[i])
+		case ',':
+			set = append(set, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(c)
+		}
+	}
+	return append(set, item.String()), nil
+}
+
+// parseRange parses the body of [1-10], [001-120:5] or [a-z]. A start with
+// leading zeros pads the numbers to its width. Ranges of more than limit
+// values fail with ErrTooMany.
+func parseRange(body string, limit int) ([]string, error) {
+	bounds, stepText, hasStep := strings.Cut(body, ":")
+	first, last, ok := strings.Cut(bounds, "-")
+	if !ok || first == "" || last == "" {
+		return nil, errors.New("want a range like 1-10, 001-120:5 or a-z")
+	}
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+			return nil, fmt.Errorf("step %q is not a positive number", stepText)
+		}
+	}
+
+	// Letter ranges
+	if len(first) == 1 && len(last) == 1 && isLetter(first[0]) && isLetter(last[0]) {
+		from, to := first[0], last[0]
+		if isLower(from) != isLower(to) || from > to {
+			return nil, fmt.Errorf("%s doesn't count up to %s", first, last)
+		}
+		var letters []string
+		for c := int(from); c <= int(to); c += step {
+			letters = append(letters, string(rune(c)))
+		}
+		return letters, nil
+	}
+
+	from, err := strconv.ParseUint(first, 10, 63)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number or letter", first)
+	}
+	to, err := strconv.ParseUint(last, 10, 63)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number or letter", last)
+	}
+	if from > to {
+		return nil, fmt.Errorf("%s doesn't count up to %s", first, last)
+	}
+	width := 0
+	if len(first) > 1 && first[0] == '0' {
+		width = len(first)
+	}
+
+	if (to-from)/uint64(step) >= uint64(limit) {
+		return nil, ErrTooMany
+	}
+	var numbers []string
+	for n := from; n <= to; n += uint64(step) {
+		numbers = append(numbers, fmt.Sprintf("%0*d", width, n))
+	}
+	return numbers, nil
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isLower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
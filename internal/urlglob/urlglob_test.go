@@ -0,0 +1,125 @@
+package urlglob
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{"no glob", "http://example.com/a.bin", []string{"http://example.com/a.bin"}},
+		{"numbers", "http://example.com/[1-3].jpg", []string{
+			"http://example.com/1.jpg", "http://example.com/2.jpg", "http://example.com/3.jpg"}},
+		{"zero padding", "http://example.com/[08-11].jpg", []string{
+			"http://example.com/08.jpg", "http://example.com/09.jpg", "http://example.com/10.jpg", "http://example.com/11.jpg"}},
+		{"padding wider than the end", "http://example.com/[001-3]", []string{
+			"http://example.com/001", "http://example.com/002", "http://example.com/003"}},
+		{"single number", "http://example.com/[5-5]", []string{"http://example.com/5"}},
+		{"step", "http://example.com/[1-10:2]", []string{
+			"http://example.com/1", "http://example.com/3", "http://example.com/5", "http://example.com/7", "http://example.com/9"}},
+		{"step past the end", "http://example.com/[0-10:5]", []string{
+			"http://example.com/0", "http://example.com/5", "http://example.com/10"}},
+		{"letters", "http://example.com/[a-d]", []string{
+			"http://example.com/a", "http://example.com/b", "http://example.com/c", "http://example.com/d"}},
+		{"upper case letters with a step", "http://example.com/[A-E:2]", []string{
+			"http://example.com/A", "http://example.com/C", "http://example.com/E"}},
+		{"set", "http://{www,cdn}.example.com/a.bin", []string{
+			"http://www.example.com/a.bin", "http://cdn.example.com/a.bin"}},
+		{"set with an empty item", "http://example.com/a{,.bak}", []string{
+			"http://example.com/a", "http://example.com/a.bak"}},
+		{"last glob varies fastest", "http://example.com/{x,y}/[1-2]", []string{
+			"http://example.com/x/1", "http://example.com/x/2", "http://example.com/y/1", "http://example.com/y/2"}},
+		{"escaped brackets", `http://example.com/\[1-2\].jpg`, []string{"http://example.com/[1-2].jpg"}},
+		{"escaped braces and comma", `http://example.com/{a\,b,\{c\}}`, []string{
+			"http://example.com/a,b", "http://example.com/{c}"}},
+		{"escaped backslash", `http://example.com/a\\b`, []string{`http://example.com/a\b`}},
+		{"other escapes stay", `http://example.com/a\nb`, []string{`http://example.com/a\nb`}},
+		{"IPv6 host", "http://[::1]/file.bin", []string{"http://[::1]/file.bin"}},
+		{"IPv6 host with a port and a range", "http://[2001:db8::1]:8080/[1-2].bin", []string{
+			"http://[2001:db8::1]:8080/1.bin", "http://[2001:db8::1]:8080/2.bin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.pattern, DefaultLimit)
+			if err != nil {
+				t.Fatalf("Expand(%q) returned %v", tt.pattern, err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Expand(%q) =\n%v\nwant\n%v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    string // Part of the error
+	}{
+		{"reversed numbers", "http://example.com/[10-1]", "doesn't count up"},
+		{"reversed letters", "http://example.com/[z-a]", "doesn't count up"},
+		{"mixed case letters", "http://example.com/[a-Z]", "doesn't count up"},
+		{"empty range", "http://example.com/[]", "want a range"},
+		{"range without an end", "http://example.com/[1-]", "want a range"},
+		{"range without a dash", "http://example.com/[12]", "want a range"},
+		{"not a number", "http://example.com/[1-x2]", "not a number"},
+		{"zero step", "http://example.com/[1-10:0]", "not a positive number"},
+		{"negative step", "http://example.com/[1-10:-2]", "not a positive number"},
+		{"empty set", "http://example.com/{}", "empty set"},
+		{"unmatched bracket", "http://example.com/[1-2", "unmatched ["},
+		{"unmatched brace", "http://example.com/{a,b", "unmatched {"},
+		{"stray closer", "http://example.com/a]b", "unmatched ]"},
+		{"nested globs", "http://example.com/{a,[1-2]}", "unmatched {"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.pattern, DefaultLimit)
+			if err == nil {
+				t.Fatalf("Expand(%q) = %v, want an error", tt.pattern, got)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expand(%q) returned %q, want it to mention %q", tt.pattern, err, tt.want)
+			}
+			if errors.Is(err, ErrTooMany) {
+				t.Errorf("Expand(%q) returned %v, which isn't about the limit", tt.pattern, err)
+			}
+		})
+	}
+}
+
+func TestExpandLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		limit   int
+		want    int // URLs expected, 0 for ErrTooMany
+	}{
+		{"at the limit", "http://example.com/[1-10]", 10, 10},
+		{"one range too large", "http://example.com/[1-11]", 10, 0},
+		{"huge range", "http://example.com/[1-999999999999]", DefaultLimit, 0},
+		{"steps keep a range small", "http://example.com/[1-100:10]", 10, 10},
+		{"product of globs at the limit", "http://example.com/[1-5]/{a,b}", 10, 10},
+		{"product of globs too large", "http://example.com/[1-5]/{a,b,c}", 10, 0},
+		{"letters count too", "http://example.com/[a-z]", 25, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.pattern, tt.limit)
+			if tt.want == 0 {
+				if !errors.Is(err, ErrTooMany) {
+					t.Fatalf("Expand(%q, %d) returned %d URLs and %v, want %v", tt.pattern, tt.limit, len(got), err, ErrTooMany)
+				}
+				return
+			}
+			if err != nil || len(got) != tt.want {
+				t.Fatalf("Expand(%q, %d) returned %d URLs and %v, want %d", tt.pattern, tt.limit, len(got), err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+// Package urllist reads lists of URLs to download: plain lists with one URL
+// per line, the files wget takes with -i, and the input files of aria2, in
+// which a URL line may be followed by indented options.
+package urllist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/queue"
+)
+
+// maxLine bounds the length of a line, so a binary file isn't read whole
+const maxLine = 1 << 20
+
+// Verdict tells whether an entry of a list should be added
+type Verdict string
+
+const (
+	New       Verdict = "new"       // The entry can be added
+	Duplicate Verdict = "duplicate" // The URL is already downloaded or earlier in the list
+	Invalid   Verdict = "invalid"   // The entry can't be added
+)
+
+// Entry is a download read from a list
+type Entry struct {
+	Line    int      `json:"line"` // Line of the URL, counting from 1
+	URL     string   `json:"url"`
+	Dir     string   `json:"dir,omitempty"`     // Directory to save in, from an aria2 dir option
+	Out     string   `json:"out,omitempty"`     // Name to save as, from an aria2 out option
+	Headers []string `json:"headers,omitempty"` // Request headers from aria2 header options
+	Ignored []string `json:"ignored,omitempty"` // aria2 options that don't apply, like mirrors
+	Verdict Verdict  `json:"verdict"`
+	Reason  string   `json:"reason,omitempty"` // Why the entry is a duplicate or invalid
+}
+
+// AddOptions returns the options to add the entry to queueName with. Like
+// aria2, a relative dir is taken relative to the working directory.
+func (e Entry) AddOptions(queueName string) (queue.AddOptions, error) {
+	opts := queue.AddOptions{Queue: queueName, Filename: e.Out, Headers: e.Headers}
+	if e.Dir != "" {
+		dir, err := filepath.Abs(e.Dir)
+		if err != nil {
+			return opts, err
+		}
+		opts.Dir = dir
+	}
+	return opts, nil
+}
+
+// Parse reads a list. Blank lines and lines starting with # are skipped.
+// URLs in known, like those of the existing downloads, are marked as
+// duplicates, as are URLs that appear earlier in the list. Only reading r
+// fails; entries that can't be added are marked Invalid.
+func Parse(r io.Reader, known []string) ([]Entry, error) {
+	seen := make(map[string]string, len(known))
+	for _, u := range known {
+		seen[u] = "already in the downloads"
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Options are indented under their URL; an indented URL starts a
+		// new entry, so indented plain lists read as expected
+		indented := trimmed != strings.TrimRightFunc(text, unicode.IsSpace)
+		if indented && len(entries) > 0 && (isOption(trimmed) || !strings.Contains(trimmed, "://")) {
+			entries[len(entries)-1].option(trimmed)
+			continue
+		}
+
+		// aria2 separates mirrors of the same file with tabs
+		rawURL, mirrors, _ := strings.Cut(trimmed, "\t")
+		e := Entry{Line: line, URL: strings.TrimSpace(rawURL), Verdict: New}
+		if n := len(strings.Fields(mirrors)); n > 0 {
+			e.Ignored = append(e.Ignored, fmt.Sprintf("%d mirror(s)", n))
+		}
+		if err := checkURL(e.URL); err != nil {
+			e.invalid(err.Error())
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if e.Verdict != New {
+			continue
+		}
+		if reason, ok := seen[e.URL]; ok {
+			e.Verdict, e.Reason = Duplicate, reason
+			continue
+		}
+		seen[e.URL] = fmt.Sprintf("same URL as line %d", e.Line)
+	}
+	return entries, nil
+}
+
+// isOption tells whether a line starts with the name of an aria2 option
+// and =, rather than a URL
+func isOption(text string) bool {
+	name, _, ok := strings.Cut(text, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLower(r) && !unicode.IsDigit(r) && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// option applies an aria2 option line to the entry
+func (e *Entry) option(text string) {
+	key, value, ok := strings.Cut(text, "=")
+	if !ok {
+		e.invalid(fmt.Sprintf("option %q is not of the form name=value", text))
+		return
+	}
+	switch key = strings.TrimSpace(key); key {
+	case "dir":
+		e.Dir = value
+	case "out":
+		if err := queue.CheckFilename(value); err != nil {
+			e.invalid(err.Error())
+			return
+		}
+		e.Out = value
+	case "header":
+		if _, _, err := downloader.ParseHeader(value); err != nil {
+			e.invalid(err.Error())
+			return
+		}
+		e.Headers = append(e.Headers, value)
+	default:
+		e.Ignored = append(e.Ignored, key)
+	}
+}
+
+// invalid marks the entry as invalid, keeping the first reason
+func (e *Entry) invalid(reason string) {
+	if e.Verdict != Invalid {
+		e.Verdict, e.Reason = Invalid, reason
+	}
+}
+
+// checkURL reports why a URL can't be downloaded, like the queue manager
+// would when it is added
+func checkURL(rawURL string) error {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return errors.New("not a URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported protocol %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("no host")
+	}
+	return nil
+}
+
+// Count returns how many entries have each verdict
+func Count(entries []Entry) map[Verdict]int {
+	counts := map[Verdict]int{}
+	for _, e := range entries {
+		counts[e.Verdict]++
+	}
+	return counts
+}
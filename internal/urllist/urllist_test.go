@@ -0,0 +1,174 @@
+package urllist
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parse parses list with no known URLs or fails the test
+func parse(t *testing.T, list string, known ...string) []Entry {
+	t.Helper()
+	entries, err := Parse(strings.NewReader(list), known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// describe sums up an entry for comparing against expectations
+func describe(e Entry) string {
+	s := fmt.Sprintf("%d %s %s", e.Line, e.Verdict, e.URL)
+	if e.Dir != "" {
+		s += " dir=" + e.Dir
+	}
+	if e.Out != "" {
+		s += " out=" + e.Out
+	}
+	for _, h := range e.Headers {
+		s += " header=" + h
+	}
+	if len(e.Ignored) > 0 {
+		s += " ignored=" + strings.Join(e.Ignored, ",")
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{
+			name: "plain",
+			list: "http://example.com/a.bin\nhttps://example.com/b.bin\n",
+			want: []string{"1 new http://example.com/a.bin", "2 new https://example.com/b.bin"},
+		},
+		{
+			name: "comments and blank lines",
+			list: "# downloads\n\nhttp://example.com/a.bin\n   \n  # indented comment\nhttp://example.com/b.bin",
+			want: []string{"3 new http://example.com/a.bin", "6 new http://example.com/b.bin"},
+		},
+		{
+			name: "wget list with CRLF and a byte order mark",
+			list: "\ufeffhttp://example.com/a.bin\r\nhttp://example.com/b.bin\r\n",
+			want: []string{"1 new http://example.com/a.bin", "2 new http://example.com/b.bin"},
+		},
+		{
+			name: "aria2 options under a URL",
+			list: "http://example.com/a.bin\n  dir=/tmp/x\n  out=a-renamed.bin\n\theader=X-Token: abc\n  split=4\nhttp://example.com/b.bin\n",
+			want: []string{
+				"1 new http://example.com/a.bin dir=/tmp/x out=a-renamed.bin header=X-Token: abc ignored=split",
+				"6 new http://example.com/b.bin",
+			},
+		},
+		{
+			name: "aria2 mirrors",
+			list: "http://example.com/a.bin\thttp://mirror.example.com/a.bin\thttp://other.example.com/a.bin\n",
+			want: []string{"1 new http://example.com/a.bin ignored=2 mirror(s)"},
+		},
+		{
+			name: "indented plain list",
+			list: "  http://example.com/a.bin\n  http://example.com/b.bin\n",
+			want: []string{"1 new http://example.com/a.bin", "2 new http://example.com/b.bin"},
+		},
+		{
+			name: "dots in an out name",
+			list: "http://example.com/a.bin\n  out=v1..2.tar.gz\n",
+			want: []string{"1 new http://example.com/a.bin out=v1..2.tar.gz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := parse(t, tt.list)
+			var got []string
+			for _, e := range entries {
+				got = append(got, describe(e))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	list := strings.Join([]string{
+		"http://example.com/a.bin",     // 1: new
+		"http://example.com/known.bin", // 2: already downloaded
+		"http://example.com/a.bin",     // 3: same as line 1
+		"ftp://example.com/c.bin",      // 4: wrong protocol
+		"not a url",                    // 5: not a URL
+		"http://example.com/d.bin",     // 6: bad out option
+		"  out=../d.bin",
+		"http://example.com/e.bin", // 8: bad header
+		"  header=no colon",
+		"http://example.com/f.bin", // 10: option without a value
+		"  this is not an option",
+		"http://",                  // 12: no host
+		"ftp://example.com/c.bin",  // 13: invalid entries aren't duplicates
+		"http://example.com/g.bin", // 14: new
+	}, "\n")
+	entries := parse(t, list, "http://example.com/known.bin")
+
+	want := []struct {
+		line    int
+		verdict Verdict
+		reason  string // Part of the reason
+	}{
+		{1, New, ""},
+		{2, Duplicate, "already in the downloads"},
+		{3, Duplicate, "same URL as line 1"},
+		{4, Invalid, `unsupported protocol "ftp"`},
+		{5, Invalid, "not a URL"},
+		{6, Invalid, "must not contain a path"},
+		{8, Invalid, "header"},
+		{10, Invalid, "name=value"},
+		{12, Invalid, "no host"},
+		{13, Invalid, "unsupported protocol"},
+		{14, New, ""},
+	}
+	if len(entries) != len(want) {
+		for _, e := range entries {
+			t.Log(describe(e), e.Reason)
+		}
+		t.Fatalf("%d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Line != w.line || e.Verdict != w.verdict || !strings.Contains(e.Reason, w.reason) || (w.reason == "") != (e.Reason == "") {
+			t.Errorf("entry %d: line %d, %s, %q; want line %d, %s, %q", i, e.Line, e.Verdict, e.Reason, w.line, w.verdict, w.reason)
+		}
+	}
+
+	counts := Count(entries)
+	if counts[New] != 2 || counts[Duplicate] != 2 || counts[Invalid] != 7 {
+		t.Errorf("counts = %v, want 2 new, 2 duplicates and 7 invalid", counts)
+	}
+}
+
+func TestEntryAddOptions(t *testing.T) {
+	e := Entry{URL: "http://example.com/a.bin", Dir: "relative", Out: "b.bin", Headers: []string{"X-A: 1"}}
+	opts, err := e.AddOptions("night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDir, _ := filepath.Abs("relative")
+	if opts.Queue != "night" || opts.Dir != wantDir || opts.Filename != "b.bin" || len(opts.Headers) != 1 {
+		t.Errorf("AddOptions = %+v", opts)
+	}
+
+	opts, err = Entry{URL: "http://example.com/a.bin"}.AddOptions("")
+	if err != nil || opts.Dir != "" {
+		t.Errorf("AddOptions without dir = %+v, %v; want no dir", opts, err)
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	list := "http://example.com/a.bin\n" + strings.Repeat("x", maxLine+1) + "\n"
+	if _, err := Parse(strings.NewReader(list), nil); err == nil {
+		t.Error("a line longer than the limit was read")
+	}
+}